@@ -7,6 +7,7 @@ import (
 	"github.com/jinzhu/gorm"
 	"regexp"
 	"strings"
+	"time"
 )
 
 // LoginHandlerFunc
@@ -26,12 +27,16 @@ type LoginHandlerResponse struct {
 }
 
 var (
-	TokenKey  = "Token"
-	LangKey   = "Lang"
-	Whitelist = []interface{}{
+	TokenKey        = "Token"
+	RefreshTokenKey = "RefreshToken"
+	LangKey         = "Lang"
+	Whitelist       = []interface{}{
 		"GET /",
 		"GET /favicon.ico",
 		"POST /login",
+		"POST /login/2fa",
+		"POST /token/refresh",
+		"GET /.well-known/jwks.json",
 		"GET /model/docs",
 		"GET /model/ws",
 		"GET /language",
@@ -41,8 +46,9 @@ var (
 		"GET /intl/messages",
 		regexp.MustCompile("GET /assets"),
 	}
-	ExpiresSeconds = 86400
-	loginHandler   = defaultLoginHandler
+	ExpiresSeconds        = 86400
+	RefreshExpiresSeconds = 7 * 86400
+	loginHandler          = defaultLoginHandler
 )
 
 const (
@@ -59,11 +65,15 @@ func (c *Context) InWhitelist() bool {
 		b, _ := v.(bool)
 		return b
 	}
-	var (
-		input  = fmt.Sprintf("%s %s", c.Request.Method, c.Request.URL.Path) // 格式为：GET /api/user
-		result bool
-	)
-	for _, item := range Whitelist {
+	result := c.matchRules(Whitelist)
+	c.Set(cacheKey, result)
+	return result
+}
+
+// matchRules 判断当前请求是否匹配规则列表，支持string和*regexp.Regexp
+func (c *Context) matchRules(rules []interface{}) (result bool) {
+	input := fmt.Sprintf("%s %s", c.Request.Method, c.Request.URL.Path) // 格式为：GET /api/user
+	for _, item := range rules {
 		if v, ok := item.(string); ok {
 			// 字符串忽略大小写
 			lowerInput := strings.ToLower(input)
@@ -91,8 +101,7 @@ func (c *Context) InWhitelist() bool {
 			}
 		}
 	}
-	c.Set(cacheKey, result)
-	return result
+	return
 }
 
 // AddWhitelist support string and *regexp.Regexp.
@@ -165,21 +174,38 @@ func (c *Context) DecodedContext() (sign *SignContext, err error) {
 		return v.(*SignContext), nil
 	}
 
+	// 签名请求
+	if c.IsSignedRequest() {
+		if sign, err = c.decodedSignedRequest(); err != nil {
+			return nil, err
+		}
+		c.Set(cacheKey, sign)
+		return
+	}
+
 	token := c.Token()
 	if token == "" {
 		return nil, ErrTokenNotFound
 	}
 	sign = &SignContext{Token: token, Lang: c.Lang()}
 
-	// 解析UID
-	secret, err := GetSignSecret(token)
+	// 解析UID（非对称签名令牌可配置为不检查吊销状态，此时无需查询密钥）
+	var (
+		secret     *SignSecret
+		asymmetric = IsAsymmetricToken(token)
+	)
+	if asymmetric && !GetJWTConfig().RevocationEnabled() {
+		secret, err = secretFromAsymmetricToken(token)
+	} else {
+		secret, err = GetSignSecret(token)
+	}
 	if secret == nil || err != nil {
 		return
 	}
 	sign.UID = secret.UID
 	sign.Username = secret.Username
 	// 验证令牌
-	if secret.Secret == "" {
+	if secret.Secret == "" && !asymmetric {
 		err = ErrSecretNotFound
 		return
 	}
@@ -187,6 +213,11 @@ func (c *Context) DecodedContext() (sign *SignContext, err error) {
 		err = ErrInvalidToken
 		return
 	}
+	// 密钥有效期可能早于令牌内的过期时间（如API Key轮换宽限期）
+	if secret.Exp > 0 && time.Now().Unix() > secret.Exp {
+		err = ErrInvalidToken
+		return
+	}
 	sign.Secret = secret
 	if secret.Type == "" {
 		secret.Type = AdminSignType
@@ -257,6 +288,9 @@ func EncodedToken(claims jwt.MapClaims, secret string) (signed string, err error
 
 // DecodedToken
 func DecodedToken(tokenString string, secret string) (jwt.MapClaims, error) {
+	if IsAsymmetricToken(tokenString) {
+		return DecodedAsymmetricToken(tokenString)
+	}
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
@@ -288,6 +322,10 @@ func Acc(handler ...LoginHandlerFunc) *Mod {
 		Models: []interface{}{
 			&SignSecret{},
 			&SignHistory{},
+			&TwoFactorAuth{},
+			&TwoFactorDevice{},
+			&SignApp{},
+			&SigningKey{},
 		},
 		Middleware: HandlersChain{
 			AuthMiddleware,
@@ -295,9 +333,41 @@ func Acc(handler ...LoginHandlerFunc) *Mod {
 		Routes: RoutesInfo{
 			LoginRoute,
 			LogoutRoute,
+			RefreshTokenRoute,
+			SessionsRoute,
+			RevokeSessionRoute,
+			RevokeOtherSessionsRoute,
+			RevokeAllSessionsRoute,
 			ValidRoute,
 			APIKeyRoute,
+			APIKeyListRoute,
+			APIKeyRevokeRoute,
+			APIKeyRotateRoute,
+			SignAppListRoute,
+			SignAppCreateRoute,
+			SignAppResetSecretRoute,
+			SignAppDisableRoute,
+			LDAPSyncRoute,
+			JWKSRoute,
+			JWKSRotateRoute,
 			WhitelistRoute,
+			TwoFactorLoginRoute,
+			TwoFactorStatusRoute,
+			TwoFactorEnrollRoute,
+			TwoFactorActivateRoute,
+			TwoFactorRecoveryCodesRoute,
+			TwoFactorDisableRoute,
+			TwoFactorResetRoute,
 		},
+		OnImport: initAccJobs,
+	}
+}
+
+// initAccJobs 按配置添加账号相关的定时任务
+func initAccJobs() error {
+	// 配置ldap:syncSpec后自动同步目录
+	if config := GetLDAPConfig(); config.Enabled() && config.SyncSpec != "" {
+		return AddLDAPSyncJob()
 	}
+	return nil
 }
@@ -0,0 +1,595 @@
+package kuu
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/guregu/null.v3"
+)
+
+// TwoFactorDeviceKey 受信设备令牌的Cookie/Header名称
+var TwoFactorDeviceKey = "TwoFactorDevice"
+
+var (
+	ErrTwoFactorInvalidCode = errors.New("invalid two-factor code")
+	ErrTwoFactorPreAuth     = errors.New("two-factor pre-auth token expired or invalid")
+	ErrTwoFactorNotEnabled  = errors.New("two-factor authentication is not enabled")
+)
+
+const (
+	twoFactorRecoveryCodeCount = 10
+	twoFactorMaxAttempts       = 5
+)
+
+// TwoFactorChallenge 登录第一步通过后、需要二次验证时的响应
+type TwoFactorChallenge struct {
+	TwoFactorRequired bool
+	EnrollRequired    bool
+	PreAuthToken      string
+	Exp               int64
+	Secret            string `json:",omitempty"`
+	URI               string `json:",omitempty"`
+}
+
+type twoFactorPreAuth struct {
+	UID      uint
+	Username string
+	Lang     string
+	Payload  jwt.MapClaims
+	Secret   string // 强制启用时待绑定的密钥
+	Exp      int64
+	Attempts int
+}
+
+func getTwoFactorPreAuthKey(token string) string {
+	return fmt.Sprintf("login_2fa_preauth_%s", token)
+}
+
+func twoFactorIssuer() string {
+	return C().DefaultGetString("twoFactor:issuer", GetAppName())
+}
+
+// GetTwoFactorAuth 查询用户的双因素认证配置，未配置时返回nil
+func GetTwoFactorAuth(tx *gorm.DB, uid uint) (*TwoFactorAuth, error) {
+	var auth TwoFactorAuth
+	if err := tx.Where(&TwoFactorAuth{UID: uid}).First(&auth).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &auth, nil
+}
+
+// IsTwoFactorRequiredByRoles 判断用户已分配的角色中是否有要求双因素认证的
+func IsTwoFactorRequiredByRoles(uid uint) (bool, error) {
+	user, err := GetUserWithRoles(uid)
+	if err != nil {
+		return false, err
+	}
+	for _, assign := range user.RoleAssigns {
+		if assign.Role != nil && assign.Role.RequireTwoFactor.Bool {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// NewTwoFactorChallenge 判断是否需要二次验证，需要时签发短期预认证令牌
+func NewTwoFactorChallenge(c *Context, resp *LoginHandlerResponse) (*TwoFactorChallenge, error) {
+	if resp.UID == 0 {
+		return nil, nil
+	}
+	IgnoreAuth()
+	defer IgnoreAuth(true)
+
+	auth, err := GetTwoFactorAuth(DB(), resp.UID)
+	if err != nil {
+		return nil, err
+	}
+	enabled := auth != nil && auth.Enabled.Bool
+	if !enabled {
+		required, err := IsTwoFactorRequiredByRoles(resp.UID)
+		if err != nil {
+			return nil, err
+		}
+		if !required {
+			return nil, nil
+		}
+	} else if isTrustedTwoFactorDevice(c, resp.UID) {
+		return nil, nil
+	}
+
+	preAuth := twoFactorPreAuth{
+		UID:      resp.UID,
+		Username: resp.Username,
+		Lang:     resp.Lang,
+		Payload:  resp.Payload,
+		Exp:      time.Now().Add(time.Second * time.Duration(C().DefaultGetInt("twoFactor:preAuthSeconds", 300))).Unix(),
+	}
+	challenge := &TwoFactorChallenge{
+		TwoFactorRequired: true,
+		PreAuthToken:      strings.ReplaceAll(uuid.NewV4().String(), "-", ""),
+		Exp:               preAuth.Exp,
+	}
+	if !enabled {
+		// 角色要求启用但用户尚未绑定：下发待绑定密钥，验证通过后自动启用
+		preAuth.Secret = GenTOTPSecret()
+		challenge.EnrollRequired = true
+		challenge.Secret = preAuth.Secret
+		challenge.URI = TOTPURI(twoFactorIssuer(), resp.Username, preAuth.Secret)
+	}
+	savePreAuth(challenge.PreAuthToken, &preAuth)
+	return challenge, nil
+}
+
+func savePreAuth(token string, preAuth *twoFactorPreAuth) {
+	SetCacheString(getTwoFactorPreAuthKey(token), JSONStringify(preAuth), time.Until(time.Unix(preAuth.Exp, 0)))
+}
+
+func loadPreAuth(token string) (*twoFactorPreAuth, error) {
+	if token == "" {
+		return nil, ErrTwoFactorPreAuth
+	}
+	raw := GetCacheString(getTwoFactorPreAuthKey(token))
+	if raw == "" {
+		return nil, ErrTwoFactorPreAuth
+	}
+	var preAuth twoFactorPreAuth
+	if err := JSONParse(raw, &preAuth); err != nil {
+		return nil, err
+	}
+	// 本地缓存不支持过期时间，需自行判断
+	if time.Now().Unix() > preAuth.Exp || preAuth.Attempts >= twoFactorMaxAttempts {
+		DelCache(getTwoFactorPreAuthKey(token))
+		return nil, ErrTwoFactorPreAuth
+	}
+	return &preAuth, nil
+}
+
+func isTrustedTwoFactorDevice(c *Context, uid uint) bool {
+	token := c.GetKey(TwoFactorDeviceKey)
+	if token == "" {
+		return false
+	}
+	var count int
+	DB().Model(&TwoFactorDevice{}).
+		Where(&TwoFactorDevice{UID: uid, Token: Sha1(token)}).
+		Where(fmt.Sprintf("%s > ?", DB().Dialect().Quote("exp")), time.Now().Unix()).
+		Count(&count)
+	return count > 0
+}
+
+func rememberTwoFactorDevice(c *Context, tx *gorm.DB, uid uint) error {
+	days := C().DefaultGetInt("twoFactor:rememberDays", 30)
+	if days <= 0 {
+		return nil
+	}
+	token := strings.ReplaceAll(uuid.NewV4().String(), "-", "")
+	device := TwoFactorDevice{
+		UID:       uid,
+		Token:     Sha1(token),
+		UserAgent: c.Request.UserAgent(),
+		IP:        c.TrustedClientIP(),
+		Exp:       time.Now().AddDate(0, 0, days).Unix(),
+	}
+	if err := tx.Create(&device).Error; err != nil {
+		return err
+	}
+	c.SetCookie(TwoFactorDeviceKey, token, days*86400, "/", "", false, true)
+	return nil
+}
+
+// Verify 校验动态口令或恢复码，校验通过后会持久化使用状态以防止重放
+func (a *TwoFactorAuth) Verify(tx *gorm.DB, code, recoveryCode string) error {
+	if code != "" {
+		step, ok := VerifyTOTP(a.Secret, code, time.Now())
+		if !ok || step <= a.LastUsedStep {
+			return ErrTwoFactorInvalidCode
+		}
+		a.LastUsedStep = step
+		return tx.Model(a).Update("last_used_step", step).Error
+	}
+	if recoveryCode != "" {
+		var hashes []string
+		if a.RecoveryCodes != "" {
+			if err := JSONParse(a.RecoveryCodes, &hashes); err != nil {
+				return err
+			}
+		}
+		recoveryCode = normalizeRecoveryCode(recoveryCode)
+		for i, hash := range hashes {
+			if CompareHashAndPassword(hash, recoveryCode) == nil {
+				hashes = append(hashes[:i], hashes[i+1:]...)
+				a.RecoveryCodes = JSONStringify(hashes)
+				return tx.Model(a).Update("recovery_codes", a.RecoveryCodes).Error
+			}
+		}
+	}
+	return ErrTwoFactorInvalidCode
+}
+
+// ResetRecoveryCodes 重新生成恢复码，返回明文（仅此一次可见）
+func (a *TwoFactorAuth) ResetRecoveryCodes(tx *gorm.DB) ([]string, error) {
+	var (
+		codes  []string
+		hashes []string
+	)
+	for i := 0; i < twoFactorRecoveryCodeCount; i++ {
+		code := genRecoveryCode()
+		hashed, err := GenerateFromPassword(normalizeRecoveryCode(code))
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, hashed)
+	}
+	a.RecoveryCodes = JSONStringify(hashes)
+	if err := tx.Model(a).Update("recovery_codes", a.RecoveryCodes).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (a *TwoFactorAuth) enable(tx *gorm.DB) ([]string, error) {
+	now := time.Now()
+	a.Enabled = null.BoolFrom(true)
+	a.EnabledAt = &now
+	if err := tx.Model(a).Updates(map[string]interface{}{
+		"enabled":    a.Enabled,
+		"enabled_at": a.EnabledAt,
+	}).Error; err != nil {
+		return nil, err
+	}
+	return a.ResetRecoveryCodes(tx)
+}
+
+func genRecoveryCode() string {
+	const chars = "abcdefghjkmnpqrstuvwxyz23456789"
+	var buf strings.Builder
+	for i := 0; i < 10; i++ {
+		if i == 5 {
+			buf.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(chars))))
+		if err != nil {
+			panic(err)
+		}
+		buf.WriteByte(chars[n.Int64()])
+	}
+	return buf.String()
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+// TwoFactorLoginRoute
+var TwoFactorLoginRoute = RouteInfo{
+	Name:   "双因素认证登录接口",
+	Method: http.MethodPost,
+	Path:   "/login/2fa",
+	IntlMessages: map[string]string{
+		"acc_2fa_failed":          "Two-factor authentication failed",
+		"acc_2fa_invalid_code":    "Invalid verification code.",
+		"acc_2fa_preauth_expired": "Verification has expired, please login again.",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		var body struct {
+			PreAuthToken   string `binding:"required"`
+			Code           string
+			RecoveryCode   string
+			RememberDevice bool
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			return c.STDErr(err, "acc_2fa_failed")
+		}
+		preAuth, err := loadPreAuth(body.PreAuthToken)
+		if err != nil {
+			return c.STDErr(err, "acc_2fa_preauth_expired")
+		}
+		var recoveryCodes []string
+		err = c.IgnoreAuth().WithTransaction(func(tx *gorm.DB) error {
+			auth, err := GetTwoFactorAuth(tx, preAuth.UID)
+			if err != nil {
+				return err
+			}
+			if preAuth.Secret != "" {
+				// 首次绑定
+				if _, ok := VerifyTOTP(preAuth.Secret, body.Code, time.Now()); !ok {
+					return ErrTwoFactorInvalidCode
+				}
+				if auth == nil {
+					auth = &TwoFactorAuth{UID: preAuth.UID}
+				}
+				auth.Secret = preAuth.Secret
+				auth.LastUsedStep = TOTPStep(time.Now())
+				if err := tx.Save(auth).Error; err != nil {
+					return err
+				}
+				if recoveryCodes, err = auth.enable(tx); err != nil {
+					return err
+				}
+			} else {
+				if auth == nil || !auth.Enabled.Bool {
+					return ErrTwoFactorNotEnabled
+				}
+				if err := auth.Verify(tx, body.Code, body.RecoveryCode); err != nil {
+					return err
+				}
+			}
+			if body.RememberDevice {
+				return rememberTwoFactorDevice(c, tx, preAuth.UID)
+			}
+			return nil
+		})
+		c.IgnoreAuth(true)
+		if err != nil {
+			if err == ErrTwoFactorInvalidCode {
+				preAuth.Attempts++
+				savePreAuth(body.PreAuthToken, preAuth)
+				return c.STDErr(err, "acc_2fa_invalid_code")
+			}
+			return c.STDErr(err, "acc_2fa_failed")
+		}
+		DelCache(getTwoFactorPreAuthKey(body.PreAuthToken))
+		reply := issueLoginToken(c, &LoginHandlerResponse{
+			UID:      preAuth.UID,
+			Username: preAuth.Username,
+			Lang:     preAuth.Lang,
+			Payload:  preAuth.Payload,
+		}, "acc_2fa_failed")
+		if len(recoveryCodes) > 0 && reply.Code == 0 {
+			// 首次绑定时在响应中返回恢复码（仅此一次），不写入令牌载荷
+			data := make(D, len(preAuth.Payload)+1)
+			for k, v := range preAuth.Payload {
+				data[k] = v
+			}
+			data["RecoveryCodes"] = recoveryCodes
+			reply.Data = data
+		}
+		return reply
+	},
+}
+
+// TwoFactorStatusRoute
+var TwoFactorStatusRoute = RouteInfo{
+	Name:   "查询双因素认证状态",
+	Method: http.MethodGet,
+	Path:   "/2fa",
+	IntlMessages: map[string]string{
+		"acc_2fa_failed": "Two-factor authentication failed",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		auth, err := GetTwoFactorAuth(c.IgnoreAuth().DB(), c.SignInfo.UID)
+		if err != nil {
+			return c.STDErr(err, "acc_2fa_failed")
+		}
+		required, err := IsTwoFactorRequiredByRoles(c.SignInfo.UID)
+		if err != nil {
+			return c.STDErr(err, "acc_2fa_failed")
+		}
+		ret := D{"Enabled": false, "RequiredByRoles": required}
+		if auth != nil {
+			var hashes []string
+			_ = JSONParse(auth.RecoveryCodes, &hashes)
+			ret["Enabled"] = auth.Enabled.Bool
+			ret["EnabledAt"] = auth.EnabledAt
+			ret["RecoveryCodesLeft"] = len(hashes)
+		}
+		return c.STD(ret)
+	},
+}
+
+// TwoFactorEnrollRoute
+var TwoFactorEnrollRoute = RouteInfo{
+	Name:   "生成双因素认证密钥",
+	Method: http.MethodPost,
+	Path:   "/2fa/enroll",
+	IntlMessages: map[string]string{
+		"acc_2fa_enroll_failed":   "Two-factor enrollment failed",
+		"acc_2fa_already_enabled": "Two-factor authentication is already enabled.",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		var secret string
+		err := c.IgnoreAuth().WithTransaction(func(tx *gorm.DB) error {
+			auth, err := GetTwoFactorAuth(tx, c.SignInfo.UID)
+			if err != nil {
+				return err
+			}
+			if auth != nil && auth.Enabled.Bool {
+				return NewIntlError(errors.New("two-factor authentication is already enabled"), "acc_2fa_already_enabled")
+			}
+			if auth == nil {
+				auth = &TwoFactorAuth{UID: c.SignInfo.UID}
+			}
+			secret = GenTOTPSecret()
+			auth.Secret = secret
+			auth.Enabled = null.BoolFrom(false)
+			return tx.Save(auth).Error
+		})
+		c.IgnoreAuth(true)
+		if err != nil {
+			return c.STDErr(err, "acc_2fa_enroll_failed")
+		}
+		return c.STD(D{
+			"Secret": secret,
+			"URI":    TOTPURI(twoFactorIssuer(), c.SignInfo.Username, secret),
+		})
+	},
+}
+
+// TwoFactorActivateRoute
+var TwoFactorActivateRoute = RouteInfo{
+	Name:   "启用双因素认证",
+	Method: http.MethodPost,
+	Path:   "/2fa/activate",
+	IntlMessages: map[string]string{
+		"acc_2fa_activate_failed": "Two-factor activation failed",
+		"acc_2fa_invalid_code":    "Invalid verification code.",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		var body struct {
+			Code string `binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			return c.STDErr(err, "acc_2fa_activate_failed")
+		}
+		var codes []string
+		err := c.IgnoreAuth().WithTransaction(func(tx *gorm.DB) error {
+			auth, err := GetTwoFactorAuth(tx, c.SignInfo.UID)
+			if err != nil {
+				return err
+			}
+			if auth == nil || auth.Secret == "" {
+				return ErrTwoFactorNotEnabled
+			}
+			if err := auth.Verify(tx, body.Code, ""); err != nil {
+				return err
+			}
+			codes, err = auth.enable(tx)
+			return err
+		})
+		c.IgnoreAuth(true)
+		if err != nil {
+			if err == ErrTwoFactorInvalidCode {
+				return c.STDErr(err, "acc_2fa_invalid_code")
+			}
+			return c.STDErr(err, "acc_2fa_activate_failed")
+		}
+		return c.STD(D{"RecoveryCodes": codes})
+	},
+}
+
+// TwoFactorRecoveryCodesRoute
+var TwoFactorRecoveryCodesRoute = RouteInfo{
+	Name:   "重新生成双因素认证恢复码",
+	Method: http.MethodPost,
+	Path:   "/2fa/recovery_codes",
+	IntlMessages: map[string]string{
+		"acc_2fa_failed":       "Two-factor authentication failed",
+		"acc_2fa_invalid_code": "Invalid verification code.",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		var body struct {
+			Code string `binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			return c.STDErr(err, "acc_2fa_failed")
+		}
+		var codes []string
+		err := c.IgnoreAuth().WithTransaction(func(tx *gorm.DB) error {
+			auth, err := GetTwoFactorAuth(tx, c.SignInfo.UID)
+			if err != nil {
+				return err
+			}
+			if auth == nil || !auth.Enabled.Bool {
+				return ErrTwoFactorNotEnabled
+			}
+			if err := auth.Verify(tx, body.Code, ""); err != nil {
+				return err
+			}
+			codes, err = auth.ResetRecoveryCodes(tx)
+			return err
+		})
+		c.IgnoreAuth(true)
+		if err != nil {
+			if err == ErrTwoFactorInvalidCode {
+				return c.STDErr(err, "acc_2fa_invalid_code")
+			}
+			return c.STDErr(err, "acc_2fa_failed")
+		}
+		return c.STD(D{"RecoveryCodes": codes})
+	},
+}
+
+// TwoFactorDisableRoute
+var TwoFactorDisableRoute = RouteInfo{
+	Name:   "停用双因素认证",
+	Method: http.MethodPost,
+	Path:   "/2fa/disable",
+	IntlMessages: map[string]string{
+		"acc_2fa_disable_failed":   "Failed to disable two-factor authentication",
+		"acc_2fa_invalid_code":     "Invalid verification code.",
+		"acc_2fa_required_by_role": "Two-factor authentication is required by your roles.",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		var body struct {
+			Code         string
+			RecoveryCode string
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			return c.STDErr(err, "acc_2fa_disable_failed")
+		}
+		if required, err := IsTwoFactorRequiredByRoles(c.SignInfo.UID); err != nil {
+			return c.STDErr(err, "acc_2fa_disable_failed")
+		} else if required {
+			return c.STDErr(errors.New("two-factor authentication is required by roles"), "acc_2fa_required_by_role")
+		}
+		err := c.IgnoreAuth().WithTransaction(func(tx *gorm.DB) error {
+			auth, err := GetTwoFactorAuth(tx, c.SignInfo.UID)
+			if err != nil {
+				return err
+			}
+			if auth == nil || !auth.Enabled.Bool {
+				return ErrTwoFactorNotEnabled
+			}
+			if err := auth.Verify(tx, body.Code, body.RecoveryCode); err != nil {
+				return err
+			}
+			return resetTwoFactor(tx, c.SignInfo.UID)
+		})
+		c.IgnoreAuth(true)
+		if err != nil {
+			if err == ErrTwoFactorInvalidCode {
+				return c.STDErr(err, "acc_2fa_invalid_code")
+			}
+			return c.STDErr(err, "acc_2fa_disable_failed")
+		}
+		c.SetCookie(TwoFactorDeviceKey, "", -1, "/", "", false, true)
+		return c.STDOK()
+	},
+}
+
+// TwoFactorResetRoute
+var TwoFactorResetRoute = RouteInfo{
+	Name:   "重置用户双因素认证（该接口仅限root调用）",
+	Method: http.MethodDelete,
+	Path:   "/2fa/:uid",
+	IntlMessages: map[string]string{
+		"acc_2fa_reset_failed":  "Failed to reset two-factor authentication",
+		"login_as_unauthorized": "Unauthorized operation",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		if c.SignInfo.UID != RootUID() {
+			return c.STDErr(fmt.Errorf("unauthorized operation: uid=%v", c.SignInfo.UID), "login_as_unauthorized")
+		}
+		uid := ParseID(c.Param("uid"))
+		if uid == 0 {
+			return c.STDErr(errors.New("uid is required"), "acc_2fa_reset_failed")
+		}
+		err := c.IgnoreAuth().WithTransaction(func(tx *gorm.DB) error {
+			return resetTwoFactor(tx, uid)
+		})
+		c.IgnoreAuth(true)
+		if err != nil {
+			return c.STDErr(err, "acc_2fa_reset_failed")
+		}
+		return c.STDOK()
+	},
+}
+
+func resetTwoFactor(tx *gorm.DB, uid uint) error {
+	if err := tx.Unscoped().Where(&TwoFactorAuth{UID: uid}).Delete(&TwoFactorAuth{}).Error; err != nil {
+		return err
+	}
+	return tx.Unscoped().Where(&TwoFactorDevice{UID: uid}).Delete(&TwoFactorDevice{}).Error
+}
@@ -0,0 +1,293 @@
+package kuu
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	ErrAPIKeyIPDenied = errors.New("api key is not allowed from this ip")
+	ErrAPIKeyCaller   = errors.New("api keys cannot be issued by an api key")
+)
+
+// isAPIKeyCaller 判断当前请求是否使用API Key认证，API Key不允许签发或轮换API Key，避免绕过权限范围
+func isAPIKeyCaller(c *Context) bool {
+	return c.SignInfo != nil && c.SignInfo.Secret != nil && c.SignInfo.Secret.IsAPIKey.Bool
+}
+
+// APIKeyScope API Key权限范围，各项为空时表示不限制（仍以所属用户的权限为上限）
+type APIKeyScope struct {
+	Permissions []string            `json:",omitempty"` // 允许的权限编码（菜单编码）
+	APIs        []APIPermissionItem `json:",omitempty"` // 允许访问的接口
+	AllowedIPs  []string            `json:",omitempty"` // 允许的来源IP，支持CIDR
+	OrgIDs      []uint              `json:",omitempty"` // 允许访问的组织
+}
+
+// IsEmpty
+func (s *APIKeyScope) IsEmpty() bool {
+	return s == nil || (len(s.Permissions) == 0 && len(s.APIs) == 0 && len(s.AllowedIPs) == 0 && len(s.OrgIDs) == 0)
+}
+
+// AllowIP 判断来源IP是否在允许列表中
+func (s *APIKeyScope) AllowIP(ip string) bool {
+	if s == nil || len(s.AllowedIPs) == 0 {
+		return true
+	}
+	parsed := net.ParseIP(strings.TrimSpace(ip))
+	if parsed == nil {
+		return false
+	}
+	for _, item := range s.AllowedIPs {
+		item = strings.TrimSpace(item)
+		if strings.Contains(item, "/") {
+			if _, ipNet, err := net.ParseCIDR(item); err == nil && ipNet.Contains(parsed) {
+				return true
+			}
+		} else if v := net.ParseIP(item); v != nil && v.Equal(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowAPI 判断接口是否在允许列表中
+func (s *APIKeyScope) AllowAPI(method, path string) bool {
+	if s == nil || len(s.APIs) == 0 {
+		return true
+	}
+	for _, item := range s.APIs {
+		var methodMatch bool
+		for _, allowedMethod := range item.Method {
+			if allowedMethod == "*" || strings.EqualFold(allowedMethod, method) {
+				methodMatch = true
+				break
+			}
+		}
+		if methodMatch {
+			if matched, _ := regexp.MatchString(item.Pattern, path); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GetAPIKeyScope 解析API Key权限范围，非API Key或未设置范围时返回nil
+func (s *SignSecret) GetAPIKeyScope() *APIKeyScope {
+	if s == nil || !s.IsAPIKey.Bool || s.Scope == "" {
+		return nil
+	}
+	var scope APIKeyScope
+	if err := JSONParse(s.Scope, &scope); err != nil {
+		ERROR(err)
+		return nil
+	}
+	return &scope
+}
+
+// applyAPIKeyScope 将权限与API Key权限范围取交集
+func (desc *PrivilegesDesc) applyAPIKeyScope(scope *APIKeyScope) {
+	if scope.IsEmpty() {
+		return
+	}
+	if len(scope.Permissions) > 0 {
+		allowed := make(map[string]bool)
+		for _, code := range scope.Permissions {
+			allowed[code] = true
+		}
+		permissionMap := make(map[string]int64)
+		permissions := make([]string, 0)
+		for code, exp := range desc.PermissionMap {
+			if allowed[code] {
+				permissionMap[code] = exp
+				permissions = append(permissions, code)
+			}
+		}
+		desc.PermissionMap = permissionMap
+		desc.Permissions = permissions
+	}
+	if len(scope.OrgIDs) > 0 {
+		allowed := make(map[uint]bool)
+		for _, id := range scope.OrgIDs {
+			allowed[id] = true
+		}
+		filter := func(ids *[]uint, m map[uint]Org) {
+			var filtered []uint
+			for _, id := range *ids {
+				if allowed[id] {
+					filtered = append(filtered, id)
+				} else {
+					delete(m, id)
+				}
+			}
+			*ids = filtered
+		}
+		filter(&desc.ReadableOrgIDs, desc.ReadableOrgIDMap)
+		filter(&desc.FullReadableOrgIDs, desc.FullReadableOrgIDMap)
+		filter(&desc.WritableOrgIDs, desc.WritableOrgIDMap)
+		filter(&desc.LoginableOrgIDs, desc.LoginableOrgIDMap)
+		filter(&desc.PersonalReadableOrgIDs, desc.PersonalReadableOrgIDMap)
+		filter(&desc.PersonalWritableOrgIDs, desc.PersonalWritableOrgIDMap)
+		if !allowed[desc.ActOrgID] {
+			desc.ActOrgID, desc.ActOrgCode, desc.ActOrgName = 0, "", ""
+			if len(desc.LoginableOrgIDs) > 0 {
+				org := desc.LoginableOrgIDMap[desc.LoginableOrgIDs[0]]
+				desc.ActOrgID, desc.ActOrgCode, desc.ActOrgName = org.ID, org.Code, org.Name
+			}
+		}
+	}
+}
+
+// APIKeyInfo API Key列表项
+type APIKeyInfo struct {
+	ID         uint
+	Desc       string
+	Token      string
+	Scope      *APIKeyScope
+	CreatedAt  time.Time
+	LastSeenAt int64
+	Exp        int64
+}
+
+func maskToken(token string) string {
+	if len(token) <= 12 {
+		return token
+	}
+	return fmt.Sprintf("%s...%s", token[:6], token[len(token)-6:])
+}
+
+// APIKeyListRoute
+var APIKeyListRoute = RouteInfo{
+	Name:   "查询API Key列表",
+	Method: http.MethodGet,
+	Path:   "/apikeys",
+	IntlMessages: map[string]string{
+		"apikeys_query_failed": "Failed to query API Keys",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		var (
+			secrets []SignSecret
+			db      = c.IgnoreAuth().DB()
+		)
+		defer c.IgnoreAuth(true)
+		if err := db.Where(&SignSecret{UID: c.SignInfo.UID, Method: SignMethodLogin}).
+			Where("is_api_key = ?", true).
+			Where(fmt.Sprintf("%s > ?", db.Dialect().Quote("exp")), time.Now().Unix()).
+			Order("created_at desc").Find(&secrets).Error; err != nil {
+			return c.STDErr(err, "apikeys_query_failed")
+		}
+		list := make([]APIKeyInfo, 0, len(secrets))
+		for _, item := range secrets {
+			list = append(list, APIKeyInfo{
+				ID:         item.ID,
+				Desc:       item.Desc,
+				Token:      maskToken(item.Token),
+				Scope:      item.GetAPIKeyScope(),
+				CreatedAt:  item.CreatedAt,
+				LastSeenAt: item.LastSeenAt,
+				Exp:        item.Exp,
+			})
+		}
+		return c.STD(list)
+	},
+}
+
+func getOwnAPIKey(c *Context, db *gorm.DB, id uint) (*SignSecret, error) {
+	var secret SignSecret
+	if err := db.Where("id = ?", id).Where("is_api_key = ?", true).First(&secret).Error; err != nil {
+		return nil, err
+	}
+	if secret.UID != c.SignInfo.UID && c.SignInfo.UID != RootUID() {
+		return nil, fmt.Errorf("unauthorized operation: uid=%v", c.SignInfo.UID)
+	}
+	return &secret, nil
+}
+
+// APIKeyRevokeRoute
+var APIKeyRevokeRoute = RouteInfo{
+	Name:   "吊销API Key",
+	Method: http.MethodDelete,
+	Path:   "/apikeys/:id",
+	IntlMessages: map[string]string{
+		"apikeys_revoke_failed": "Failed to revoke API Key",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		db := c.IgnoreAuth().DB()
+		defer c.IgnoreAuth(true)
+		secret, err := getOwnAPIKey(c, db, ParseID(c.Param("id")))
+		if err != nil {
+			return c.STDErr(err, "apikeys_revoke_failed")
+		}
+		if secret.Method != SignMethodLogout {
+			if err := revokeSignSecret(db, secret); err != nil {
+				return c.STDErr(err, "apikeys_revoke_failed")
+			}
+		}
+		return c.STDOK()
+	},
+}
+
+// APIKeyRotateRoute
+var APIKeyRotateRoute = RouteInfo{
+	Name:   "轮换API Key（旧Key在宽限期内仍然有效）",
+	Method: http.MethodPost,
+	Path:   "/apikeys/:id/rotate",
+	IntlMessages: map[string]string{
+		"apikeys_rotate_failed": "Failed to rotate API Key",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		var body struct {
+			GraceSeconds int64
+		}
+		_ = c.ShouldBindJSON(&body)
+		if body.GraceSeconds < 0 {
+			body.GraceSeconds = 0
+		}
+		if isAPIKeyCaller(c) {
+			return c.STDErr(ErrAPIKeyCaller, "apikeys_rotate_failed")
+		}
+		db := c.IgnoreAuth().DB()
+		defer c.IgnoreAuth(true)
+		old, err := getOwnAPIKey(c, db, ParseID(c.Param("id")))
+		if err != nil {
+			return c.STDErr(err, "apikeys_rotate_failed")
+		}
+		if old.Method == SignMethodLogout {
+			return c.STDErr(ErrInvalidToken, "apikeys_rotate_failed")
+		}
+		var payload jwt.MapClaims
+		if err := JSONParse(old.Payload, &payload); err != nil {
+			return c.STDErr(err, "apikeys_rotate_failed")
+		}
+		secretData, err := GenToken(GenTokenDesc{
+			UID:         old.UID,
+			Username:    old.Username,
+			Payload:     payload,
+			Exp:         old.Exp,
+			Type:        old.Type,
+			Desc:        old.Desc,
+			IsAPIKey:    true,
+			APIKeyScope: old.GetAPIKeyScope(),
+		})
+		if err != nil {
+			return c.STDErr(err, "apikeys_rotate_failed")
+		}
+		// 缩短旧Key的有效期至宽限期结束
+		graceExp := time.Now().Unix() + body.GraceSeconds
+		if graceExp < old.Exp {
+			if err := db.Model(&SignSecret{}).Where("id = ?", old.ID).UpdateColumn("exp", graceExp).Error; err != nil {
+				return c.STDErr(err, "apikeys_rotate_failed")
+			}
+			DelCache(old.Token)
+		}
+		return c.STD(secretData.Token)
+	},
+}
@@ -0,0 +1,70 @@
+package kuu
+
+import "testing"
+
+func TestAPIKeyScopeAllowIP(t *testing.T) {
+	scope := &APIKeyScope{AllowedIPs: []string{"10.0.0.0/8", "192.168.1.10"}}
+	cases := map[string]bool{
+		"10.1.2.3":     true,
+		"192.168.1.10": true,
+		"192.168.1.11": false,
+		"":             false,
+	}
+	for ip, want := range cases {
+		if got := scope.AllowIP(ip); got != want {
+			t.Errorf("%q: want %v, got %v", ip, want, got)
+		}
+	}
+	if !(*APIKeyScope)(nil).AllowIP("1.1.1.1") {
+		t.Error("nil scope should allow any ip")
+	}
+}
+
+func TestAPIKeyScopeAllowAPI(t *testing.T) {
+	scope := &APIKeyScope{APIs: []APIPermissionItem{
+		{Method: []string{"GET"}, Pattern: "^/api/user"},
+		{Method: []string{"*"}, Pattern: "^/api/order$"},
+	}}
+	cases := []struct {
+		method, path string
+		want         bool
+	}{
+		{"GET", "/api/user", true},
+		{"POST", "/api/user", false},
+		{"DELETE", "/api/order", true},
+		{"GET", "/api/org", false},
+	}
+	for _, item := range cases {
+		if got := scope.AllowAPI(item.method, item.path); got != item.want {
+			t.Errorf("%s %s: want %v, got %v", item.method, item.path, item.want, got)
+		}
+	}
+}
+
+func TestApplyAPIKeyScope(t *testing.T) {
+	desc := &PrivilegesDesc{
+		Permissions:      []string{"a", "b", "c"},
+		PermissionMap:    map[string]int64{"a": 0, "b": 0, "c": 0},
+		WritableOrgIDs:   []uint{1, 2},
+		WritableOrgIDMap: map[uint]Org{1: {ID: 1}, 2: {ID: 2}},
+		LoginableOrgIDs:  []uint{1, 2},
+		LoginableOrgIDMap: map[uint]Org{
+			1: {ID: 1, Code: "o1"},
+			2: {ID: 2, Code: "o2"},
+		},
+		ActOrgID: 1,
+	}
+	desc.applyAPIKeyScope(&APIKeyScope{Permissions: []string{"b", "x"}, OrgIDs: []uint{2}})
+	if len(desc.Permissions) != 1 || desc.Permissions[0] != "b" {
+		t.Errorf("unexpected permissions: %v", desc.Permissions)
+	}
+	if _, has := desc.PermissionMap["a"]; has {
+		t.Error("permission a should be removed")
+	}
+	if len(desc.WritableOrgIDs) != 1 || desc.WritableOrgIDs[0] != 2 || desc.IsWritableOrgID(1) {
+		t.Errorf("unexpected writable orgs: %v", desc.WritableOrgIDs)
+	}
+	if desc.ActOrgID != 2 || desc.ActOrgCode != "o2" {
+		t.Errorf("unexpected act org: %d %s", desc.ActOrgID, desc.ActOrgCode)
+	}
+}
@@ -0,0 +1,339 @@
+package kuu
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/guregu/null.v3"
+)
+
+// HMACSignType 签名请求的令牌类型，可用于RouteInfo.SignType限制接口只允许服务间调用
+const HMACSignType = "HMAC"
+
+// 签名请求的Header名称
+var (
+	SignedRequestAppIDKey     = "X-Kuu-App-ID"
+	SignedRequestTimestampKey = "X-Kuu-Timestamp"
+	SignedRequestNonceKey     = "X-Kuu-Nonce"
+	SignedRequestSignatureKey = "X-Kuu-Signature"
+)
+
+var (
+	ErrSignedRequestInvalid   = errors.New("invalid signed request")
+	ErrSignedRequestExpired   = errors.New("signed request timestamp out of range")
+	ErrSignedRequestReplayed  = errors.New("signed request nonce already used")
+	ErrSignedRequestSignature = errors.New("signed request signature mismatch")
+)
+
+func signedRequestMaxSkew() time.Duration {
+	return time.Duration(C().DefaultGetInt("signedRequest:maxSkewSeconds", 300)) * time.Second
+}
+
+// claimSignedRequestNonce 原子地占用随机串，已被占用时返回false，并发的重放请求只有一个能通过
+func claimSignedRequestNonce(appID, nonce, timestamp string) bool {
+	nonceKey := fmt.Sprintf("signed_request_nonce_%s_%s", appID, nonce)
+	return SetCacheStringNX(nonceKey, timestamp, 2*signedRequestMaxSkew())
+}
+
+// SignedRequestStringToSign 生成待签名字符串：METHOD\nPATH\n排序后的查询参数\n时间戳\n随机串\nSHA256(请求体)
+func SignedRequestStringToSign(method, path, rawQuery, timestamp, nonce string, body []byte) string {
+	var query string
+	if values, err := url.ParseQuery(rawQuery); err == nil {
+		query = values.Encode()
+	}
+	bodyHash := sha256.Sum256(body)
+	return strings.Join([]string{
+		strings.ToUpper(method),
+		path,
+		query,
+		timestamp,
+		nonce,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+}
+
+// SignedRequestSignature 计算HMAC-SHA256签名（十六进制小写）
+func SignedRequestSignature(secret, stringToSign string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(stringToSign))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignHTTPRequest 为发往其他服务的请求添加签名Header
+func SignHTTPRequest(req *http.Request, appID, secret string) error {
+	var body []byte
+	if req.Body != nil {
+		b, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return err
+		}
+		_ = req.Body.Close()
+		body = b
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := strings.ReplaceAll(uuid.NewV4().String(), "-", "")
+	stringToSign := SignedRequestStringToSign(req.Method, req.URL.Path, req.URL.RawQuery, timestamp, nonce, body)
+	req.Header.Set(SignedRequestAppIDKey, appID)
+	req.Header.Set(SignedRequestTimestampKey, timestamp)
+	req.Header.Set(SignedRequestNonceKey, nonce)
+	req.Header.Set(SignedRequestSignatureKey, SignedRequestSignature(secret, stringToSign))
+	return nil
+}
+
+// IsSignedRequest 判断当前请求是否为签名请求
+func (c *Context) IsSignedRequest() bool {
+	return c.GetHeader(SignedRequestAppIDKey) != "" && c.GetHeader(SignedRequestSignatureKey) != ""
+}
+
+// decodedSignedRequest 校验签名请求并映射为服务用户的签名上下文
+func (c *Context) decodedSignedRequest() (*SignContext, error) {
+	var (
+		appID     = c.GetHeader(SignedRequestAppIDKey)
+		timestamp = c.GetHeader(SignedRequestTimestampKey)
+		nonce     = c.GetHeader(SignedRequestNonceKey)
+		signature = strings.ToLower(c.GetHeader(SignedRequestSignatureKey))
+	)
+	if appID == "" || timestamp == "" || nonce == "" || signature == "" {
+		return nil, ErrSignedRequestInvalid
+	}
+	// 校验时间偏差
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, ErrSignedRequestInvalid
+	}
+	skew := time.Since(time.Unix(ts, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > signedRequestMaxSkew() {
+		return nil, ErrSignedRequestExpired
+	}
+	// 查询应用
+	app, err := GetSignApp(appID)
+	if err != nil {
+		return nil, err
+	}
+	// 未设置密钥的应用任何人都可计算签名，直接拒绝
+	if app == nil || app.Disable.Bool || app.UID == 0 || app.Secret == "" {
+		return nil, ErrSignedRequestInvalid
+	}
+	// 读取请求体并还原
+	var body []byte
+	if c.Request.Body != nil {
+		if body, err = ioutil.ReadAll(c.Request.Body); err != nil {
+			return nil, err
+		}
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	// 校验签名
+	stringToSign := SignedRequestStringToSign(c.Request.Method, c.Request.URL.Path, c.Request.URL.RawQuery, timestamp, nonce, body)
+	if !hmac.Equal([]byte(SignedRequestSignature(app.Secret, stringToSign)), []byte(signature)) {
+		return nil, ErrSignedRequestSignature
+	}
+	// 防重放
+	if !claimSignedRequestNonce(appID, nonce, timestamp) {
+		return nil, ErrSignedRequestReplayed
+	}
+
+	user := GetUserFromCache(app.UID)
+	if user.ID == 0 || user.Disable.Bool {
+		return nil, ErrSignedRequestInvalid
+	}
+	sign := &SignContext{
+		Token:    fmt.Sprintf("%s:%s:%s", HMACSignType, appID, nonce),
+		Type:     HMACSignType,
+		Lang:     c.Lang(),
+		UID:      user.ID,
+		Username: user.Username,
+		Payload: jwt.MapClaims{
+			"UID":      user.ID,
+			"Username": user.Username,
+			"AppID":    appID,
+		},
+	}
+	sign.Secret = &SignSecret{
+		UID:      user.ID,
+		Username: user.Username,
+		Token:    sign.Token,
+		Method:   SignMethodLogin,
+		Type:     HMACSignType,
+		Iat:      ts,
+	}
+	if !sign.IsValid() {
+		return nil, ErrInvalidToken
+	}
+	return sign, nil
+}
+
+// GetSignApp 根据AppID查询签名应用
+func GetSignApp(appID string) (*SignApp, error) {
+	// 缓存时需保留密钥（模型上的Secret字段不参与JSON序列化）
+	type cachedSignApp struct {
+		ID      uint
+		AppID   string
+		Secret  string
+		UID     uint
+		Disable null.Bool
+	}
+	var (
+		cacheKey = getSignAppCacheKey(appID)
+		cached   cachedSignApp
+	)
+	if s := GetCacheString(cacheKey); s != "" {
+		if err := JSONParse(s, &cached); err == nil {
+			return &SignApp{Model: gorm.Model{ID: cached.ID}, AppID: cached.AppID, Secret: cached.Secret, UID: cached.UID, Disable: cached.Disable}, nil
+		}
+	}
+	var app SignApp
+	if err := DB().Where(&SignApp{AppID: appID}).First(&app).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	cached = cachedSignApp{ID: app.ID, AppID: app.AppID, Secret: app.Secret, UID: app.UID, Disable: app.Disable}
+	SetCacheString(cacheKey, JSONStringify(cached), 10*time.Minute)
+	return &app, nil
+}
+
+func getSignAppCacheKey(appID string) string {
+	return fmt.Sprintf("sign_app_%s", appID)
+}
+
+// SignAppCreateRoute
+var SignAppCreateRoute = RouteInfo{
+	Name:   "创建签名应用（该接口仅限root调用）",
+	Method: http.MethodPost,
+	Path:   "/signapps",
+	IntlMessages: map[string]string{
+		"signapps_failed":       "Failed to create sign app",
+		"login_as_unauthorized": "Unauthorized operation",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		if c.SignInfo.UID != RootUID() {
+			return c.STDErr(fmt.Errorf("unauthorized operation: uid=%v", c.SignInfo.UID), "login_as_unauthorized")
+		}
+		var body struct {
+			UID  uint `binding:"required"`
+			Name string
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			return c.STDErr(err, "signapps_failed")
+		}
+		app := SignApp{
+			AppID:   strings.ReplaceAll(uuid.NewV4().String(), "-", "")[:16],
+			Secret:  strings.ReplaceAll(uuid.NewV4().String()+uuid.NewV4().String(), "-", ""),
+			Name:    body.Name,
+			UID:     body.UID,
+			Disable: null.BoolFrom(false),
+		}
+		if err := c.IgnoreAuth().DB().Create(&app).Error; err != nil {
+			c.IgnoreAuth(true)
+			return c.STDErr(err, "signapps_failed")
+		}
+		c.IgnoreAuth(true)
+		return c.STD(D{"ID": app.ID, "AppID": app.AppID, "Secret": app.Secret})
+	},
+}
+
+// SignAppResetSecretRoute
+var SignAppResetSecretRoute = RouteInfo{
+	Name:   "重置签名应用密钥（该接口仅限root调用）",
+	Method: http.MethodPost,
+	Path:   "/signapps/:id/secret",
+	IntlMessages: map[string]string{
+		"signapps_failed":       "Failed to create sign app",
+		"login_as_unauthorized": "Unauthorized operation",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		if c.SignInfo.UID != RootUID() {
+			return c.STDErr(fmt.Errorf("unauthorized operation: uid=%v", c.SignInfo.UID), "login_as_unauthorized")
+		}
+		var (
+			app SignApp
+			db  = c.IgnoreAuth().DB()
+		)
+		defer c.IgnoreAuth(true)
+		if err := db.Where("id = ?", ParseID(c.Param("id"))).First(&app).Error; err != nil {
+			return c.STDErr(err, "signapps_failed")
+		}
+		secret := strings.ReplaceAll(uuid.NewV4().String()+uuid.NewV4().String(), "-", "")
+		if err := db.Model(&app).Update("secret", secret).Error; err != nil {
+			return c.STDErr(err, "signapps_failed")
+		}
+		DelCache(getSignAppCacheKey(app.AppID))
+		return c.STD(D{"ID": app.ID, "AppID": app.AppID, "Secret": secret})
+	},
+}
+
+// SignAppListRoute
+var SignAppListRoute = RouteInfo{
+	Name:   "查询签名应用列表（该接口仅限root调用）",
+	Method: http.MethodGet,
+	Path:   "/signapps",
+	IntlMessages: map[string]string{
+		"signapps_query_failed": "Failed to query sign apps",
+		"login_as_unauthorized": "Unauthorized operation",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		if c.SignInfo.UID != RootUID() {
+			return c.STDErr(fmt.Errorf("unauthorized operation: uid=%v", c.SignInfo.UID), "login_as_unauthorized")
+		}
+		var list []SignApp
+		if err := c.IgnoreAuth().DB().Order("id desc").Find(&list).Error; err != nil {
+			c.IgnoreAuth(true)
+			return c.STDErr(err, "signapps_query_failed")
+		}
+		c.IgnoreAuth(true)
+		return c.STD(list)
+	},
+}
+
+// SignAppDisableRoute
+var SignAppDisableRoute = RouteInfo{
+	Name:   "启用/禁用签名应用（该接口仅限root调用）",
+	Method: http.MethodPut,
+	Path:   "/signapps/:id/disable",
+	IntlMessages: map[string]string{
+		"signapps_failed":       "Failed to create sign app",
+		"login_as_unauthorized": "Unauthorized operation",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		if c.SignInfo.UID != RootUID() {
+			return c.STDErr(fmt.Errorf("unauthorized operation: uid=%v", c.SignInfo.UID), "login_as_unauthorized")
+		}
+		var body struct {
+			Disable bool
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			return c.STDErr(err, "signapps_failed")
+		}
+		var (
+			app SignApp
+			db  = c.IgnoreAuth().DB()
+		)
+		defer c.IgnoreAuth(true)
+		if err := db.Where("id = ?", ParseID(c.Param("id"))).First(&app).Error; err != nil {
+			return c.STDErr(err, "signapps_failed")
+		}
+		if err := db.Model(&app).Update("disable", null.BoolFrom(body.Disable)).Error; err != nil {
+			return c.STDErr(err, "signapps_failed")
+		}
+		DelCache(getSignAppCacheKey(app.AppID))
+		return c.STDOK()
+	},
+}
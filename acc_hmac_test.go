@@ -0,0 +1,67 @@
+package kuu
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSignedRequestStringToSign(t *testing.T) {
+	a := SignedRequestStringToSign("get", "/api/user", "b=2&a=1&a=0", "1600000000", "n1", nil)
+	b := SignedRequestStringToSign("GET", "/api/user", "a=1&a=0&b=2", "1600000000", "n1", []byte{})
+	if a != b {
+		t.Errorf("query order should not affect string to sign:\n%s\n%s", a, b)
+	}
+	want := "GET\n/api/user\na=1&a=0&b=2\n1600000000\nn1\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	if a != want {
+		t.Errorf("unexpected string to sign:\n%s", a)
+	}
+}
+
+func TestSignHTTPRequest(t *testing.T) {
+	body := `{"hello":"world"}`
+	req, _ := http.NewRequest(http.MethodPost, "http://localhost/api/user?x=1", strings.NewReader(body))
+	if err := SignHTTPRequest(req, "app", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	restored, _ := ioutil.ReadAll(req.Body)
+	if !bytes.Equal(restored, []byte(body)) {
+		t.Errorf("request body not restored: %s", restored)
+	}
+	stringToSign := SignedRequestStringToSign(req.Method, req.URL.Path, req.URL.RawQuery,
+		req.Header.Get(SignedRequestTimestampKey), req.Header.Get(SignedRequestNonceKey), restored)
+	if got := req.Header.Get(SignedRequestSignatureKey); got != SignedRequestSignature("secret", stringToSign) {
+		t.Errorf("signature mismatch: %s", got)
+	}
+}
+
+func TestClaimSignedRequestNonceConcurrent(t *testing.T) {
+	var (
+		nonce   = fmt.Sprintf("n%d", time.Now().UnixNano())
+		claimed int32
+		wg      sync.WaitGroup
+	)
+	defer DelCache(fmt.Sprintf("signed_request_nonce_app_%s", nonce))
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if claimSignedRequestNonce("app", nonce, "1600000000") {
+				atomic.AddInt32(&claimed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if claimed != 1 {
+		t.Errorf("expected exactly one request to claim the nonce, got %d", claimed)
+	}
+	if claimSignedRequestNonce("app", nonce, "1600000000") {
+		t.Error("replayed nonce should be rejected")
+	}
+}
@@ -0,0 +1,622 @@
+package kuu
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/guregu/null.v3"
+)
+
+const (
+	// RoleAssignSourceLDAP 目录同步产生的角色分配，同步时仅维护该来源的记录
+	RoleAssignSourceLDAP = "LDAP"
+	// UserSourceLDAP 目录同步创建的用户，同步时仅关联该来源的用户
+	UserSourceLDAP = "LDAP"
+)
+
+var (
+	ErrLDAPNotConfigured = errors.New("ldap is not configured")
+	ErrLDAPUserNotFound  = errors.New("ldap user not found")
+	ErrLDAPUserAmbiguous = errors.New("ldap user filter matched multiple entries")
+	ErrLDAPUserConflict  = errors.New("ldap user conflicts with a local account")
+)
+
+// LDAPConfig LDAP/AD配置，对应配置项ldap
+type LDAPConfig struct {
+	URL                string              `json:"url"`                // 服务地址，如ldap://127.0.0.1:389、ldaps://ad.example.com
+	StartTLS           bool                `json:"startTLS"`           // 是否在ldap://连接上启用StartTLS
+	InsecureSkipVerify bool                `json:"insecureSkipVerify"` // 是否跳过证书校验（仅限测试环境）
+	TimeoutSeconds     int                 `json:"timeoutSeconds"`     // 连接和读写超时时间（秒）
+	BindDN             string              `json:"bindDN"`             // 查询用的服务账号
+	BindPassword       string              `json:"bindPassword"`       // 服务账号密码
+	BaseDN             string              `json:"baseDN"`             // 用户查询的根节点
+	UserFilter         string              `json:"userFilter"`         // 登录时查询用户的条件，{{username}}为账号占位符
+	UserSyncFilter     string              `json:"userSyncFilter"`     // 同步用户时的查询条件
+	Attributes         LDAPAttributes      `json:"attributes"`         // 用户属性映射
+	GroupBaseDN        string              `json:"groupBaseDN"`        // 用户组查询的根节点，为空时仅使用memberOf属性
+	GroupFilter        string              `json:"groupFilter"`        // 查询用户所属组的条件，{{dn}}和{{username}}为占位符
+	GroupRoles         map[string][]string `json:"groupRoles"`         // 用户组（DN或CN）与角色编码的映射
+	DefaultRoles       []string            `json:"defaultRoles"`       // 所有目录用户默认分配的角色编码
+	OrgBaseDN          string              `json:"orgBaseDN"`          // 组织单元查询的根节点，为空时不同步组织
+	OrgFilter          string              `json:"orgFilter"`          // 组织单元查询条件
+	OrgCodeAttr        string              `json:"orgCodeAttr"`        // 组织编码属性，为空时使用DN
+	OrgNameAttr        string              `json:"orgNameAttr"`        // 组织名称属性
+	OrgRootCode        string              `json:"orgRootCode"`        // 顶层组织单元挂载的本地组织编码，为空时挂载到根组织
+	SyncSpec           string              `json:"syncSpec"`           // 定时同步的cron表达式，配置后导入模块时自动添加同步任务
+	FallbackLocal      bool                `json:"fallbackLocal"`      // 目录中不存在的账号是否使用本地密码登录
+	LinkLocalUsers     bool                `json:"linkLocalUsers"`     // 是否允许目录账号接管同名的本地账号（root用户除外）
+}
+
+// LDAPAttributes 用户属性映射
+type LDAPAttributes struct {
+	Username string `json:"username"`
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Mobile   string `json:"mobile"`
+	MemberOf string `json:"memberOf"`
+}
+
+// GetLDAPConfig 读取LDAP配置
+func GetLDAPConfig() (config LDAPConfig) {
+	C().GetInterface("ldap", &config)
+	config.setDefaults()
+	return
+}
+
+func (l *LDAPConfig) setDefaults() {
+	if l.TimeoutSeconds <= 0 {
+		l.TimeoutSeconds = 10
+	}
+	if l.Attributes.Username == "" {
+		l.Attributes.Username = "uid"
+	}
+	if l.Attributes.Name == "" {
+		l.Attributes.Name = "cn"
+	}
+	if l.Attributes.Email == "" {
+		l.Attributes.Email = "mail"
+	}
+	if l.Attributes.Mobile == "" {
+		l.Attributes.Mobile = "mobile"
+	}
+	if l.Attributes.MemberOf == "" {
+		l.Attributes.MemberOf = "memberOf"
+	}
+	if l.UserFilter == "" {
+		l.UserFilter = fmt.Sprintf("(&(objectClass=person)(%s={{username}}))", l.Attributes.Username)
+	}
+	if l.UserSyncFilter == "" {
+		l.UserSyncFilter = "(objectClass=person)"
+	}
+	if l.GroupFilter == "" {
+		l.GroupFilter = "(|(member={{dn}})(uniqueMember={{dn}})(memberUid={{username}}))"
+	}
+	if l.OrgFilter == "" {
+		l.OrgFilter = "(objectClass=organizationalUnit)"
+	}
+	if l.OrgNameAttr == "" {
+		l.OrgNameAttr = "ou"
+	}
+}
+
+// Enabled 是否已配置LDAP
+func (l LDAPConfig) Enabled() bool {
+	return l.URL != ""
+}
+
+// Dial 建立连接，按需启用StartTLS并使用服务账号绑定
+func (l LDAPConfig) Dial() (*ldap.Conn, error) {
+	if !l.Enabled() {
+		return nil, ErrLDAPNotConfigured
+	}
+	var (
+		timeout   = time.Duration(l.TimeoutSeconds) * time.Second
+		tlsConfig = &tls.Config{InsecureSkipVerify: l.InsecureSkipVerify}
+	)
+	conn, err := ldap.DialURL(l.URL, ldap.DialWithDialer(&net.Dialer{Timeout: timeout}), ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(timeout)
+	if l.StartTLS && strings.HasPrefix(l.URL, "ldap://") {
+		if u, err := url.Parse(l.URL); err == nil {
+			tlsConfig.ServerName = u.Hostname()
+		}
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if l.BindDN != "" {
+		if err := conn.Bind(l.BindDN, l.BindPassword); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// search 在目录中查询条目，sizeLimit为0表示不限制
+func (l LDAPConfig) search(conn *ldap.Conn, baseDN, filter string, attributes []string, sizeLimit int) ([]*ldap.Entry, error) {
+	result, err := conn.Search(ldap.NewSearchRequest(
+		baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, sizeLimit, l.TimeoutSeconds, false,
+		filter, attributes, nil,
+	))
+	if err != nil {
+		// 超出数量限制时保留已返回的条目，由调用方判断
+		if result != nil && ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+			return result.Entries, nil
+		}
+		return nil, err
+	}
+	return result.Entries, nil
+}
+
+func (l LDAPConfig) userAttributes() []string {
+	return []string{l.Attributes.Username, l.Attributes.Name, l.Attributes.Email, l.Attributes.Mobile, l.Attributes.MemberOf}
+}
+
+// FindUser 按账号查询目录用户
+func (l LDAPConfig) FindUser(conn *ldap.Conn, username string) (*ldap.Entry, error) {
+	filter := strings.ReplaceAll(l.UserFilter, "{{username}}", ldap.EscapeFilter(username))
+	entries, err := l.search(conn, l.BaseDN, filter, l.userAttributes(), 2)
+	if err != nil {
+		return nil, err
+	}
+	switch len(entries) {
+	case 0:
+		return nil, ErrLDAPUserNotFound
+	case 1:
+		return entries[0], nil
+	default:
+		return nil, fmt.Errorf("%w: username=%s", ErrLDAPUserAmbiguous, username)
+	}
+}
+
+// UserGroups 查询用户所属的组（DN列表）
+func (l LDAPConfig) UserGroups(conn *ldap.Conn, entry *ldap.Entry) ([]string, error) {
+	groups := append([]string{}, entry.GetEqualFoldAttributeValues(l.Attributes.MemberOf)...)
+	if l.GroupBaseDN != "" {
+		filter := strings.NewReplacer(
+			"{{dn}}", ldap.EscapeFilter(entry.DN),
+			"{{username}}", ldap.EscapeFilter(entry.GetEqualFoldAttributeValue(l.Attributes.Username)),
+		).Replace(l.GroupFilter)
+		entries, err := l.search(conn, l.GroupBaseDN, filter, []string{"cn"}, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range entries {
+			groups = append(groups, item.DN)
+		}
+	}
+	return groups, nil
+}
+
+// MapRoles 根据用户组映射角色编码，组可按完整DN或CN配置（不区分大小写）
+func (l LDAPConfig) MapRoles(groups []string) []string {
+	mapping := make(map[string][]string)
+	for group, codes := range l.GroupRoles {
+		key := strings.ToLower(strings.TrimSpace(group))
+		mapping[key] = append(mapping[key], codes...)
+	}
+	exists := make(map[string]bool)
+	var codes []string
+	add := func(items []string) {
+		for _, code := range items {
+			if code != "" && !exists[code] {
+				exists[code] = true
+				codes = append(codes, code)
+			}
+		}
+	}
+	add(l.DefaultRoles)
+	for _, group := range groups {
+		add(mapping[strings.ToLower(group)])
+		if cn := ldapRDNValue(group); cn != "" {
+			add(mapping[strings.ToLower(cn)])
+		}
+	}
+	return codes
+}
+
+// ldapRDNValue 返回DN中第一个RDN的值，如cn=admins,ou=groups,dc=example,dc=com返回admins
+func ldapRDNValue(dn string) string {
+	rdn := strings.SplitN(dn, ",", 2)[0]
+	if i := strings.IndexByte(rdn, '='); i >= 0 {
+		return strings.TrimSpace(rdn[i+1:])
+	}
+	return ""
+}
+
+// ldapParentDN 返回上级DN
+func ldapParentDN(dn string) string {
+	if parts := strings.SplitN(dn, ",", 2); len(parts) == 2 {
+		return strings.TrimSpace(parts[1])
+	}
+	return ""
+}
+
+// LDAPAuthenticate 校验目录账号密码，返回用户条目及所属组
+func LDAPAuthenticate(config LDAPConfig, username, password string) (*ldap.Entry, []string, error) {
+	conn, err := config.Dial()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+
+	entry, err := config.FindUser(conn, username)
+	if err != nil {
+		return nil, nil, err
+	}
+	groups, err := config.UserGroups(conn, entry)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := conn.Bind(entry.DN, password); err != nil {
+		return nil, nil, err
+	}
+	return entry, groups, nil
+}
+
+// SyncLDAPUser 根据目录条目创建或更新本地用户，并同步角色分配
+// 仅关联目录同步创建的用户，同名的本地账号需开启ldap:linkLocalUsers才会被接管，root用户始终不会被关联
+func SyncLDAPUser(tx *gorm.DB, config LDAPConfig, entry *ldap.Entry, groups []string, orgID uint) (*User, error) {
+	username := entry.GetEqualFoldAttributeValue(config.Attributes.Username)
+	if username == "" {
+		return nil, fmt.Errorf("ldap entry has no username attribute: dn=%s", entry.DN)
+	}
+	var user User
+	if err := tx.Where(&User{Username: username}).First(&user).Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+		return nil, err
+	}
+	if err := checkLDAPUserLink(config, &user); err != nil {
+		return nil, fmt.Errorf("%w: username=%s, dn=%s", err, username, entry.DN)
+	}
+	attrs := map[string]interface{}{
+		"name":      entry.GetEqualFoldAttributeValue(config.Attributes.Name),
+		"email":     entry.GetEqualFoldAttributeValue(config.Attributes.Email),
+		"mobile":    entry.GetEqualFoldAttributeValue(config.Attributes.Mobile),
+		"source":    UserSourceLDAP,
+		"source_id": entry.DN,
+	}
+	if orgID != 0 {
+		attrs["org_id"] = orgID
+	}
+	if user.ID == 0 {
+		// 目录用户不使用本地密码登录，使用随机密码占位
+		user = User{
+			Username: username,
+			Password: MD5(uuid.NewV4().String()),
+			Name:     attrs["name"].(string),
+			Email:    attrs["email"].(string),
+			Mobile:   attrs["mobile"].(string),
+			OrgID:    orgID,
+			Disable:  null.BoolFrom(false),
+			Source:   UserSourceLDAP,
+			SourceID: entry.DN,
+		}
+		if err := tx.Create(&user).Error; err != nil {
+			return nil, err
+		}
+	} else {
+		if err := tx.Model(&User{}).Where("id = ?", user.ID).UpdateColumns(attrs).Error; err != nil {
+			return nil, err
+		}
+		DelCache(fmt.Sprintf("user_%d", user.ID))
+		if err := tx.Where("id = ?", user.ID).First(&user).Error; err != nil {
+			return nil, err
+		}
+	}
+	if err := syncLDAPRoleAssigns(tx, user.ID, config.MapRoles(groups)); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// checkLDAPUserLink 判断目录账号能否关联已存在的同名本地用户
+func checkLDAPUserLink(config LDAPConfig, user *User) error {
+	switch {
+	case user.ID == 0:
+		return nil
+	case user.ID == RootUID() || user.IsBuiltIn.Bool:
+		return ErrLDAPUserConflict
+	case user.Source == UserSourceLDAP || config.LinkLocalUsers:
+		return nil
+	}
+	return ErrLDAPUserConflict
+}
+
+// syncLDAPRoleAssigns 同步目录来源的角色分配，手工分配的角色不受影响
+func syncLDAPRoleAssigns(tx *gorm.DB, uid uint, roleCodes []string) error {
+	var roles []Role
+	if len(roleCodes) > 0 {
+		if err := tx.Model(&Role{}).Where(fmt.Sprintf("%s IN (?)", tx.Dialect().Quote("code")), roleCodes).Find(&roles).Error; err != nil {
+			return err
+		}
+	}
+	var assigns []RoleAssign
+	if err := tx.Where(&RoleAssign{UserID: uid, Source: RoleAssignSourceLDAP}).Find(&assigns).Error; err != nil {
+		return err
+	}
+	wanted := make(map[uint]bool)
+	for _, role := range roles {
+		wanted[role.ID] = true
+	}
+	existing := make(map[uint]bool)
+	for _, assign := range assigns {
+		if wanted[assign.RoleID] {
+			existing[assign.RoleID] = true
+			continue
+		}
+		if err := tx.Where("id = ?", assign.ID).Delete(&RoleAssign{}).Error; err != nil {
+			return err
+		}
+	}
+	for _, role := range roles {
+		if existing[role.ID] {
+			continue
+		}
+		if err := tx.Create(&RoleAssign{UserID: uid, RoleID: role.ID, Source: RoleAssignSourceLDAP}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SyncLDAPOrgs 同步组织单元，返回DN与本地组织ID的映射
+func SyncLDAPOrgs(tx *gorm.DB, config LDAPConfig, conn *ldap.Conn) (map[string]uint, error) {
+	orgIDs := make(map[string]uint)
+	if config.OrgBaseDN == "" {
+		return orgIDs, nil
+	}
+	attrs := []string{config.OrgNameAttr}
+	if config.OrgCodeAttr != "" {
+		attrs = append(attrs, config.OrgCodeAttr)
+	}
+	entries, err := config.search(conn, config.OrgBaseDN, config.OrgFilter, attrs, 0)
+	if err != nil {
+		return nil, err
+	}
+	// 按层级排序，保证上级组织先于下级组织创建
+	sort.SliceStable(entries, func(i, j int) bool {
+		return strings.Count(entries[i].DN, ",") < strings.Count(entries[j].DN, ",")
+	})
+	rootPid := RootOrgID()
+	if config.OrgRootCode != "" {
+		var root Org
+		if err := tx.Where(&Org{Code: config.OrgRootCode}).First(&root).Error; err != nil {
+			return nil, err
+		}
+		rootPid = root.ID
+	}
+	for _, entry := range entries {
+		code := strings.ToLower(entry.DN)
+		if config.OrgCodeAttr != "" {
+			if v := entry.GetEqualFoldAttributeValue(config.OrgCodeAttr); v != "" {
+				code = v
+			}
+		}
+		name := entry.GetEqualFoldAttributeValue(config.OrgNameAttr)
+		if name == "" {
+			name = ldapRDNValue(entry.DN)
+		}
+		pid := orgIDs[strings.ToLower(ldapParentDN(entry.DN))]
+		if pid == 0 {
+			pid = rootPid
+		}
+		var org Org
+		if err := tx.Where(&Org{Code: code}).First(&org).Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+			return nil, err
+		}
+		if org.ID == 0 {
+			org = Org{Code: code, Name: name, Pid: pid}
+			if err := tx.Create(&org).Error; err != nil {
+				return nil, err
+			}
+		} else if org.Name != name || org.Pid != pid {
+			if err := tx.Model(&Org{}).Where("id = ?", org.ID).UpdateColumns(map[string]interface{}{"name": name, "pid": pid}).Error; err != nil {
+				return nil, err
+			}
+		}
+		orgIDs[strings.ToLower(entry.DN)] = org.ID
+	}
+	return orgIDs, nil
+}
+
+// LDAPSyncResult 同步结果
+type LDAPSyncResult struct {
+	Orgs   int
+	Users  int
+	Errors []string
+}
+
+// SyncLDAP 同步目录中的组织单元、用户及角色分配
+func SyncLDAP(config LDAPConfig) (*LDAPSyncResult, error) {
+	conn, err := config.Dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	IgnoreAuth()
+	defer IgnoreAuth(true)
+
+	result := &LDAPSyncResult{}
+	var orgIDs map[string]uint
+	if err := WithTransaction(func(tx *gorm.DB) error {
+		var err error
+		orgIDs, err = SyncLDAPOrgs(tx, config, conn)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	result.Orgs = len(orgIDs)
+
+	entries, err := config.search(conn, config.BaseDN, config.UserSyncFilter, config.userAttributes(), 0)
+	if err != nil {
+		return result, err
+	}
+	for _, entry := range entries {
+		// 单个用户同步失败不影响其他用户
+		err := WithTransaction(func(tx *gorm.DB) error {
+			groups, err := config.UserGroups(conn, entry)
+			if err != nil {
+				return err
+			}
+			_, err = SyncLDAPUser(tx, config, entry, groups, orgIDs[strings.ToLower(ldapParentDN(entry.DN))])
+			return err
+		})
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %s", entry.DN, err.Error()))
+			continue
+		}
+		result.Users++
+	}
+	return result, nil
+}
+
+// AddLDAPSyncJob 按配置项ldap:syncSpec添加定时同步任务，未配置时每小时同步一次
+func AddLDAPSyncJob() error {
+	config := GetLDAPConfig()
+	if !config.Enabled() {
+		return ErrLDAPNotConfigured
+	}
+	spec := config.SyncSpec
+	if spec == "" {
+		spec = "@every 1h"
+	}
+	_, err := AddJob(spec, "LDAP同步", func(c *JobContext) {
+		result, err := SyncLDAP(GetLDAPConfig())
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		for _, item := range result.Errors {
+			c.Error(errors.New(item))
+		}
+		INFO("LDAP sync finished: orgs=%d, users=%d, errors=%d", result.Orgs, result.Users, len(result.Errors))
+	})
+	return err
+}
+
+// LDAPLoginHandler LDAP登录处理器，使用方式：kuu.Acc(kuu.LDAPLoginHandler)
+// 目录认证需要明文密码，客户端应通过PasswordPlain（或Password）提交
+func LDAPLoginHandler(c *Context) (resp *LoginHandlerResponse) {
+	config := GetLDAPConfig()
+	if !config.Enabled() {
+		return defaultLoginHandler(c)
+	}
+	body := struct {
+		Username      string
+		Password      string
+		PasswordPlain string
+	}{}
+	if err := c.ShouldBindBodyWith(&body, binding.JSON); err != nil {
+		return &LoginHandlerResponse{Error: err}
+	}
+	resp = &LoginHandlerResponse{
+		Username: body.Username,
+		Password: body.Password,
+	}
+	if err := CheckLoginThrottle(c.TrustedClientIP()); err != nil {
+		resp.Error = err
+		resp.LocaleMessageID = "acc_login_throttled"
+		resp.LocaleMessageDefaultText = "Too many failed login attempts, please try again later."
+		return
+	}
+	// 本地账号被锁定时不再请求目录服务
+	var local User
+	if err := DB().Where(&User{Username: body.Username}).First(&local).Error; err == nil && IsAccountLocked(&local) {
+		resp.Error = fmt.Errorf("%w: uid=%v, until=%v", ErrAccountLocked, local.ID, local.LockedUntil)
+		resp.LocaleMessageID = "acc_account_locked"
+		resp.LocaleMessageDefaultText = "Account locked due to too many failed login attempts, please try again after {{until}}."
+		resp.LocaleMessageContextValues = D{"until": local.LockedUntil.Format("2006-01-02 15:04:05")}
+		return
+	}
+	password := body.PasswordPlain
+	if password == "" {
+		password = body.Password
+	}
+	entry, groups, err := LDAPAuthenticate(config, body.Username, password)
+	if err != nil {
+		if errors.Is(err, ErrLDAPUserNotFound) && config.FallbackLocal {
+			return defaultLoginHandler(c)
+		}
+		resp.Error = err
+		if errors.Is(err, ErrLDAPUserNotFound) || ldap.IsErrorAnyOf(err, ldap.LDAPResultInvalidCredentials, ldap.ErrorEmptyPassword) {
+			var failedUser *User
+			if local.ID != 0 {
+				failedUser = &local
+			}
+			RecordLoginFailure(c, body.Username, failedUser)
+		} else {
+			resp.LocaleMessageID = "acc_ldap_unavailable"
+			resp.LocaleMessageDefaultText = "Directory service is unavailable, please try again later."
+		}
+		return
+	}
+	var user *User
+	IgnoreAuth()
+	err = WithTransaction(func(tx *gorm.DB) error {
+		var err error
+		user, err = SyncLDAPUser(tx, config, entry, groups, 0)
+		return err
+	})
+	IgnoreAuth(true)
+	if err != nil {
+		resp.Error = err
+		if errors.Is(err, ErrLDAPUserConflict) {
+			resp.LocaleMessageID = "acc_ldap_user_conflict"
+			resp.LocaleMessageDefaultText = "The directory account conflicts with a local account."
+		}
+		return
+	}
+	if user.DenyLogin.Bool {
+		resp.Error = fmt.Errorf("account deny login: %v", user.ID)
+		resp.LocaleMessageID = "acc_account_deny"
+		resp.LocaleMessageDefaultText = "Account deny login."
+		return
+	}
+	if user.Disable.Bool {
+		resp.Error = fmt.Errorf("account has been disabled: %v", user.ID)
+		resp.LocaleMessageID = "acc_account_disabled"
+		resp.LocaleMessageDefaultText = "Account has been disabled."
+		return
+	}
+	ResetLoginFailures(user)
+	setLoginPayload(c, resp, user)
+	resp.Payload["LDAP"] = true
+	return
+}
+
+// LDAPSyncRoute
+var LDAPSyncRoute = RouteInfo{
+	Name:   "立即执行LDAP同步（该接口仅限root调用）",
+	Method: http.MethodPost,
+	Path:   "/ldap/sync",
+	IntlMessages: map[string]string{
+		"acc_ldap_sync_failed":  "LDAP synchronization failed",
+		"login_as_unauthorized": "Unauthorized operation",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		if c.SignInfo.UID != RootUID() {
+			return c.STDErr(fmt.Errorf("unauthorized operation: uid=%v", c.SignInfo.UID), "login_as_unauthorized")
+		}
+		result, err := SyncLDAP(GetLDAPConfig())
+		if err != nil {
+			return c.STDErr(err, "acc_ldap_sync_failed")
+		}
+		return c.STD(result)
+	},
+}
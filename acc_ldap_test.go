@@ -0,0 +1,518 @@
+package kuu
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/jinzhu/gorm"
+	"gopkg.in/guregu/null.v3"
+)
+
+func TestLDAPMapRoles(t *testing.T) {
+	config := LDAPConfig{
+		GroupRoles: map[string][]string{
+			"cn=admins,ou=groups,dc=example,dc=com": {"admin"},
+			"Developers":                            {"dev"},
+		},
+		DefaultRoles: []string{"staff"},
+	}
+	roles := config.MapRoles([]string{
+		"CN=Admins,OU=Groups,DC=example,DC=com",
+		"cn=developers,ou=groups,dc=example,dc=com",
+		"cn=guests,ou=groups,dc=example,dc=com",
+	})
+	sort.Strings(roles)
+	if !reflect.DeepEqual(roles, []string{"admin", "dev", "staff"}) {
+		t.Errorf("unexpected roles: %v", roles)
+	}
+	if v := ldapRDNValue("cn=admins,ou=groups,dc=example,dc=com"); v != "admins" {
+		t.Errorf("unexpected rdn value: %s", v)
+	}
+	if v := ldapParentDN("uid=alice, ou=staff,dc=example,dc=com"); v != "ou=staff,dc=example,dc=com" {
+		t.Errorf("unexpected parent dn: %s", v)
+	}
+}
+
+func TestCheckLDAPUserLink(t *testing.T) {
+	var (
+		config = LDAPConfig{}
+		link   = LDAPConfig{LinkLocalUsers: true}
+	)
+	cases := []struct {
+		name     string
+		config   LDAPConfig
+		user     User
+		conflict bool
+	}{
+		{"new user", config, User{}, false},
+		{"ldap user", config, User{ID: 2, Source: UserSourceLDAP}, false},
+		{"local user", config, User{ID: 2}, true},
+		{"local user with linking", link, User{ID: 2}, false},
+		{"root", link, User{ID: RootUID()}, true},
+		{"root marked as ldap", link, User{ID: RootUID(), Source: UserSourceLDAP}, true},
+		{"built-in user", link, User{ID: 3, IsBuiltIn: null.BoolFrom(true)}, true},
+	}
+	for _, item := range cases {
+		err := checkLDAPUserLink(item.config, &item.user)
+		if conflict := errors.Is(err, ErrLDAPUserConflict); conflict != item.conflict {
+			t.Errorf("%s: expected conflict=%v, got %v", item.name, item.conflict, err)
+		}
+	}
+}
+
+// testLDAPServer 进程内LDAP测试服务，支持简单绑定、子树搜索和StartTLS
+type testLDAPServer struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	mu        sync.RWMutex
+	entries   []*ldap.Entry
+	passwords map[string]string
+}
+
+func newTestLDAPServer(t *testing.T) *testLDAPServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testLDAPServer{
+		listener:  listener,
+		tlsConfig: testTLSConfig(t),
+		passwords: make(map[string]string),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { _ = listener.Close() })
+	return s
+}
+
+func (s *testLDAPServer) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+// add 添加条目，password不为空时可使用该条目绑定
+func (s *testLDAPServer) add(dn, password string, attrs map[string][]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, ldap.NewEntry(dn, attrs))
+	if password != "" {
+		s.passwords[strings.ToLower(dn)] = password
+	}
+}
+
+// remove 删除条目
+func (s *testLDAPServer) remove(dn string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, entry := range s.entries {
+		if strings.EqualFold(entry.DN, dn) {
+			s.entries = append(s.entries[:i], s.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *testLDAPServer) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		msgID, op := packet.Children[0].Value, packet.Children[1]
+		reply := func(p *ber.Packet) {
+			msg := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+			msg.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, msgID, ""))
+			msg.AppendChild(p)
+			_, _ = conn.Write(msg.Bytes())
+		}
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn, password := testBERString(op.Children[1]), testBERString(op.Children[2])
+			s.mu.RLock()
+			expected, ok := s.passwords[strings.ToLower(dn)]
+			s.mu.RUnlock()
+			if ok && expected == password {
+				reply(testLDAPResult(ldap.ApplicationBindResponse, ldap.LDAPResultSuccess))
+			} else {
+				reply(testLDAPResult(ldap.ApplicationBindResponse, ldap.LDAPResultInvalidCredentials))
+			}
+		case ldap.ApplicationSearchRequest:
+			base, filter := strings.ToLower(testBERString(op.Children[0])), op.Children[6]
+			s.mu.RLock()
+			for _, entry := range s.entries {
+				dn := strings.ToLower(entry.DN)
+				if dn != base && !strings.HasSuffix(dn, ","+base) || !testLDAPMatch(filter, entry) {
+					continue
+				}
+				attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+				for _, attr := range entry.Attributes {
+					values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+					for _, v := range attr.Values {
+						values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, ""))
+					}
+					item := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+					item.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, attr.Name, ""))
+					item.AppendChild(values)
+					attrs.AppendChild(item)
+				}
+				result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+				result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.DN, ""))
+				result.AppendChild(attrs)
+				reply(result)
+			}
+			s.mu.RUnlock()
+			reply(testLDAPResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+		case ldap.ApplicationExtendedRequest:
+			if testBERString(op.Children[0]) != "1.3.6.1.4.1.1466.20037" {
+				reply(testLDAPResult(ldap.ApplicationExtendedResponse, ldap.LDAPResultProtocolError))
+				continue
+			}
+			reply(testLDAPResult(ldap.ApplicationExtendedResponse, ldap.LDAPResultSuccess))
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+		case ldap.ApplicationUnbindRequest:
+			return
+		}
+	}
+}
+
+// testBERString 读取字符串值，上下文类型的值只保存在Data中
+func testBERString(p *ber.Packet) string {
+	if v, ok := p.Value.(string); ok {
+		return v
+	}
+	return p.Data.String()
+}
+
+func testLDAPResult(tag ber.Tag, code uint16) *ber.Packet {
+	p := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), ""))
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	return p
+}
+
+// testLDAPMatch 支持与、或、非、等值、子串和存在条件
+func testLDAPMatch(filter *ber.Packet, entry *ldap.Entry) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !testLDAPMatch(child, entry) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if testLDAPMatch(child, entry) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return !testLDAPMatch(filter.Children[0], entry)
+	case ldap.FilterEqualityMatch:
+		for _, v := range entry.GetEqualFoldAttributeValues(testBERString(filter.Children[0])) {
+			if strings.EqualFold(v, testBERString(filter.Children[1])) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterSubstrings:
+		for _, v := range entry.GetEqualFoldAttributeValues(testBERString(filter.Children[0])) {
+			v = strings.ToLower(v)
+			matched := true
+			for _, sub := range filter.Children[1].Children {
+				part := strings.ToLower(testBERString(sub))
+				switch sub.Tag {
+				case ldap.FilterSubstringsInitial:
+					matched = matched && strings.HasPrefix(v, part)
+				case ldap.FilterSubstringsFinal:
+					matched = matched && strings.HasSuffix(v, part)
+				default:
+					matched = matched && strings.Contains(v, part)
+				}
+			}
+			if matched {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterPresent:
+		return len(entry.GetEqualFoldAttributeValues(testBERString(filter))) > 0
+	}
+	return false
+}
+
+func testTLSConfig(t *testing.T) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+// testLDAPDirectory 启动包含服务账号、组织单元、用户和用户组的测试目录
+func testLDAPDirectory(t *testing.T) (*testLDAPServer, LDAPConfig) {
+	server := newTestLDAPServer(t)
+	server.add("cn=admin,dc=example,dc=com", "admin-secret", map[string][]string{"cn": {"admin"}})
+	server.add("ou=staff,dc=example,dc=com", "", map[string][]string{
+		"objectClass": {"organizationalUnit"},
+		"ou":          {"Staff"},
+	})
+	server.add("ou=dev,ou=staff,dc=example,dc=com", "", map[string][]string{
+		"objectClass": {"organizationalUnit"},
+		"ou":          {"Dev"},
+	})
+	server.add("uid=alice,ou=dev,ou=staff,dc=example,dc=com", "alice-secret", map[string][]string{
+		"objectClass": {"person", "inetOrgPerson"},
+		"uid":         {"alice"},
+		"cn":          {"Alice"},
+		"mail":        {"alice@example.com"},
+		"memberOf":    {"cn=admins,ou=groups,dc=example,dc=com"},
+	})
+	server.add("cn=developers,ou=groups,dc=example,dc=com", "", map[string][]string{
+		"objectClass": {"groupOfNames"},
+		"cn":          {"developers"},
+		"member":      {"uid=alice,ou=dev,ou=staff,dc=example,dc=com"},
+	})
+	config := LDAPConfig{
+		URL:                server.URL(),
+		StartTLS:           true,
+		InsecureSkipVerify: true,
+		BindDN:             "cn=admin,dc=example,dc=com",
+		BindPassword:       "admin-secret",
+		BaseDN:             "dc=example,dc=com",
+		GroupBaseDN:        "ou=groups,dc=example,dc=com",
+		GroupRoles: map[string][]string{
+			"cn=admins,ou=groups,dc=example,dc=com": {"admin"},
+			"Developers":                            {"dev"},
+		},
+		OrgBaseDN: "ou=staff,dc=example,dc=com",
+	}
+	config.setDefaults()
+	return server, config
+}
+
+// testLDAPDB 注册sqlite默认数据源并创建目录同步涉及的表
+func testLDAPDB(t *testing.T) *gorm.DB {
+	db := testSQLiteDB(t, "")
+	// sqlite的索引名在库内唯一，迁移下一张表前删除上一张表的kuu_unique索引
+	for _, model := range []interface{}{&User{}, &PasswordHistory{}, &Org{}, &Role{}, &RoleAssign{}} {
+		if err := db.AutoMigrate(model).Error; err != nil {
+			t.Fatal(err)
+		}
+		db.Exec("DROP INDEX IF EXISTS kuu_unique")
+	}
+	for _, code := range []string{"admin", "dev", "ops"} {
+		if err := db.Create(&Role{Code: code, Name: code}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+// testLDAPRoles 查询用户的角色分配，source为空时查询全部
+func testLDAPRoles(t *testing.T, db *gorm.DB, uid uint, source string) []string {
+	t.Helper()
+	var codes []string
+	assigns, roles := db.NewScope(&RoleAssign{}).TableName(), db.NewScope(&Role{}).TableName()
+	query := db.Table(assigns).Joins(fmt.Sprintf("JOIN %s ON %s.id = %s.role_id", roles, roles, assigns)).Where(assigns+".user_id = ? AND "+assigns+".deleted_at IS NULL", uid)
+	if source != "" {
+		query = query.Where(assigns+".source = ?", source)
+	}
+	if err := query.Order(roles+".code").Pluck(roles+".code", &codes).Error; err != nil {
+		t.Fatal(err)
+	}
+	return codes
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	_, config := testLDAPDirectory(t)
+
+	entry, groups, err := LDAPAuthenticate(config, "alice", "alice-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.GetEqualFoldAttributeValue("MAIL") != "alice@example.com" {
+		t.Errorf("unexpected entry: %+v", entry)
+	}
+	roles := config.MapRoles(groups)
+	sort.Strings(roles)
+	if !reflect.DeepEqual(roles, []string{"admin", "dev"}) {
+		t.Errorf("unexpected roles: %v", roles)
+	}
+
+	if _, _, err := LDAPAuthenticate(config, "alice", "wrong"); !ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		t.Errorf("expected invalid credentials, got %v", err)
+	}
+	if _, _, err := LDAPAuthenticate(config, "alice", ""); !ldap.IsErrorWithCode(err, ldap.ErrorEmptyPassword) {
+		t.Errorf("expected empty password to be rejected, got %v", err)
+	}
+	for _, username := range []string{"bob", "*", "alice)(uid=*"} {
+		if _, _, err := LDAPAuthenticate(config, username, "alice-secret"); !errors.Is(err, ErrLDAPUserNotFound) {
+			t.Errorf("%s: expected user not found, got %v", username, err)
+		}
+	}
+	config.BindPassword = "wrong"
+	if _, _, err := LDAPAuthenticate(config, "alice", "alice-secret"); !ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		t.Errorf("expected service bind failure, got %v", err)
+	}
+}
+
+func TestSyncLDAP(t *testing.T) {
+	server, config := testLDAPDirectory(t)
+	db := testLDAPDB(t)
+	// 同名的本地账号不会被目录账号接管，根用户始终受保护
+	for _, username := range []string{"root", "bob"} {
+		if err := db.Create(&User{Username: username, Password: "x"}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	server.add("uid=root,ou=staff,dc=example,dc=com", "root-secret", map[string][]string{
+		"objectClass": {"person"},
+		"uid":         {"root"},
+	})
+	server.add("uid=bob,ou=staff,dc=example,dc=com", "bob-secret", map[string][]string{
+		"objectClass": {"person"},
+		"uid":         {"bob"},
+		"cn":          {"Bob"},
+	})
+
+	result, err := SyncLDAP(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Orgs != 2 || result.Users != 1 || len(result.Errors) != 2 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	var staff, dev Org
+	db.Where(&Org{Code: "ou=staff,dc=example,dc=com"}).First(&staff)
+	db.Where(&Org{Code: "ou=dev,ou=staff,dc=example,dc=com"}).First(&dev)
+	if staff.Name != "Staff" || staff.Pid != RootOrgID() || dev.Name != "Dev" || dev.Pid != staff.ID {
+		t.Errorf("unexpected orgs: %+v, %+v", staff, dev)
+	}
+	var alice, bob User
+	db.Where(&User{Username: "alice"}).First(&alice)
+	db.Where(&User{Username: "bob"}).First(&bob)
+	if alice.Source != UserSourceLDAP || alice.OrgID != dev.ID || alice.Email != "alice@example.com" {
+		t.Errorf("unexpected ldap user: %+v", alice)
+	}
+	if bob.Source != "" || bob.Name != "" {
+		t.Errorf("local user should not be linked: %+v", bob)
+	}
+	if roles := testLDAPRoles(t, db, alice.ID, ""); !reflect.DeepEqual(roles, []string{"admin", "dev"}) {
+		t.Errorf("unexpected roles: %v", roles)
+	}
+
+	// 目录变更后更新用户和目录来源的角色，手工分配的角色保持不变
+	db.Create(&RoleAssign{UserID: alice.ID, RoleID: 3})
+	server.remove("cn=developers,ou=groups,dc=example,dc=com")
+	server.remove("uid=alice,ou=dev,ou=staff,dc=example,dc=com")
+	server.add("uid=alice,ou=dev,ou=staff,dc=example,dc=com", "alice-secret", map[string][]string{
+		"objectClass": {"person"},
+		"uid":         {"alice"},
+		"cn":          {"Alice Liddell"},
+		"memberOf":    {"cn=admins,ou=groups,dc=example,dc=com"},
+	})
+	if _, err := SyncLDAP(config); err != nil {
+		t.Fatal(err)
+	}
+	var updated User
+	db.Where("id = ?", alice.ID).First(&updated)
+	if updated.Name != "Alice Liddell" || updated.Email != "" {
+		t.Errorf("unexpected updated user: %+v", updated)
+	}
+	if roles := testLDAPRoles(t, db, alice.ID, RoleAssignSourceLDAP); !reflect.DeepEqual(roles, []string{"admin"}) {
+		t.Errorf("unexpected ldap roles: %v", roles)
+	}
+	if roles := testLDAPRoles(t, db, alice.ID, ""); !reflect.DeepEqual(roles, []string{"admin", "ops"}) {
+		t.Errorf("manual role assignment should be kept: %v", roles)
+	}
+
+	// 开启linkLocalUsers后接管同名的本地账号
+	config.LinkLocalUsers = true
+	if result, err := SyncLDAP(config); err != nil || len(result.Errors) != 1 || !strings.Contains(result.Errors[0], "uid=root") {
+		t.Fatalf("unexpected result: %+v, %v", result, err)
+	}
+	db.Where("id = ?", bob.ID).First(&bob)
+	if bob.Source != UserSourceLDAP || bob.Name != "Bob" {
+		t.Errorf("local user should be linked: %+v", bob)
+	}
+}
+
+func TestLDAPLoginHandler(t *testing.T) {
+	_, config := testLDAPDirectory(t)
+	db := testLDAPDB(t)
+	raw := map[string]interface{}{}
+	if err := Copy(config, &raw); err != nil {
+		t.Fatal(err)
+	}
+	C(map[string]interface{}{"ldap": raw})
+	defer C(map[string]interface{}{"ldap": nil})
+
+	resp := LDAPLoginHandler(testContext("POST", "/login", `{"Username":"alice","PasswordPlain":"alice-secret"}`))
+	if resp.Error != nil {
+		t.Fatal(resp.Error)
+	}
+	var alice User
+	db.Where(&User{Username: "alice"}).First(&alice)
+	if alice.ID == 0 || resp.UID != alice.ID || resp.Payload["LDAP"] != true {
+		t.Errorf("unexpected login response: %+v", resp)
+	}
+	if roles := testLDAPRoles(t, db, alice.ID, RoleAssignSourceLDAP); !reflect.DeepEqual(roles, []string{"admin", "dev"}) {
+		t.Errorf("unexpected roles: %v", roles)
+	}
+
+	resp = LDAPLoginHandler(testContext("POST", "/login", `{"Username":"alice","PasswordPlain":"wrong"}`))
+	if !ldap.IsErrorWithCode(resp.Error, ldap.LDAPResultInvalidCredentials) {
+		t.Errorf("expected invalid credentials, got %v", resp.Error)
+	}
+	// 同名的本地账号拒绝登录
+	db.Model(&User{}).Where("id = ?", alice.ID).UpdateColumn("source", "")
+	resp = LDAPLoginHandler(testContext("POST", "/login", `{"Username":"alice","PasswordPlain":"alice-secret"}`))
+	if !errors.Is(resp.Error, ErrLDAPUserConflict) || resp.LocaleMessageID != "acc_ldap_user_conflict" {
+		t.Errorf("expected user conflict, got %v", resp.Error)
+	}
+}
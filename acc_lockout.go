@@ -0,0 +1,264 @@
+package kuu
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	ErrAccountLocked  = errors.New("account locked")
+	ErrLoginThrottled = errors.New("too many failed login attempts")
+)
+
+// LockoutPolicy 登录失败锁定策略，对应配置项lockout
+type LockoutPolicy struct {
+	MaxFailures          int     `json:"maxFailures"`          // 账号连续失败多少次后锁定，0表示不锁定
+	FailureWindowSeconds int     `json:"failureWindowSeconds"` // 失败次数统计窗口（秒）
+	BaseSeconds          int     `json:"baseSeconds"`          // 首次锁定时长（秒）
+	Multiplier           float64 `json:"multiplier"`           // 每次锁定时长的增长倍数
+	MaxSeconds           int     `json:"maxSeconds"`           // 最长锁定时长（秒）
+	IPMaxFailures        int     `json:"ipMaxFailures"`        // 单个IP在窗口内允许的失败次数，0表示不限制
+	SubnetMaxFailures    int     `json:"subnetMaxFailures"`    // 单个网段在窗口内允许的失败次数，0表示不限制
+	SubnetPrefixV4       int     `json:"subnetPrefixV4"`       // IPv4网段前缀长度
+	SubnetPrefixV6       int     `json:"subnetPrefixV6"`       // IPv6网段前缀长度
+}
+
+// GetLockoutPolicy 读取登录失败锁定策略，账号锁定和IP、网段限制默认关闭，需通过maxFailures、ipMaxFailures、subnetMaxFailures开启，
+// 如：{"lockout": {"maxFailures": 5, "ipMaxFailures": 30, "subnetMaxFailures": 100}}
+func GetLockoutPolicy() LockoutPolicy {
+	policy := LockoutPolicy{
+		FailureWindowSeconds: 900,
+		BaseSeconds:          60,
+		Multiplier:           2,
+		MaxSeconds:           86400,
+		SubnetPrefixV4:       24,
+		SubnetPrefixV6:       64,
+	}
+	C().GetInterface("lockout", &policy)
+	return policy
+}
+
+// LockDuration 第lockCount+1次锁定的时长
+func (p LockoutPolicy) LockDuration(lockCount int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	seconds := float64(p.BaseSeconds) * math.Pow(multiplier, float64(lockCount))
+	if p.MaxSeconds > 0 && seconds > float64(p.MaxSeconds) {
+		seconds = float64(p.MaxSeconds)
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func (p LockoutPolicy) window() time.Duration {
+	return time.Duration(p.FailureWindowSeconds) * time.Second
+}
+
+// SubnetOf 返回IP所在网段，如192.168.1.0/24
+func (p LockoutPolicy) SubnetOf(ip string) string {
+	parsed := net.ParseIP(strings.TrimSpace(ip))
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		mask := net.CIDRMask(p.SubnetPrefixV4, 32)
+		return fmt.Sprintf("%s/%d", v4.Mask(mask).String(), p.SubnetPrefixV4)
+	}
+	mask := net.CIDRMask(p.SubnetPrefixV6, 128)
+	return fmt.Sprintf("%s/%d", parsed.Mask(mask).String(), p.SubnetPrefixV6)
+}
+
+// loginFailureCounter 带统计窗口的失败计数，不依赖缓存过期能力
+type loginFailureCounter struct {
+	Count int
+	Start int64
+}
+
+func getLoginFailures(key string, window time.Duration) (counter loginFailureCounter) {
+	if s := GetCacheString(key); s != "" {
+		_ = JSONParse(s, &counter)
+	}
+	if window > 0 && counter.Start > 0 && time.Since(time.Unix(counter.Start, 0)) > window {
+		counter = loginFailureCounter{}
+	}
+	return
+}
+
+func incrLoginFailures(key string, window time.Duration) int {
+	counter := getLoginFailures(key, window)
+	if counter.Start == 0 {
+		counter.Start = time.Now().Unix()
+	}
+	counter.Count++
+	SetCacheString(key, JSONStringify(counter), window)
+	return counter.Count
+}
+
+func getIPFailuresKey(ip string) string {
+	return fmt.Sprintf("login_ip_%s_failed_times", ip)
+}
+
+func getSubnetFailuresKey(subnet string) string {
+	return fmt.Sprintf("login_subnet_%s_failed_times", subnet)
+}
+
+// CheckLoginThrottle 检查来源IP及所在网段是否因失败次数过多被限制登录
+func CheckLoginThrottle(ip string) error {
+	policy := GetLockoutPolicy()
+	if policy.IPMaxFailures > 0 && getLoginFailures(getIPFailuresKey(ip), policy.window()).Count >= policy.IPMaxFailures {
+		return NewIntlError(fmt.Errorf("%w: ip=%s", ErrLoginThrottled, ip), "acc_login_throttled", "Too many failed login attempts, please try again later.")
+	}
+	if subnet := policy.SubnetOf(ip); policy.SubnetMaxFailures > 0 && subnet != "" &&
+		getLoginFailures(getSubnetFailuresKey(subnet), policy.window()).Count >= policy.SubnetMaxFailures {
+		return NewIntlError(fmt.Errorf("%w: subnet=%s", ErrLoginThrottled, subnet), "acc_login_throttled", "Too many failed login attempts, please try again later.")
+	}
+	return nil
+}
+
+// RecordLoginFailure 记录登录失败：按账号、IP和网段计数，达到阈值时锁定账号
+func RecordLoginFailure(c *Context, username string, user *User) {
+	policy := GetLockoutPolicy()
+	ip := c.TrustedClientIP()
+	incrLoginFailures(getFailedTimesKey(username), policy.window())
+	if n := incrLoginFailures(getIPFailuresKey(ip), policy.window()); policy.IPMaxFailures > 0 && n == policy.IPMaxFailures {
+		saveLockoutEvent(c, user, username, "ip_throttled", D{"IP": ip, "Failures": n})
+	}
+	if subnet := policy.SubnetOf(ip); subnet != "" {
+		if n := incrLoginFailures(getSubnetFailuresKey(subnet), policy.window()); policy.SubnetMaxFailures > 0 && n == policy.SubnetMaxFailures {
+			saveLockoutEvent(c, user, username, "subnet_throttled", D{"Subnet": subnet, "Failures": n})
+		}
+	}
+	if user == nil || user.ID == 0 || policy.MaxFailures <= 0 {
+		return
+	}
+	now := time.Now()
+	failures := user.FailedLoginTimes + 1
+	if user.LastFailedLoginAt != nil && policy.window() > 0 && now.Sub(*user.LastFailedLoginAt) > policy.window() {
+		failures = 1
+	}
+	attrs := map[string]interface{}{
+		"failed_login_times":   failures,
+		"last_failed_login_at": now,
+	}
+	var lockedUntil time.Time
+	if failures >= policy.MaxFailures {
+		lockedUntil = now.Add(policy.LockDuration(user.LockCount))
+		attrs["failed_login_times"] = 0
+		attrs["locked_until"] = lockedUntil
+		attrs["lock_count"] = user.LockCount + 1
+	}
+	if err := DB().Model(&User{}).Where("id = ?", user.ID).UpdateColumns(attrs).Error; err != nil {
+		ERROR(err)
+		return
+	}
+	DelCache(fmt.Sprintf("user_%d", user.ID))
+	if !lockedUntil.IsZero() {
+		saveLockoutEvent(c, user, username, "locked", D{"IP": ip, "LockedUntil": lockedUntil, "LockCount": user.LockCount + 1})
+	}
+}
+
+// ResetLoginFailures 登录成功后清除失败计数
+func ResetLoginFailures(user *User) {
+	DelCache(getFailedTimesKey(user.Username))
+	if user.ID == 0 || (user.FailedLoginTimes == 0 && user.LockCount == 0 && user.LockedUntil == nil) {
+		return
+	}
+	if err := DB().Model(&User{}).Where("id = ?", user.ID).UpdateColumns(map[string]interface{}{
+		"failed_login_times": 0,
+		"lock_count":         0,
+		"locked_until":       gorm.Expr("NULL"),
+	}).Error; err != nil {
+		ERROR(err)
+	}
+	DelCache(fmt.Sprintf("user_%d", user.ID))
+}
+
+// IsAccountLocked 判断账号是否处于锁定状态
+func IsAccountLocked(user *User) bool {
+	return user != nil && user.LockedUntil != nil && user.LockedUntil.After(time.Now())
+}
+
+// UnlockUser 解锁账号
+func UnlockUser(tx *gorm.DB, uid uint) error {
+	if err := tx.Model(&User{}).Where("id = ?", uid).UpdateColumns(map[string]interface{}{
+		"failed_login_times": 0,
+		"lock_count":         0,
+		"locked_until":       gorm.Expr("NULL"),
+	}).Error; err != nil {
+		return err
+	}
+	DelCache(fmt.Sprintf("user_%d", uid))
+	return nil
+}
+
+func saveLockoutEvent(c *Context, user *User, username, action string, data D) {
+	log := EventLog{
+		EventClass:   EventLogClassSecurity,
+		EventSubject: fmt.Sprintf("login_%s", action),
+		EventSummary: fmt.Sprintf("login %s: username=%s", strings.ReplaceAll(action, "_", " "), username),
+		EventData:    JSONStringify(data),
+	}
+	if user != nil {
+		log.UserID = user.ID
+		log.UserName = user.Username
+		log.UserEmailAddress = user.Email
+		log.UserPhoneNumber = user.Mobile
+	} else {
+		log.UserName = username
+	}
+	if err := SaveEventLog(c, &log, map[string]string{"Username": username, "Action": action}); err != nil {
+		ERROR(err)
+	}
+}
+
+// UnlockUserRoute
+var UnlockUserRoute = RouteInfo{
+	Name:   "解锁账号（该接口仅限root调用）",
+	Method: http.MethodPost,
+	Path:   "/user/unlock",
+	IntlMessages: map[string]string{
+		"acc_unlock_failed":     "Failed to unlock account",
+		"login_as_unauthorized": "Unauthorized operation",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		if c.SignInfo.UID != RootUID() {
+			return c.STDErr(fmt.Errorf("unauthorized operation: uid=%v", c.SignInfo.UID), "login_as_unauthorized")
+		}
+		var body struct {
+			UID uint `binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			return c.STDErr(err, "acc_unlock_failed")
+		}
+		var user User
+		db := c.IgnoreAuth().DB()
+		defer c.IgnoreAuth(true)
+		if err := db.Where("id = ?", body.UID).First(&user).Error; err != nil {
+			return c.STDErr(err, "acc_unlock_failed")
+		}
+		if err := UnlockUser(db, user.ID); err != nil {
+			return c.STDErr(err, "acc_unlock_failed")
+		}
+		DelCache(getFailedTimesKey(user.Username))
+		log := EventLog{
+			EventClass:   EventLogClassSecurity,
+			EventSubject: "login_unlocked",
+			EventSummary: fmt.Sprintf("account unlocked: username=%s", user.Username),
+			EventData:    JSONStringify(D{"UID": user.ID, "LockedUntil": user.LockedUntil}),
+			UserID:       c.SignInfo.UID,
+			UserName:     c.SignInfo.Username,
+		}
+		if err := SaveEventLog(c, &log, map[string]string{"Username": user.Username, "Action": "unlocked"}); err != nil {
+			ERROR(err)
+		}
+		return c.STDOK()
+	},
+}
@@ -0,0 +1,43 @@
+package kuu
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockoutPolicyLockDuration(t *testing.T) {
+	policy := LockoutPolicy{BaseSeconds: 60, Multiplier: 2, MaxSeconds: 600}
+	want := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 10 * time.Minute, 10 * time.Minute}
+	for i, d := range want {
+		if got := policy.LockDuration(i); got != d {
+			t.Errorf("lock %d: want %v, got %v", i, d, got)
+		}
+	}
+}
+
+func TestLockoutPolicySubnetOf(t *testing.T) {
+	policy := LockoutPolicy{SubnetPrefixV4: 24, SubnetPrefixV6: 64}
+	cases := map[string]string{
+		"192.168.1.23":         "192.168.1.0/24",
+		"10.0.0.1":             "10.0.0.0/24",
+		"2001:db8:1:2:3:4:5:6": "2001:db8:1:2::/64",
+		"not-an-ip":            "",
+	}
+	for ip, want := range cases {
+		if got := policy.SubnetOf(ip); got != want {
+			t.Errorf("%s: want %s, got %s", ip, want, got)
+		}
+	}
+}
+
+func TestGetLockoutPolicyDefaults(t *testing.T) {
+	// 未配置时不锁定账号、不限制IP和网段
+	if policy := GetLockoutPolicy(); policy.MaxFailures != 0 || policy.IPMaxFailures != 0 || policy.SubnetMaxFailures != 0 {
+		t.Errorf("lockout should be disabled by default: %+v", policy)
+	}
+	C(map[string]interface{}{"lockout": map[string]interface{}{"maxFailures": 5, "ipMaxFailures": 30}})
+	defer C(map[string]interface{}{"lockout": nil})
+	if policy := GetLockoutPolicy(); policy.MaxFailures != 5 || policy.IPMaxFailures != 30 || policy.FailureWindowSeconds != 900 {
+		t.Errorf("unexpected configured policy: %+v", policy)
+	}
+}
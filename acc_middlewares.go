@@ -15,6 +15,11 @@ func AuthMiddleware(c *Context) *STDReply {
 				return c.AbortErrWithCode(err, 556, "acc_incorrect_token", "Incorrect token type")
 			}
 
+			// API Key来源IP检查
+			if scope := sign.Secret.GetAPIKeyScope(); !scope.AllowIP(c.TrustedClientIP()) {
+				return c.AbortErrWithCode(ErrAPIKeyIPDenied, 557, "apikey_ip_denied", "API Key is not allowed from this IP")
+			}
+
 			var prisDesc *PrivilegesDesc
 
 			// 在中间件模式下手动设置权限描述信息
@@ -28,6 +33,14 @@ func AuthMiddleware(c *Context) *STDReply {
 			if prisDesc != nil && !prisDesc.HasAPIPermission(c.Request.Method, c.Request.URL.Path) {
 				return c.AbortErrWithCode(nil, 557, "api_permission_denied", "API access denied")
 			}
+			// 密码过期或被要求修改密码时，仅允许访问修改密码等接口
+			if sign.Type != HMACSignType && sign.Secret != nil && !sign.Secret.IsAPIKey.Bool && !c.matchRules(PasswordExpiredWhitelist) {
+				if user := GetUserFromCache(sign.UID); IsPasswordExpired(&user) {
+					return c.AbortErrWithCode(ErrPasswordExpired, 558, "acc_password_expired", "Password expired, please change your password.")
+				}
+			}
+			// 更新会话活跃时间
+			touchSession(sign.Secret)
 
 			c.Next()
 		} else {
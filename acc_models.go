@@ -32,6 +32,59 @@ type SignSecret struct {
 	Method     string    `name:"登录/登出"`
 	IsAPIKey   null.Bool `name:"是否API Key"`
 	Type       string    `name:"令牌类型"`
+
+	RefreshToken    string    `name:"刷新令牌(哈希)" gorm:"INDEX:kuu_refresh_token" json:"-"`
+	RefreshExp      int64     `name:"刷新令牌过期时间戳"`
+	RefreshRotated  null.Bool `name:"刷新令牌是否已轮换"`
+	Family          string    `name:"令牌族ID" gorm:"INDEX:kuu_token_family"`
+	RawRefreshToken string    `gorm:"-" json:"-"`
+
+	IP         string `name:"登录IP"`
+	UserAgent  string `name:"登录设备(User-Agent)" gorm:"size:512"`
+	LastSeenAt int64  `name:"最后活跃时间戳"`
+	Scope      string `name:"API Key权限范围(JSON-String)" gorm:"type:text"`
+}
+
+// SignApp 签名请求应用，AppID映射到服务用户，仅可通过root专用接口管理
+type SignApp struct {
+	gorm.Model `displayName:"签名应用"`
+	AppID      string    `name:"应用ID" gorm:"UNIQUE_INDEX:kuu_sign_app_id"`
+	Secret     string    `name:"应用密钥" json:"-"`
+	Name       string    `name:"应用名称"`
+	UID        uint      `name:"服务用户ID"`
+	Disable    null.Bool `name:"是否禁用"`
+}
+
+// AfterSave
+func (a *SignApp) AfterSave(tx *gorm.DB) {
+	appID := a.AppID
+	if appID == "" && a.ID != 0 {
+		var app SignApp
+		if err := tx.Select("app_id").Where("id = ?", a.ID).First(&app).Error; err == nil {
+			appID = app.AppID
+		}
+	}
+	if appID != "" {
+		DelCache(getSignAppCacheKey(appID))
+	}
+}
+
+// AfterDelete
+func (a *SignApp) AfterDelete() {
+	if a.AppID != "" {
+		DelCache(getSignAppCacheKey(a.AppID))
+	}
+}
+
+// SigningKey 令牌签名密钥（RS256/ES256）
+type SigningKey struct {
+	gorm.Model `displayName:"令牌签名密钥"`
+	Kid        string    `name:"密钥ID" gorm:"UNIQUE_INDEX:kuu_signing_key_kid"`
+	Alg        string    `name:"签名算法"`
+	PrivateKey string    `name:"私钥(PEM)" gorm:"type:text" json:"-"`
+	PublicKey  string    `name:"公钥(PEM)" gorm:"type:text"`
+	Active     null.Bool `name:"是否用于签名"`
+	ExpiresAt  int64     `name:"停用后的过期时间戳"`
 }
 
 // SignContext
@@ -81,3 +134,24 @@ func (s *SignContext) getPayloadExp() int64 {
 	}
 	return 0
 }
+
+// TwoFactorAuth
+type TwoFactorAuth struct {
+	gorm.Model    `displayName:"双因素认证"`
+	UID           uint       `name:"用户ID" gorm:"NOT NULL;UNIQUE_INDEX:kuu_two_factor_uid"`
+	Secret        string     `name:"TOTP密钥" json:"-"`
+	Enabled       null.Bool  `name:"是否已启用"`
+	EnabledAt     *time.Time `name:"启用时间"`
+	RecoveryCodes string     `name:"恢复码(哈希JSON数组)" gorm:"type:text" json:"-"`
+	LastUsedStep  int64      `name:"最近使用的时间步" json:"-"`
+}
+
+// TwoFactorDevice
+type TwoFactorDevice struct {
+	gorm.Model `displayName:"双因素认证受信设备"`
+	UID        uint   `name:"用户ID" gorm:"NOT NULL"`
+	Token      string `name:"设备令牌(哈希)" gorm:"NOT NULL;INDEX:kuu_two_factor_device_token" json:"-"`
+	UserAgent  string `name:"设备User-Agent" gorm:"size:512"`
+	IP         string `name:"设备IP"`
+	Exp        int64  `name:"过期时间戳"`
+}
@@ -31,31 +31,92 @@ var LoginRoute = RouteInfo{
 			}
 			return c.STDErr(resp.Error, "acc_login_failed")
 		}
-		// 调用令牌签发
-		secretData, err := GenToken(GenTokenDesc{
-			UID:      resp.UID,
-			Username: resp.Username,
-			Payload:  resp.Payload,
-			Exp:      time.Now().Add(time.Second * time.Duration(ExpiresSeconds)).Unix(),
-			Type:     AdminSignType,
-		})
+		// 双因素认证
+		challenge, err := NewTwoFactorChallenge(c, resp)
 		if err != nil {
 			return c.STDErr(err, "acc_login_failed")
 		}
-		// 设置到上下文中
-		c.Set("__kuu_sign_context__", &SignContext{
-			Token:   secretData.Token,
-			UID:     secretData.UID,
-			Payload: resp.Payload,
-			Secret:  secretData,
+		if challenge != nil {
+			return c.STD(challenge)
+		}
+		return issueLoginToken(c, resp, "acc_login_failed")
+	},
+}
+
+func issueLoginToken(c *Context, resp *LoginHandlerResponse, failedMessageID string) *STDReply {
+	// 调用令牌签发
+	desc := GenTokenDesc{
+		UID:       resp.UID,
+		Username:  resp.Username,
+		Payload:   resp.Payload,
+		Exp:       time.Now().Add(time.Second * time.Duration(AccessTokenExpiresSeconds())).Unix(),
+		Type:      AdminSignType,
+		IP:        c.TrustedClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+	if refreshExpiresSeconds := RefreshTokenExpiresSeconds(); refreshExpiresSeconds > 0 {
+		desc.RefreshExp = time.Now().Add(time.Second * time.Duration(refreshExpiresSeconds)).Unix()
+	}
+	secretData, err := GenToken(desc)
+	if err != nil {
+		return c.STDErr(err, failedMessageID)
+	}
+	// 设置到上下文中
+	c.Set("__kuu_sign_context__", &SignContext{
+		Token:   secretData.Token,
+		UID:     secretData.UID,
+		Payload: resp.Payload,
+		Secret:  secretData,
+	})
+	// 设置Cookie
+	c.SetCookie(LangKey, resp.Lang, ExpiresSeconds, "/", "", false, true)
+	setTokenCookies(c, secretData)
+	// 清空验证码Cookie和缓存
+	c.SetCookie(CaptchaIDKey, "", -1, "/", "", false, true)
+	DelCache(getFailedTimesKey(resp.Username))
+	return c.STD(resp.Payload)
+}
+
+func setTokenCookies(c *Context, secretData *SignSecret) {
+	c.SetCookie(TokenKey, secretData.Token, int(secretData.Exp-secretData.Iat), "/", "", false, true)
+	if secretData.RawRefreshToken != "" {
+		c.SetCookie(RefreshTokenKey, secretData.RawRefreshToken, int(secretData.RefreshExp-secretData.Iat), "/", "", false, true)
+	}
+}
+
+// RefreshTokenRoute
+var RefreshTokenRoute = RouteInfo{
+	Name:   "刷新令牌接口",
+	Method: "POST",
+	Path:   "/token/refresh",
+	IntlMessages: map[string]string{
+		"acc_refresh_failed": "Refresh token invalid or expired, please login again.",
+		"acc_refresh_reused": "Refresh token has been used, all related sessions have been signed out.",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		var body struct {
+			RefreshToken string
+		}
+		_ = c.ShouldBindJSON(&body)
+		if body.RefreshToken == "" {
+			body.RefreshToken = c.GetKey(RefreshTokenKey)
+		}
+		secretData, err := RotateRefreshToken(body.RefreshToken)
+		if err != nil {
+			c.SetCookie(TokenKey, "", -1, "/", "", false, true)
+			c.SetCookie(RefreshTokenKey, "", -1, "/", "", false, true)
+			if err == ErrRefreshTokenReused {
+				return c.STDErr(err, "acc_refresh_reused")
+			}
+			return c.STDErr(err, "acc_refresh_failed")
+		}
+		setTokenCookies(c, secretData)
+		return c.STD(D{
+			TokenKey:        secretData.Token,
+			RefreshTokenKey: secretData.RawRefreshToken,
+			"Exp":           secretData.Exp,
+			"RefreshExp":    secretData.RefreshExp,
 		})
-		// 设置Cookie
-		c.SetCookie(LangKey, resp.Lang, ExpiresSeconds, "/", "", false, true)
-		c.SetCookie(TokenKey, secretData.Token, ExpiresSeconds, "/", "", false, true)
-		// 清空验证码Cookie和缓存
-		c.SetCookie(CaptchaIDKey, "", -1, "/", "", false, true)
-		DelCache(getFailedTimesKey(resp.Username))
-		return c.STD(resp.Payload)
 	},
 }
 
@@ -79,15 +140,12 @@ func Logout(c *Context, tx *gorm.DB) error {
 	if c.SignInfo != nil && c.SignInfo.IsValid() {
 		secret := c.SignInfo.Secret
 		if secret.ID != 0 {
-			if err := tx.Model(&secret).Updates(&SignSecret{Method: SignMethodLogout}).Error; err != nil {
+			if err := revokeSignSecret(tx, secret); err != nil {
 				return err
 			}
-			// 删除令牌缓存
-			DelCache(secret.Token)
-			// 保存登出历史
-			saveHistory(secret)
 			// 设置Cookie过期
 			c.SetCookie(TokenKey, secret.Token, -1, "/", "", false, true)
+			c.SetCookie(RefreshTokenKey, "", -1, "/", "", false, true)
 			c.SetCookie(LangKey, "", -1, "/", "", false, true)
 		}
 	}
@@ -135,6 +193,9 @@ var APIKeyRoute = RouteInfo{
 		"apikeys_failed": "Create API Keys failed.",
 	},
 	HandlerFunc: func(c *Context) *STDReply {
+		if isAPIKeyCaller(c) {
+			return c.STDErr(ErrAPIKeyCaller, "apikeys_failed")
+		}
 		var body GenTokenDesc
 		if err := c.ShouldBindJSON(&body); err != nil {
 			return c.STDErr(err, "apikeys_failed")
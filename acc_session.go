@@ -0,0 +1,275 @@
+package kuu
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// SessionTouchInterval 会话最后活跃时间的最小更新间隔
+var SessionTouchInterval = time.Minute
+
+var (
+	sessionTouchedMap     sync.Map
+	sessionTouchedSweptAt int64 // 上次清理sessionTouchedMap的时间（UnixNano）
+)
+
+// Session 活跃会话
+type Session struct {
+	ID         uint
+	UID        uint
+	Username   string
+	Type       string
+	IP         string
+	UserAgent  string
+	CreatedAt  time.Time
+	LastSeenAt int64
+	Exp        int64
+	Current    bool
+}
+
+// revokeSignSecret 吊销令牌并清除缓存
+func revokeSignSecret(tx *gorm.DB, secret *SignSecret) error {
+	if err := tx.Model(&SignSecret{}).Where("id = ?", secret.ID).Updates(&SignSecret{Method: SignMethodLogout}).Error; err != nil {
+		return err
+	}
+	secret.Method = SignMethodLogout
+	sessionTouchedMap.Delete(secret.ID)
+	// 删除令牌缓存
+	DelCache(secret.Token)
+	// 保存登出历史
+	saveHistory(secret)
+	return nil
+}
+
+// revokeSignSecrets 吊销查询条件匹配的所有有效令牌，返回吊销数量
+func revokeSignSecrets(tx *gorm.DB, query *gorm.DB) (int, error) {
+	var secrets []SignSecret
+	if err := query.Where("method <> ?", SignMethodLogout).Find(&secrets).Error; err != nil {
+		return 0, err
+	}
+	for i := range secrets {
+		if err := revokeSignSecret(tx, &secrets[i]); err != nil {
+			return i, err
+		}
+	}
+	return len(secrets), nil
+}
+
+func activeSessionsQuery(tx *gorm.DB, uid uint) *gorm.DB {
+	now := time.Now().Unix()
+	return tx.Model(&SignSecret{}).
+		Where(&SignSecret{UID: uid, Method: SignMethodLogin}).
+		// 访问令牌已过期但刷新令牌仍有效的会话仍可续期，同样视为活跃会话
+		Where(fmt.Sprintf("%s > ? OR %s > ?", tx.Dialect().Quote("exp"), tx.Dialect().Quote("refresh_exp")), now, now).
+		Where("is_api_key IS NULL OR is_api_key = ?", false)
+}
+
+// GetUserSessions 查询用户的活跃会话（不含API Key）
+func GetUserSessions(tx *gorm.DB, uid uint, currentToken string) ([]Session, error) {
+	var secrets []SignSecret
+	if uid == 0 {
+		return nil, nil
+	}
+	if err := activeSessionsQuery(tx, uid).Order("created_at desc").Find(&secrets).Error; err != nil {
+		return nil, err
+	}
+	sessions := make([]Session, 0, len(secrets))
+	for _, item := range secrets {
+		sessions = append(sessions, Session{
+			ID:         item.ID,
+			UID:        item.UID,
+			Username:   item.Username,
+			Type:       item.Type,
+			IP:         item.IP,
+			UserAgent:  item.UserAgent,
+			CreatedAt:  item.CreatedAt,
+			LastSeenAt: item.LastSeenAt,
+			Exp:        item.Exp,
+			Current:    currentToken != "" && item.Token == currentToken,
+		})
+	}
+	return sessions, nil
+}
+
+// RevokeUserSessions 吊销用户的所有活跃会话，exceptTokens中的令牌除外
+func RevokeUserSessions(tx *gorm.DB, uid uint, exceptTokens ...string) (int, error) {
+	if uid == 0 {
+		return 0, nil
+	}
+	query := activeSessionsQuery(tx, uid)
+	if len(exceptTokens) > 0 {
+		query = query.Where("token NOT IN (?)", exceptTokens)
+	}
+	return revokeSignSecrets(tx, query)
+}
+
+// RevokeUserTokens 吊销用户的所有令牌（含API Key），用于禁用用户等场景
+func RevokeUserTokens(tx *gorm.DB, uid uint) (int, error) {
+	if uid == 0 {
+		return 0, nil
+	}
+	return revokeSignSecrets(tx, tx.Model(&SignSecret{}).Where(&SignSecret{UID: uid}))
+}
+
+// touchSession 更新会话最后活跃时间
+func touchSession(secret *SignSecret) {
+	if secret == nil || secret.ID == 0 {
+		return
+	}
+	now := time.Now()
+	if v, ok := sessionTouchedMap.Load(secret.ID); ok && now.Sub(v.(time.Time)) < SessionTouchInterval {
+		return
+	}
+	sessionTouchedMap.Store(secret.ID, now)
+	sweepSessionTouched(now)
+	if err := DB().Model(&SignSecret{}).Where("id = ?", secret.ID).UpdateColumn("last_seen_at", now.Unix()).Error; err != nil {
+		ERROR(err)
+	}
+}
+
+// sweepSessionTouched 清理超过更新间隔的记录，避免已过期的会话一直留在内存中，每个间隔最多清理一次
+func sweepSessionTouched(now time.Time) {
+	last := atomic.LoadInt64(&sessionTouchedSweptAt)
+	if now.UnixNano()-last < int64(SessionTouchInterval) || !atomic.CompareAndSwapInt64(&sessionTouchedSweptAt, last, now.UnixNano()) {
+		return
+	}
+	sessionTouchedMap.Range(func(key, value interface{}) bool {
+		if now.Sub(value.(time.Time)) >= SessionTouchInterval {
+			sessionTouchedMap.Delete(key)
+		}
+		return true
+	})
+}
+
+func sessionTargetUID(c *Context, v string) (uint, error) {
+	uid := ParseID(v)
+	if uid == 0 || uid == c.SignInfo.UID {
+		return c.SignInfo.UID, nil
+	}
+	if c.SignInfo.UID != RootUID() {
+		return 0, fmt.Errorf("unauthorized operation: uid=%v", c.SignInfo.UID)
+	}
+	return uid, nil
+}
+
+// SessionsRoute
+var SessionsRoute = RouteInfo{
+	Name:   "查询活跃会话（root可通过uid参数查询任意用户）",
+	Method: http.MethodGet,
+	Path:   "/sessions",
+	IntlMessages: map[string]string{
+		"acc_sessions_failed":   "Failed to query sessions",
+		"login_as_unauthorized": "Unauthorized operation",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		uid, err := sessionTargetUID(c, c.Query("uid"))
+		if err != nil {
+			return c.STDErr(err, "login_as_unauthorized")
+		}
+		sessions, err := GetUserSessions(c.IgnoreAuth().DB(), uid, c.SignInfo.Token)
+		c.IgnoreAuth(true)
+		if err != nil {
+			return c.STDErr(err, "acc_sessions_failed")
+		}
+		return c.STD(sessions)
+	},
+}
+
+// RevokeSessionRoute
+var RevokeSessionRoute = RouteInfo{
+	Name:   "吊销指定会话",
+	Method: http.MethodDelete,
+	Path:   "/sessions/:id",
+	IntlMessages: map[string]string{
+		"acc_session_revoke_failed": "Failed to revoke session",
+		"login_as_unauthorized":     "Unauthorized operation",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		id := ParseID(c.Param("id"))
+		if id == 0 {
+			return c.STDErr(errors.New("session id is required"), "acc_session_revoke_failed")
+		}
+		var (
+			secret SignSecret
+			db     = c.IgnoreAuth().DB()
+		)
+		defer c.IgnoreAuth(true)
+		if err := db.Where("id = ?", id).First(&secret).Error; err != nil {
+			return c.STDErr(err, "acc_session_revoke_failed")
+		}
+		if secret.UID != c.SignInfo.UID && c.SignInfo.UID != RootUID() {
+			return c.STDErr(fmt.Errorf("unauthorized operation: uid=%v", c.SignInfo.UID), "login_as_unauthorized")
+		}
+		if secret.Method == SignMethodLogout {
+			return c.STDOK()
+		}
+		if err := revokeSignSecret(db, &secret); err != nil {
+			return c.STDErr(err, "acc_session_revoke_failed")
+		}
+		if secret.Token == c.SignInfo.Token {
+			c.SetCookie(TokenKey, "", -1, "/", "", false, true)
+			c.SetCookie(RefreshTokenKey, "", -1, "/", "", false, true)
+		}
+		return c.STDOK()
+	},
+}
+
+// RevokeOtherSessionsRoute
+var RevokeOtherSessionsRoute = RouteInfo{
+	Name:   "吊销当前会话以外的所有会话",
+	Method: http.MethodPost,
+	Path:   "/sessions/revoke_others",
+	IntlMessages: map[string]string{
+		"acc_session_revoke_failed": "Failed to revoke session",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		var n int
+		err := c.IgnoreAuth().WithTransaction(func(tx *gorm.DB) (err error) {
+			n, err = RevokeUserSessions(tx, c.SignInfo.UID, c.SignInfo.Token)
+			return
+		})
+		c.IgnoreAuth(true)
+		if err != nil {
+			return c.STDErr(err, "acc_session_revoke_failed")
+		}
+		return c.STD(n)
+	},
+}
+
+// RevokeAllSessionsRoute
+var RevokeAllSessionsRoute = RouteInfo{
+	Name:   "强制用户退出所有会话（该接口仅限root调用）",
+	Method: http.MethodPost,
+	Path:   "/sessions/revoke_all",
+	IntlMessages: map[string]string{
+		"acc_session_revoke_failed": "Failed to revoke session",
+		"login_as_unauthorized":     "Unauthorized operation",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		if c.SignInfo.UID != RootUID() {
+			return c.STDErr(fmt.Errorf("unauthorized operation: uid=%v", c.SignInfo.UID), "login_as_unauthorized")
+		}
+		var body struct {
+			UID uint `binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			return c.STDErr(err, "acc_session_revoke_failed")
+		}
+		var n int
+		err := c.IgnoreAuth().WithTransaction(func(tx *gorm.DB) (err error) {
+			n, err = RevokeUserSessions(tx, body.UID)
+			return
+		})
+		c.IgnoreAuth(true)
+		if err != nil {
+			return c.STDErr(err, "acc_session_revoke_failed")
+		}
+		return c.STD(n)
+	},
+}
@@ -0,0 +1,28 @@
+package kuu
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSweepSessionTouched(t *testing.T) {
+	now := time.Now()
+	sessionTouchedMap.Store(uint(1), now.Add(-2*SessionTouchInterval))
+	sessionTouchedMap.Store(uint(2), now)
+	defer sessionTouchedMap.Delete(uint(2))
+
+	sweepSessionTouched(now)
+	if _, ok := sessionTouchedMap.Load(uint(1)); ok {
+		t.Error("expired entry should be evicted")
+	}
+	if _, ok := sessionTouchedMap.Load(uint(2)); !ok {
+		t.Error("recent entry should be kept")
+	}
+	// 同一间隔内不重复清理
+	sessionTouchedMap.Store(uint(3), now.Add(-2*SessionTouchInterval))
+	defer sessionTouchedMap.Delete(uint(3))
+	sweepSessionTouched(now.Add(time.Second))
+	if _, ok := sessionTouchedMap.Load(uint(3)); !ok {
+		t.Error("sweep should run at most once per interval")
+	}
+}
@@ -0,0 +1,787 @@
+package kuu
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// 数据操作类型
+const (
+	AuthActionRead   = "read"
+	AuthActionCreate = "create"
+	AuthActionUpdate = "update"
+	AuthActionDelete = "delete"
+)
+
+var (
+	ErrAccessPolicyInvalid = errors.New("invalid access policy")
+	ErrAccessPolicyDenied  = errors.New("access denied by policy")
+)
+
+// AccessPolicy 基于属性的数据访问策略（ABAC），对应配置项accessPolicies
+//
+// Condition为表达式，裸字段名（或record.字段名）表示记录字段，user.属性名表示当前用户属性，如：
+//
+//	Region in user.Regions and Amount < 10000
+//
+// 用户属性包括UID、Username、OrgID、ActOrgID、Roles、Permissions、ReadableOrgIDs、WritableOrgIDs，
+// 以及令牌载荷中的自定义属性（参考SetPayloadAttrs）。
+//
+// 同一模型和操作存在多条适用策略时满足其一即可；用户不匹配任何策略的角色时，不额外限制。
+type AccessPolicy struct {
+	Name      string   `json:"name"`
+	Model     string   `json:"model"`     // 模型名称
+	Actions   []string `json:"actions"`   // 适用操作：read、create、update、delete，为空或*表示全部
+	Roles     []string `json:"roles"`     // 适用角色编码，为空表示全部用户
+	Condition string   `json:"condition"` // 条件表达式
+	expr      *policyExpr
+}
+
+// Compile 解析条件表达式
+func (p *AccessPolicy) Compile() error {
+	expr, err := ParsePolicyExpr(p.Condition)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrAccessPolicyInvalid, p.Name, err)
+	}
+	p.expr = expr
+	return nil
+}
+
+func (p *AccessPolicy) matchAction(action string) bool {
+	if len(p.Actions) == 0 {
+		return true
+	}
+	for _, item := range p.Actions {
+		if item == "*" || strings.EqualFold(item, action) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *AccessPolicy) matchRoles(desc *PrivilegesDesc) bool {
+	if len(p.Roles) == 0 {
+		return true
+	}
+	for _, code := range p.Roles {
+		if desc.HasRole(code) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	accessPoliciesMu         sync.RWMutex
+	accessPolicies           = make(map[string][]*AccessPolicy)
+	accessPoliciesConfigOnce sync.Once
+)
+
+// RegisterAccessPolicy 注册数据访问策略
+func RegisterAccessPolicy(policies ...AccessPolicy) error {
+	compiled := make([]*AccessPolicy, 0, len(policies))
+	for i := range policies {
+		policy := policies[i]
+		if policy.Model == "" {
+			return fmt.Errorf("%w: %s: model is required", ErrAccessPolicyInvalid, policy.Name)
+		}
+		if err := policy.Compile(); err != nil {
+			return err
+		}
+		compiled = append(compiled, &policy)
+	}
+	accessPoliciesMu.Lock()
+	defer accessPoliciesMu.Unlock()
+	for _, policy := range compiled {
+		accessPolicies[policy.Model] = append(accessPolicies[policy.Model], policy)
+	}
+	return nil
+}
+
+// GetAccessPolicies 查询对当前用户适用的策略
+func GetAccessPolicies(modelName, action string, desc *PrivilegesDesc) []*AccessPolicy {
+	accessPoliciesConfigOnce.Do(func() {
+		var list []AccessPolicy
+		C().GetInterface("accessPolicies", &list)
+		if err := RegisterAccessPolicy(list...); err != nil {
+			ERROR(err)
+		}
+	})
+	accessPoliciesMu.RLock()
+	defer accessPoliciesMu.RUnlock()
+	var matched []*AccessPolicy
+	for _, policy := range accessPolicies[modelName] {
+		if policy.matchAction(action) && policy.matchRoles(desc) {
+			matched = append(matched, policy)
+		}
+	}
+	return matched
+}
+
+// AddAccessPolicyWheres 将适用策略编译为SQL条件追加到查询中
+func AddAccessPolicyWheres(auth AuthProcessorDesc, action string) error {
+	sqls, attrs, err := GetAccessPolicyWheres(auth, action)
+	if err != nil {
+		return err
+	}
+	if len(sqls) > 0 {
+		auth.Scope.Search.Where(strings.Join(sqls, " OR "), attrs...)
+	}
+	return nil
+}
+
+// GetAccessPolicyWheres 返回适用策略编译后的SQL条件（多个条件之间为OR关系）
+func GetAccessPolicyWheres(auth AuthProcessorDesc, action string) (sqls []string, attrs []interface{}, err error) {
+	if auth.Meta == nil || !auth.PrisDesc.IsValid() || !auth.PrisDesc.NotRootUser() || isAuthIgnored() {
+		return
+	}
+	policies := GetAccessPolicies(auth.Meta.Name, action, auth.PrisDesc)
+	if len(policies) == 0 {
+		return
+	}
+	scope := auth.Scope
+	compiler := &policySQLCompiler{
+		user: newPolicyUserAttrs(auth.PrisDesc),
+		column: func(name string) (string, bool) {
+			if f, ok := scope.FieldByName(name); ok && f.IsNormal {
+				return fmt.Sprintf("%v.%v", scope.QuotedTableName(), scope.Quote(f.DBName)), true
+			}
+			return "", false
+		},
+	}
+	for _, policy := range policies {
+		sql, vars, err := compiler.compile(policy.expr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %s: %v", ErrAccessPolicyInvalid, policy.Name, err)
+		}
+		sqls = append(sqls, fmt.Sprintf("(%s)", sql))
+		attrs = append(attrs, vars...)
+	}
+	return
+}
+
+// CheckAccessPolicies 在内存中校验待写入的记录是否满足适用策略，更新时未修改的字段视为未知（由SQL条件保证）
+func CheckAccessPolicies(auth AuthProcessorDesc, action string) error {
+	if auth.Meta == nil || auth.Scope == nil || !auth.PrisDesc.IsValid() || !auth.PrisDesc.NotRootUser() || isAuthIgnored() {
+		return nil
+	}
+	policies := GetAccessPolicies(auth.Meta.Name, action, auth.PrisDesc)
+	if len(policies) == 0 {
+		return nil
+	}
+	scope := auth.Scope
+	var updateAttrs map[string]interface{}
+	if action == AuthActionUpdate {
+		if v, ok := scope.InstanceGet("gorm:update_attrs"); ok {
+			updateAttrs, _ = v.(map[string]interface{})
+		}
+	}
+	env := &policyEnv{
+		user: newPolicyUserAttrs(auth.PrisDesc),
+		record: func(name string) (interface{}, bool) {
+			f, ok := scope.FieldByName(name)
+			if !ok {
+				return nil, false
+			}
+			if updateAttrs != nil {
+				v, ok := updateAttrs[f.DBName]
+				return v, ok
+			}
+			return f.Field.Interface(), true
+		},
+	}
+	for _, policy := range policies {
+		if ok, known := policy.expr.eval(env); ok || !known {
+			return nil
+		}
+	}
+	return NewIntlError(fmt.Errorf("%w: model=%s, action=%s, uid=%d", ErrAccessPolicyDenied, auth.Meta.Name, action, auth.PrisDesc.UID), "access_policy_denied", "Operation is not allowed by data access policy.")
+}
+
+// isAuthIgnored 判断当前协程是否设置了忽略权限标记
+func isAuthIgnored() bool {
+	if caches := GetRoutineCaches(); caches != nil {
+		_, ignoreAuth := caches[GLSIgnoreAuthKey]
+		return ignoreAuth
+	}
+	return false
+}
+
+func newPolicyUserAttrs(desc *PrivilegesDesc) func(string) interface{} {
+	return func(name string) interface{} {
+		switch name {
+		case "UID":
+			return desc.UID
+		case "OrgID":
+			return desc.OrgID
+		case "ActOrgID":
+			return desc.ActOrgID
+		case "ActOrgCode":
+			return desc.ActOrgCode
+		case "Roles", "RolesCode":
+			return desc.RolesCode
+		case "Permissions":
+			return desc.Permissions
+		case "ReadableOrgIDs":
+			return desc.ReadableOrgIDs
+		case "WritableOrgIDs":
+			return desc.WritableOrgIDs
+		case "LoginableOrgIDs":
+			return desc.LoginableOrgIDs
+		}
+		if desc.SignInfo == nil {
+			return nil
+		}
+		if name == "Username" {
+			return desc.SignInfo.Username
+		}
+		if desc.SignInfo.Payload != nil {
+			return desc.SignInfo.Payload[name]
+		}
+		return nil
+	}
+}
+
+// policyOperand 表达式操作数
+type policyOperand struct {
+	Kind  int // policyOperandField、policyOperandUser、policyOperandLiteral
+	Name  string
+	Value interface{}
+	List  []policyOperand
+}
+
+const (
+	policyOperandField = iota + 1
+	policyOperandUser
+	policyOperandLiteral
+	policyOperandList
+)
+
+// policyExpr 表达式语法树
+type policyExpr struct {
+	Op       string // and、or、not、cmp
+	Children []*policyExpr
+	CmpOp    string // ==、!=、<、<=、>、>=、in、not in
+	Left     policyOperand
+	Right    policyOperand
+}
+
+// ParsePolicyExpr 解析策略表达式
+func ParsePolicyExpr(s string) (*policyExpr, error) {
+	tokens, err := tokenizePolicyExpr(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("empty expression")
+	}
+	p := &policyParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected token %q", p.tokens[p.pos].text)
+	}
+	return expr, nil
+}
+
+type policyToken struct {
+	kind string // ident、number、string、op
+	text string
+}
+
+func tokenizePolicyExpr(s string) ([]policyToken, error) {
+	var tokens []policyToken
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsLetter(r) || r == '_':
+			j := i
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_' || runes[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, policyToken{"ident", string(runes[i:j])})
+			i = j
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, policyToken{"number", string(runes[i:j])})
+			i = j
+		case r == '\'' || r == '"':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(runes) && runes[j] != r; j++ {
+				if runes[j] == '\\' && j+1 < len(runes) {
+					j++
+				}
+				sb.WriteRune(runes[j])
+			}
+			if j >= len(runes) {
+				return nil, errors.New("unterminated string")
+			}
+			tokens = append(tokens, policyToken{"string", sb.String()})
+			i = j + 1
+		default:
+			if i+1 < len(runes) {
+				if two := string(runes[i : i+2]); two == "==" || two == "!=" || two == "<=" || two == ">=" || two == "&&" || two == "||" {
+					tokens = append(tokens, policyToken{"op", two})
+					i += 2
+					continue
+				}
+			}
+			if strings.ContainsRune("=<>!()[],", r) {
+				tokens = append(tokens, policyToken{"op", string(r)})
+				i++
+				continue
+			}
+			return nil, fmt.Errorf("unexpected character %q", r)
+		}
+	}
+	return tokens, nil
+}
+
+type policyParser struct {
+	tokens []policyToken
+	pos    int
+}
+
+func (p *policyParser) peek() (policyToken, bool) {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos], true
+	}
+	return policyToken{}, false
+}
+
+func (p *policyParser) isKeyword(t policyToken, words ...string) bool {
+	for _, w := range words {
+		if (t.kind == "ident" || t.kind == "op") && strings.EqualFold(t.text, w) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *policyParser) parseOr() (*policyExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t, ok := p.peek()
+		if !ok || !p.isKeyword(t, "or", "||") {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &policyExpr{Op: "or", Children: []*policyExpr{left, right}}
+	}
+}
+
+func (p *policyParser) parseAnd() (*policyExpr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t, ok := p.peek()
+		if !ok || !p.isKeyword(t, "and", "&&") {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &policyExpr{Op: "and", Children: []*policyExpr{left, right}}
+	}
+}
+
+func (p *policyParser) parseNot() (*policyExpr, error) {
+	t, ok := p.peek()
+	if !ok {
+		return nil, errors.New("unexpected end of expression")
+	}
+	if p.isKeyword(t, "not", "!") {
+		p.pos++
+		child, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &policyExpr{Op: "not", Children: []*policyExpr{child}}, nil
+	}
+	if t.kind == "op" && t.text == "(" {
+		p.pos++
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t, ok := p.peek(); !ok || t.text != ")" {
+			return nil, errors.New("expected ')'")
+		}
+		p.pos++
+		return expr, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *policyParser) parseComparison() (*policyExpr, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	expr := &policyExpr{Op: "cmp", Left: left}
+	t, ok := p.peek()
+	switch {
+	case ok && t.kind == "op" && (t.text == "==" || t.text == "=" || t.text == "!=" || t.text == "<" || t.text == "<=" || t.text == ">" || t.text == ">="):
+		expr.CmpOp = t.text
+		if expr.CmpOp == "=" {
+			expr.CmpOp = "=="
+		}
+		p.pos++
+	case ok && p.isKeyword(t, "in"):
+		expr.CmpOp = "in"
+		p.pos++
+	case ok && p.isKeyword(t, "not") && p.pos+1 < len(p.tokens) && p.isKeyword(p.tokens[p.pos+1], "in"):
+		expr.CmpOp = "not in"
+		p.pos += 2
+	default:
+		// 单独的操作数视为布尔值
+		expr.CmpOp = "=="
+		expr.Right = policyOperand{Kind: policyOperandLiteral, Value: true}
+		return expr, nil
+	}
+	if expr.Right, err = p.parseOperand(); err != nil {
+		return nil, err
+	}
+	return expr, nil
+}
+
+func (p *policyParser) parseOperand() (policyOperand, error) {
+	t, ok := p.peek()
+	if !ok {
+		return policyOperand{}, errors.New("unexpected end of expression")
+	}
+	p.pos++
+	switch t.kind {
+	case "number":
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return policyOperand{}, err
+		}
+		return policyOperand{Kind: policyOperandLiteral, Value: v}, nil
+	case "string":
+		return policyOperand{Kind: policyOperandLiteral, Value: t.text}, nil
+	case "ident":
+		switch strings.ToLower(t.text) {
+		case "true":
+			return policyOperand{Kind: policyOperandLiteral, Value: true}, nil
+		case "false":
+			return policyOperand{Kind: policyOperandLiteral, Value: false}, nil
+		case "null", "nil":
+			return policyOperand{Kind: policyOperandLiteral}, nil
+		}
+		if strings.HasPrefix(t.text, "user.") {
+			return policyOperand{Kind: policyOperandUser, Name: strings.TrimPrefix(t.text, "user.")}, nil
+		}
+		return policyOperand{Kind: policyOperandField, Name: strings.TrimPrefix(t.text, "record.")}, nil
+	case "op":
+		if t.text == "[" {
+			list := policyOperand{Kind: policyOperandList}
+			for {
+				if t, ok := p.peek(); ok && t.text == "]" {
+					p.pos++
+					return list, nil
+				}
+				item, err := p.parseOperand()
+				if err != nil {
+					return policyOperand{}, err
+				}
+				if item.Kind != policyOperandLiteral {
+					return policyOperand{}, errors.New("list items must be literals")
+				}
+				list.List = append(list.List, item)
+				if t, ok := p.peek(); ok && t.text == "," {
+					p.pos++
+				}
+			}
+		}
+	}
+	return policyOperand{}, fmt.Errorf("unexpected token %q", t.text)
+}
+
+// policyEnv 内存求值环境
+type policyEnv struct {
+	user   func(name string) interface{}
+	record func(name string) (interface{}, bool)
+}
+
+func (env *policyEnv) resolve(o policyOperand) (interface{}, bool) {
+	switch o.Kind {
+	case policyOperandUser:
+		return env.user(o.Name), true
+	case policyOperandField:
+		if env.record == nil {
+			return nil, false
+		}
+		return env.record(o.Name)
+	case policyOperandList:
+		list := make([]interface{}, len(o.List))
+		for i, item := range o.List {
+			list[i] = item.Value
+		}
+		return list, true
+	}
+	return o.Value, true
+}
+
+// eval 三值逻辑求值，known为false表示依赖的字段未知
+func (e *policyExpr) eval(env *policyEnv) (result bool, known bool) {
+	switch e.Op {
+	case "and":
+		known = true
+		for _, child := range e.Children {
+			v, k := child.eval(env)
+			if k && !v {
+				return false, true
+			}
+			known = known && k
+		}
+		return known, known
+	case "or":
+		known = true
+		for _, child := range e.Children {
+			v, k := child.eval(env)
+			if k && v {
+				return true, true
+			}
+			known = known && k
+		}
+		return false, known
+	case "not":
+		v, k := e.Children[0].eval(env)
+		return !v, k
+	}
+	left, lk := env.resolve(e.Left)
+	right, rk := env.resolve(e.Right)
+	if !lk || !rk {
+		return false, false
+	}
+	return comparePolicyValues(e.CmpOp, left, right), true
+}
+
+func normalizePolicyValue(v interface{}) interface{} {
+	if valuer, ok := v.(driver.Valuer); ok {
+		if dv, err := valuer.Value(); err == nil {
+			v = dv
+		}
+	}
+	if v == nil {
+		return nil
+	}
+	if t, ok := v.(time.Time); ok {
+		return t
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return nil
+		}
+		return normalizePolicyValue(rv.Elem().Interface())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	case reflect.String:
+		return rv.String()
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.Slice, reflect.Array:
+		list := make([]interface{}, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			list[i] = normalizePolicyValue(rv.Index(i).Interface())
+		}
+		return list
+	}
+	return v
+}
+
+func comparePolicyValues(op string, left, right interface{}) bool {
+	left, right = normalizePolicyValue(left), normalizePolicyValue(right)
+	switch op {
+	case "in", "not in":
+		var found bool
+		if right == nil {
+			// 用户属性不存在时视为空集合
+		} else if list, ok := right.([]interface{}); ok {
+			for _, item := range list {
+				if policyValuesEqual(left, item) {
+					found = true
+					break
+				}
+			}
+		} else {
+			found = policyValuesEqual(left, right)
+		}
+		return found == (op == "in")
+	case "==":
+		return policyValuesEqual(left, right)
+	case "!=":
+		return !policyValuesEqual(left, right)
+	}
+	var cmp int
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return false
+		}
+		cmp = compareOrdered(l < r, l > r)
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return false
+		}
+		cmp = strings.Compare(l, r)
+	case time.Time:
+		r, ok := right.(time.Time)
+		if !ok {
+			return false
+		}
+		cmp = compareOrdered(l.Before(r), l.After(r))
+	default:
+		return false
+	}
+	switch op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+func compareOrdered(less, greater bool) int {
+	if less {
+		return -1
+	}
+	if greater {
+		return 1
+	}
+	return 0
+}
+
+func policyValuesEqual(a, b interface{}) bool {
+	if ta, ok := a.(time.Time); ok {
+		tb, ok := b.(time.Time)
+		return ok && ta.Equal(tb)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// policySQLCompiler 将表达式编译为SQL条件
+type policySQLCompiler struct {
+	user   func(name string) interface{}
+	column func(name string) (string, bool)
+}
+
+var policySQLOps = map[string]string{"==": "=", "!=": "<>", "<": "<", "<=": "<=", ">": ">", ">=": ">="}
+
+var policySQLFlippedOps = map[string]string{"==": "==", "!=": "!=", "<": ">", "<=": ">=", ">": "<", ">=": "<="}
+
+func (c *policySQLCompiler) compile(e *policyExpr) (string, []interface{}, error) {
+	switch e.Op {
+	case "and", "or", "not":
+		var (
+			parts []string
+			vars  []interface{}
+		)
+		for _, child := range e.Children {
+			sql, v, err := c.compile(child)
+			if err != nil {
+				return "", nil, err
+			}
+			parts = append(parts, fmt.Sprintf("(%s)", sql))
+			vars = append(vars, v...)
+		}
+		if e.Op == "not" {
+			return fmt.Sprintf("NOT %s", parts[0]), vars, nil
+		}
+		return strings.Join(parts, fmt.Sprintf(" %s ", strings.ToUpper(e.Op))), vars, nil
+	}
+	left, right, op := e.Left, e.Right, e.CmpOp
+	if left.Kind != policyOperandField && right.Kind == policyOperandField {
+		if op == "in" || op == "not in" {
+			return "", nil, fmt.Errorf("field %s cannot be the right operand of %s", right.Name, op)
+		}
+		left, right, op = right, left, policySQLFlippedOps[op]
+	}
+	if left.Kind != policyOperandField {
+		// 与记录无关的条件直接求值
+		env := &policyEnv{user: c.user}
+		if v, _ := e.eval(env); v {
+			return "1 = 1", nil, nil
+		}
+		return "1 = 0", nil, nil
+	}
+	column, ok := c.column(left.Name)
+	if !ok {
+		return "", nil, fmt.Errorf("unknown field %s", left.Name)
+	}
+	if right.Kind == policyOperandField {
+		other, ok := c.column(right.Name)
+		if !ok {
+			return "", nil, fmt.Errorf("unknown field %s", right.Name)
+		}
+		if op == "in" || op == "not in" {
+			return "", nil, fmt.Errorf("field %s cannot be the right operand of %s", right.Name, op)
+		}
+		return fmt.Sprintf("%s %s %s", column, policySQLOps[op], other), nil, nil
+	}
+	value, _ := (&policyEnv{user: c.user}).resolve(right)
+	value = normalizePolicyValue(value)
+	switch op {
+	case "in", "not in":
+		list, ok := value.([]interface{})
+		if !ok && value != nil {
+			list = []interface{}{value}
+		}
+		if len(list) == 0 {
+			if op == "in" {
+				return "1 = 0", nil, nil
+			}
+			return "1 = 1", nil, nil
+		}
+		return fmt.Sprintf("%s %s (?)", column, strings.ToUpper(op)), []interface{}{list}, nil
+	case "==", "!=":
+		if value == nil {
+			if op == "==" {
+				return fmt.Sprintf("%s IS NULL", column), nil, nil
+			}
+			return fmt.Sprintf("%s IS NOT NULL", column), nil, nil
+		}
+	}
+	return fmt.Sprintf("%s %s ?", column, policySQLOps[op]), []interface{}{value}, nil
+}
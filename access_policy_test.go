@@ -0,0 +1,143 @@
+package kuu
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"gopkg.in/guregu/null.v3"
+)
+
+func testPolicyDesc() *PrivilegesDesc {
+	return &PrivilegesDesc{
+		UID:       10,
+		OrgID:     2,
+		RolesCode: []string{"sales"},
+		SignInfo: &SignContext{
+			UID:      10,
+			Username: "alice",
+			Payload:  jwt.MapClaims{"Regions": []interface{}{"east", "west"}, "Limit": float64(10000)},
+		},
+	}
+}
+
+func TestParsePolicyExpr(t *testing.T) {
+	valid := []string{
+		"Region in user.Regions and Amount < 10000",
+		"record.Amount <= user.Limit || (Status == 'open' && !Locked)",
+		"Region not in ['north', \"south\"] and OrgID = user.OrgID",
+		"not (CreatedByID != user.UID)",
+		"Remark == null or Remark != ''",
+	}
+	for _, s := range valid {
+		if _, err := ParsePolicyExpr(s); err != nil {
+			t.Errorf("%s: %v", s, err)
+		}
+	}
+	invalid := []string{"", "Amount <", "(Amount > 1", "Amount > 1)", "Region in [Status]", "Name == 'abc", "Amount # 1"}
+	for _, s := range invalid {
+		if _, err := ParsePolicyExpr(s); err == nil {
+			t.Errorf("%s: expected error", s)
+		}
+	}
+}
+
+func TestPolicyExprEval(t *testing.T) {
+	desc := testPolicyDesc()
+	cases := []struct {
+		expr   string
+		record map[string]interface{}
+		result bool
+		known  bool
+	}{
+		{"Region in user.Regions and Amount < user.Limit", map[string]interface{}{"Region": "east", "Amount": 500}, true, true},
+		{"Region in user.Regions and Amount < user.Limit", map[string]interface{}{"Region": "north", "Amount": 500}, false, true},
+		{"Region in user.Regions and Amount < user.Limit", map[string]interface{}{"Amount": 50000}, false, true},
+		{"Region in user.Regions and Amount < user.Limit", map[string]interface{}{"Amount": 500}, false, false},
+		{"Region in user.Regions or Amount < 100", map[string]interface{}{"Amount": 50}, true, true},
+		{"Region not in ['north'] and user.Username == 'alice'", map[string]interface{}{"Region": "east"}, true, true},
+		{"Remark == null", map[string]interface{}{"Remark": null.String{}}, true, true},
+		{"Remark != null", map[string]interface{}{"Remark": null.StringFrom("x")}, true, true},
+		{"'sales' in user.Roles", nil, true, true},
+		{"not (Amount >= 100)", map[string]interface{}{"Amount": uint(99)}, true, true},
+	}
+	for _, item := range cases {
+		expr, err := ParsePolicyExpr(item.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", item.expr, err)
+		}
+		record := item.record
+		env := &policyEnv{
+			user: newPolicyUserAttrs(desc),
+			record: func(name string) (interface{}, bool) {
+				v, ok := record[name]
+				return v, ok
+			},
+		}
+		result, known := expr.eval(env)
+		if known != item.known || (known && result != item.result) {
+			t.Errorf("%s %v: got (%v, %v), expected (%v, %v)", item.expr, item.record, result, known, item.result, item.known)
+		}
+	}
+}
+
+func TestPolicySQLCompiler(t *testing.T) {
+	compiler := &policySQLCompiler{
+		user: newPolicyUserAttrs(testPolicyDesc()),
+		column: func(name string) (string, bool) {
+			switch name {
+			case "Region", "Amount", "Remark", "CreatedByID":
+				return "t." + name, true
+			}
+			return "", false
+		},
+	}
+	cases := []struct {
+		expr string
+		sql  string
+		vars []interface{}
+	}{
+		{"Region in user.Regions and Amount < 10000", "(t.Region IN (?)) AND (t.Amount < ?)", []interface{}{[]interface{}{"east", "west"}, float64(10000)}},
+		{"user.UID == CreatedByID or Remark == null", "(t.CreatedByID = ?) OR (t.Remark IS NULL)", []interface{}{float64(10)}},
+		{"user.Limit > Amount", "t.Amount < ?", []interface{}{float64(10000)}},
+		{"Region in user.Unknown", "1 = 0", nil},
+		{"not ('admin' in user.Roles)", "NOT (1 = 0)", nil},
+		{"Amount != Region", "t.Amount <> t.Region", nil},
+	}
+	for _, item := range cases {
+		expr, err := ParsePolicyExpr(item.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", item.expr, err)
+		}
+		sql, vars, err := compiler.compile(expr)
+		if err != nil {
+			t.Errorf("%s: %v", item.expr, err)
+			continue
+		}
+		if sql != item.sql || !reflect.DeepEqual(vars, item.vars) {
+			t.Errorf("%s: got %q %v, expected %q %v", item.expr, sql, vars, item.sql, item.vars)
+		}
+	}
+	expr, _ := ParsePolicyExpr("Missing == 1")
+	if _, _, err := compiler.compile(expr); err == nil {
+		t.Error("expected unknown field error")
+	}
+}
+
+func TestAccessPolicyMatch(t *testing.T) {
+	policy := AccessPolicy{Name: "sales-read", Model: "Order", Actions: []string{"read"}, Roles: []string{"sales"}, Condition: "Amount < 100"}
+	if err := policy.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	desc := testPolicyDesc()
+	if !policy.matchAction(AuthActionRead) || policy.matchAction(AuthActionUpdate) {
+		t.Error("unexpected action match")
+	}
+	if !policy.matchRoles(desc) {
+		t.Error("expected role match")
+	}
+	desc.RolesCode = []string{"finance"}
+	if policy.matchRoles(desc) {
+		t.Error("unexpected role match")
+	}
+}
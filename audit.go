@@ -1,5 +1,16 @@
 package kuu
 
+import (
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	EventLogClassSecurity = "SECURITY"
+)
+
 type EventLog struct {
 	Model     `rest:"*" displayName:"事件日志"`
 	EventID   string `name:"事件ID（UUID）" gorm:"NOT NULL"`
@@ -31,3 +42,24 @@ type EventLogLabel struct {
 	LabelKey   string `name:"标签名" gorm:"NOT NULL;INDEX:event_log_label"`
 	LabelValue string `name:"标签值" gorm:"NOT NULL;INDEX:event_log_label"`
 }
+
+// SaveEventLog 保存事件日志，自动填充事件ID、事件时间和来源IP
+func SaveEventLog(c *Context, log *EventLog, labels map[string]string) error {
+	if log.EventID == "" {
+		log.EventID = strings.ReplaceAll(uuid.NewV4().String(), "-", "")
+	}
+	if log.EventTime == 0 {
+		log.EventTime = time.Now().Unix()
+	}
+	if log.SourceIP == "" && c != nil {
+		log.SourceIP = c.TrustedClientIP()
+	}
+	for key, value := range labels {
+		log.EventLabels = append(log.EventLabels, EventLogLabel{
+			EventClass: log.EventClass,
+			LabelKey:   key,
+			LabelValue: value,
+		})
+	}
+	return DB().Create(log).Error
+}
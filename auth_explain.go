@@ -0,0 +1,320 @@
+package kuu
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/jtolds/gls"
+)
+
+var (
+	ErrAuthExplainModel  = errors.New("model not found")
+	ErrAuthExplainAction = errors.New("unsupported action")
+)
+
+// AuthExplainSummary 用户权限概要
+type AuthExplainSummary struct {
+	UID                    uint
+	Username               string
+	IsRoot                 bool
+	OrgID                  uint
+	ActOrgID               uint
+	ActOrgName             string
+	RolesCode              []string
+	ReadableOrgIDs         []uint
+	WritableOrgIDs         []uint
+	PersonalReadableOrgIDs []uint
+	PersonalWritableOrgIDs []uint
+}
+
+// AuthExplainPrivilege 用户的数据权限及其与记录的匹配情况
+type AuthExplainPrivilege struct {
+	AssignedRoleID   uint
+	AssignedRoleCode string
+	SourceRoleID     uint // 继承时为祖先角色ID
+	TargetOrgID      uint
+	TargetOrgName    string
+	Range            string // 当前操作对应的可读或可写范围
+	Matched          bool   // 是否覆盖目标记录
+}
+
+// AuthExplain 数据权限诊断结果
+type AuthExplain struct {
+	Model           string
+	Action          string
+	RecordID        uint
+	Processor       string
+	Summary         AuthExplainSummary
+	DataScopeWheres []string
+	DataScopeVars   []interface{}
+	PolicyWheres    []string
+	PolicyVars      []interface{}
+	WhereSQL        string // 权限处理器生成的完整查询条件
+	WhereVars       []interface{}
+	RecordOrgID     uint
+	RecordCreatedBy uint
+	Privileges      []AuthExplainPrivilege
+	Allowed         *bool // 未指定记录且非新增操作时为空
+	Reason          string
+}
+
+// ExplainAuth 解释用户对模型数据的操作权限，action为read、create、update、delete
+func ExplainAuth(uid uint, modelName, action string, recordID uint) (*AuthExplain, error) {
+	meta := Meta(modelName)
+	if meta == nil || meta.reflectType == nil {
+		return nil, fmt.Errorf("%w: %s", ErrAuthExplainModel, modelName)
+	}
+	if action == "" {
+		action = AuthActionRead
+	}
+	action = strings.ToLower(action)
+	switch action {
+	case AuthActionRead, AuthActionCreate, AuthActionUpdate, AuthActionDelete:
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrAuthExplainAction, action)
+	}
+	reply := &AuthExplain{Model: meta.Name, Action: action, RecordID: recordID}
+
+	// 1.以目标用户身份计算权限
+	var (
+		user   User
+		record interface{}
+	)
+	err := func() error {
+		IgnoreAuth()
+		defer IgnoreAuth(true)
+
+		if err := DB().Where("id = ?", uid).First(&user).Error; err != nil {
+			return err
+		}
+		if recordID != 0 {
+			record = reflect.New(meta.reflectType).Interface()
+			if err := DB().First(record, recordID).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+	if err != nil {
+		return nil, err
+	}
+	sign := &SignContext{UID: user.ID, Username: user.Username, Type: AdminSignType}
+	desc := GetPrivilegesDesc(sign)
+	if desc == nil {
+		return nil, fmt.Errorf("failed to compute privileges: uid=%d", uid)
+	}
+	reply.Summary = AuthExplainSummary{
+		UID:                    desc.UID,
+		Username:               user.Username,
+		IsRoot:                 !desc.NotRootUser(),
+		OrgID:                  desc.OrgID,
+		ActOrgID:               desc.ActOrgID,
+		ActOrgName:             desc.ActOrgName,
+		RolesCode:              desc.RolesCode,
+		ReadableOrgIDs:         desc.ReadableOrgIDs,
+		WritableOrgIDs:         desc.WritableOrgIDs,
+		PersonalReadableOrgIDs: desc.PersonalReadableOrgIDs,
+		PersonalWritableOrgIDs: desc.PersonalWritableOrgIDs,
+	}
+	if record != nil {
+		scope := DB().NewScope(record)
+		if field, ok := scope.FieldByName("OrgID"); ok {
+			reply.RecordOrgID = explainUintValue(field.Field.Interface())
+		}
+		if field, ok := scope.FieldByName("CreatedByID"); ok {
+			reply.RecordCreatedBy = explainUintValue(field.Field.Interface())
+		}
+	}
+
+	// 2.在目标用户的协程上下文中执行权限处理器
+	values := gls.Values{
+		GLSPrisDescKey:      desc,
+		GLSSignInfoKey:      sign,
+		GLSRoutineCachesKey: make(RoutineCaches),
+	}
+	SetGLSValues(values, func() {
+		err = explainAuthWheres(reply, meta, desc, record)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 3.匹配的角色数据权限
+	if err := explainAuthPrivileges(reply, uid); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func explainAuthWheres(reply *AuthExplain, meta *Metadata, desc *PrivilegesDesc, record interface{}) error {
+	var (
+		processor = GetAuthProcessor(meta)
+		allowed   bool
+		reason    error
+	)
+	reply.Processor = fmt.Sprintf("%T", processor)
+	if reply.Action == AuthActionCreate {
+		value := record
+		if value == nil {
+			value = reflect.New(meta.reflectType).Interface()
+		}
+		scope := DB().NewScope(value)
+		auth := GetAuthProcessorDesc(scope, desc)
+		auth.Action = AuthActionCreate
+		auth.CreatedByID = desc.UID
+		auth.OrgID = desc.ActOrgID
+		if reply.RecordOrgID != 0 {
+			auth.OrgID = reply.RecordOrgID
+		}
+		reason = processor.AllowCreate(auth)
+		allowed = reason == nil
+		reply.Allowed = &allowed
+		if reason != nil {
+			reply.Reason = reason.Error()
+		}
+		return nil
+	}
+
+	orgIDs := desc.ReadableOrgIDs
+	if reply.Action != AuthActionRead {
+		orgIDs = desc.WritableOrgIDs
+	}
+	scope := DB().NewScope(reflect.New(meta.reflectType).Interface())
+	reply.DataScopeWheres, reply.DataScopeVars = GetDataScopeWheres(DB().NewScope(scope.Value), desc, orgIDs, desc.PersonalWritableOrgIDMap)
+	auth := GetAuthProcessorDesc(scope, desc)
+	auth.Action = reply.Action
+	sqls, vars, err := GetAccessPolicyWheres(auth, reply.Action)
+	if err != nil {
+		return err
+	}
+	reply.PolicyWheres, reply.PolicyVars = sqls, vars
+
+	if reply.Action == AuthActionUpdate {
+		// 不校验待更新的字段值，只关注记录能否被更新
+		scope.InstanceSet("gorm:update_attrs", map[string]interface{}{})
+	}
+	if reply.Action == AuthActionRead {
+		reason = processor.AddReadableWheres(auth)
+	} else {
+		reason = processor.AddWritableWheres(auth)
+	}
+	if reason != nil {
+		allowed = false
+		reply.Allowed = &allowed
+		reply.Reason = reason.Error()
+		return nil
+	}
+	reply.WhereSQL = scope.CombinedConditionSql()
+	reply.WhereVars = scope.SQLVars
+	if reply.RecordID == 0 {
+		return nil
+	}
+
+	// 按权限条件查询目标记录
+	scope.SQLVars = nil
+	scope.Search.Where(fmt.Sprintf("%v.%v = ?", scope.QuotedTableName(), scope.Quote("id")), reply.RecordID)
+	var count int
+	query := fmt.Sprintf("SELECT count(*) FROM %v %v", scope.QuotedTableName(), scope.CombinedConditionSql())
+	if err := scope.SQLDB().QueryRow(query, scope.SQLVars...).Scan(&count); err != nil {
+		return err
+	}
+	allowed = count > 0
+	reply.Allowed = &allowed
+	if !allowed {
+		reply.Reason = "record is out of the user's data scope"
+	}
+	return nil
+}
+
+func explainAuthPrivileges(reply *AuthExplain, uid uint) error {
+	IgnoreAuth()
+	defer IgnoreAuth(true)
+
+	user, err := GetUserWithRoles(uid)
+	if err != nil {
+		return err
+	}
+	var orgList []Org
+	if err := DB().Find(&orgList).Error; err != nil {
+		return err
+	}
+	orgMap := OrgIDMap(FillOrgFullInfo(orgList))
+	for _, assign := range user.RoleAssigns {
+		if assign.Role == nil {
+			continue
+		}
+		for _, dp := range assign.Role.DataPrivileges {
+			if dp.TargetOrgID == 0 {
+				continue
+			}
+			item := AuthExplainPrivilege{
+				AssignedRoleID:   assign.Role.ID,
+				AssignedRoleCode: assign.Role.Code,
+				SourceRoleID:     dp.RoleID,
+				TargetOrgID:      dp.TargetOrgID,
+				TargetOrgName:    orgMap[dp.TargetOrgID].Name,
+				Range:            strings.ToUpper(dp.WritableRange),
+			}
+			if reply.Action == AuthActionRead {
+				item.Range = strings.ToUpper(dp.ReadableRange)
+			}
+			item.Matched = matchExplainPrivilege(item.Range, orgMap[dp.TargetOrgID], orgMap[reply.RecordOrgID], reply.RecordCreatedBy == uid)
+			reply.Privileges = append(reply.Privileges, item)
+		}
+	}
+	return nil
+}
+
+// matchExplainPrivilege 判断数据权限是否覆盖记录所属组织，未指定记录时视为覆盖
+func matchExplainPrivilege(scopeRange string, target, recordOrg Org, isOwner bool) bool {
+	if recordOrg.ID == 0 {
+		return true
+	}
+	switch scopeRange {
+	case DataScopePersonal:
+		return target.ID == recordOrg.ID && isOwner
+	case DataScopeCurrent:
+		return target.ID == recordOrg.ID
+	case DataScopeCurrentFollowing:
+		return target.ID == recordOrg.ID || (target.FullPid != "" && strings.HasPrefix(recordOrg.FullPid, target.FullPid))
+	}
+	return false
+}
+
+func explainUintValue(v interface{}) uint {
+	switch n := reflect.ValueOf(v); n.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return uint(n.Uint())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return uint(n.Int())
+	}
+	return 0
+}
+
+// AuthExplainRoute
+var AuthExplainRoute = RouteInfo{
+	Name:   "数据权限诊断（解释用户能否读写指定记录）",
+	Method: http.MethodGet,
+	Path:   "/auth/explain",
+	IntlMessages: map[string]string{
+		"auth_explain_failed":   "Failed to explain data permissions",
+		"login_as_unauthorized": "Unauthorized operation",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		if c.SignInfo.UID != RootUID() {
+			return c.STDErr(fmt.Errorf("unauthorized operation: uid=%v", c.SignInfo.UID), "login_as_unauthorized")
+		}
+		uid := ParseID(c.Query("uid"))
+		if uid == 0 {
+			return c.STDErr(errors.New("uid is required"), "auth_explain_failed")
+		}
+		reply, err := ExplainAuth(uid, c.Query("model"), c.Query("action"), ParseID(c.Query("id")))
+		if err != nil {
+			return c.STDErr(err, "auth_explain_failed")
+		}
+		return c.STD(reply)
+	},
+}
@@ -0,0 +1,31 @@
+package kuu
+
+import "testing"
+
+func TestMatchExplainPrivilege(t *testing.T) {
+	var (
+		root  = Org{ID: 1, FullPid: "1,"}
+		child = Org{ID: 2, FullPid: "1,2,"}
+		other = Org{ID: 3, FullPid: "3,"}
+	)
+	cases := []struct {
+		scopeRange string
+		target     Org
+		record     Org
+		isOwner    bool
+		expected   bool
+	}{
+		{DataScopeCurrentFollowing, root, child, false, true},
+		{DataScopeCurrentFollowing, root, other, false, false},
+		{DataScopeCurrent, root, child, false, false},
+		{DataScopeCurrent, child, child, false, true},
+		{DataScopePersonal, child, child, false, false},
+		{DataScopePersonal, child, child, true, true},
+		{DataScopeCurrent, other, Org{}, false, true},
+	}
+	for _, item := range cases {
+		if v := matchExplainPrivilege(item.scopeRange, item.target, item.record, item.isOwner); v != item.expected {
+			t.Errorf("%s %d -> %d (owner=%v): got %v, expected %v", item.scopeRange, item.target.ID, item.record.ID, item.isOwner, v, item.expected)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package kuu
+
+import (
+	"fmt"
+	"sync"
+)
+
+var authProcessors sync.Map
+
+// RegisterAuthProcessor 注册数据权限处理器，name为模型名称，或模型标签kuu:"AUTH:name"中引用的名称
+//
+// 处理器可通过OwnerOnlyAuthProcessor、OrgTreeAuthProcessor、PublicReadAuthProcessor组合，如：
+//
+//	kuu.RegisterAuthProcessor("Article", kuu.PublicReadAuthProcessor(kuu.OwnerOnlyAuthProcessor(nil)))
+func RegisterAuthProcessor(name string, processor AuthProcessor) {
+	if processor == nil {
+		authProcessors.Delete(name)
+		return
+	}
+	authProcessors.Store(name, processor)
+}
+
+// GetAuthProcessor 查询模型的数据权限处理器，依次匹配模型名称、模型标签，均未注册时使用ActiveAuthProcessor
+func GetAuthProcessor(meta *Metadata) AuthProcessor {
+	if meta != nil {
+		if v, ok := authProcessors.Load(meta.Name); ok {
+			return v.(AuthProcessor)
+		}
+		if name := meta.TagSettings["AUTH"]; name != "" {
+			if v, ok := authProcessors.Load(name); ok {
+				return v.(AuthProcessor)
+			}
+			WARN("auth processor not registered: model=%s, name=%s", meta.Name, name)
+		}
+	}
+	return &ActiveAuthProcessor
+}
+
+// chainedAuthProcessor 可组合的数据权限处理器，未设置的操作直接交由next处理
+type chainedAuthProcessor struct {
+	next        AuthProcessor
+	allowCreate func(auth AuthProcessorDesc) error
+	writable    func(auth AuthProcessorDesc) error
+	readable    func(auth AuthProcessorDesc) error
+	// 为true时读操作不再交由next处理
+	skipNextReadable bool
+}
+
+func (p *chainedAuthProcessor) AllowCreate(auth AuthProcessorDesc) error {
+	if p.allowCreate != nil && isAuthRestricted(auth) {
+		if err := p.allowCreate(auth); err != nil {
+			return err
+		}
+	}
+	if p.next != nil {
+		return p.next.AllowCreate(auth)
+	}
+	return nil
+}
+
+func (p *chainedAuthProcessor) AddWritableWheres(auth AuthProcessorDesc) error {
+	if p.writable != nil && isAuthRestricted(auth) {
+		if err := p.writable(auth); err != nil {
+			return err
+		}
+	}
+	if p.next != nil {
+		return p.next.AddWritableWheres(auth)
+	}
+	return nil
+}
+
+func (p *chainedAuthProcessor) AddReadableWheres(auth AuthProcessorDesc) error {
+	if p.readable != nil && isAuthRestricted(auth) {
+		if err := p.readable(auth); err != nil {
+			return err
+		}
+	}
+	if p.next != nil && !p.skipNextReadable {
+		return p.next.AddReadableWheres(auth)
+	}
+	return nil
+}
+
+// isAuthRestricted 判断是否需要进行数据权限控制（根用户和忽略权限时不控制）
+func isAuthRestricted(auth AuthProcessorDesc) bool {
+	return auth.Meta != nil && auth.Scope != nil && auth.PrisDesc.IsValid() && auth.PrisDesc.NotRootUser() && !isAuthIgnored()
+}
+
+// OwnerOnlyAuthProcessor 只能读写本人创建的数据，next为nil时不再追加其他限制
+func OwnerOnlyAuthProcessor(next AuthProcessor) AuthProcessor {
+	where := func(auth AuthProcessorDesc) error {
+		if !auth.HasCreatedByIDField {
+			return fmt.Errorf("model %s has no CreatedByID field", auth.Meta.Name)
+		}
+		auth.Scope.Search.Where(fmt.Sprintf("%v.%v = ?", auth.Scope.QuotedTableName(), auth.Scope.Quote(auth.CreatedByIDField.DBName)), auth.PrisDesc.UID)
+		return nil
+	}
+	return &chainedAuthProcessor{
+		next: next,
+		allowCreate: func(auth AuthProcessorDesc) error {
+			if auth.HasCreatedByIDField && auth.CreatedByID != auth.PrisDesc.UID {
+				return fmt.Errorf("用户 %d 只拥有个人可写权限", auth.PrisDesc.UID)
+			}
+			return nil
+		},
+		writable: where,
+		readable: where,
+	}
+}
+
+// OrgTreeAuthProcessor 只能读写当前组织及下级组织中有权限的数据（不包含个人数据），next为nil时不再追加其他限制
+func OrgTreeAuthProcessor(next AuthProcessor) AuthProcessor {
+	where := func(auth AuthProcessorDesc, orgIDs []uint) error {
+		if !auth.HasOrgIDField {
+			return fmt.Errorf("model %s has no OrgID field", auth.Meta.Name)
+		}
+		if len(orgIDs) == 0 {
+			auth.Scope.Search.Where("1 = 0")
+			return nil
+		}
+		auth.Scope.Search.Where(fmt.Sprintf("%v.%v IN (?)", auth.Scope.QuotedTableName(), auth.Scope.Quote(auth.OrgIDFieldField.DBName)), orgIDs)
+		return nil
+	}
+	return &chainedAuthProcessor{
+		next: next,
+		allowCreate: func(auth AuthProcessorDesc) error {
+			if auth.HasOrgIDField && !auth.PrisDesc.IsWritableOrgID(auth.OrgID) {
+				return fmt.Errorf("用户 %d 在组织 %d 中无可写权限", auth.PrisDesc.UID, auth.OrgID)
+			}
+			return nil
+		},
+		writable: func(auth AuthProcessorDesc) error {
+			return where(auth, auth.PrisDesc.WritableOrgIDs)
+		},
+		readable: func(auth AuthProcessorDesc) error {
+			return where(auth, auth.PrisDesc.ReadableOrgIDs)
+		},
+	}
+}
+
+// PublicReadAuthProcessor 所有登录用户均可读取，写操作交由next处理
+func PublicReadAuthProcessor(next AuthProcessor) AuthProcessor {
+	return &chainedAuthProcessor{
+		next:             next,
+		skipNextReadable: true,
+	}
+}
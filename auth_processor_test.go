@@ -0,0 +1,57 @@
+package kuu
+
+import "testing"
+
+type testAuthProcessor struct {
+	creates, writes, reads int
+}
+
+func (p *testAuthProcessor) AllowCreate(AuthProcessorDesc) error {
+	p.creates++
+	return nil
+}
+
+func (p *testAuthProcessor) AddWritableWheres(AuthProcessorDesc) error {
+	p.writes++
+	return nil
+}
+
+func (p *testAuthProcessor) AddReadableWheres(AuthProcessorDesc) error {
+	p.reads++
+	return nil
+}
+
+func TestGetAuthProcessor(t *testing.T) {
+	byName, byTag := &testAuthProcessor{}, &testAuthProcessor{}
+	RegisterAuthProcessor("TestAuthOrder", byName)
+	RegisterAuthProcessor("test_public", byTag)
+	defer RegisterAuthProcessor("TestAuthOrder", nil)
+	defer RegisterAuthProcessor("test_public", nil)
+
+	if p := GetAuthProcessor(&Metadata{Name: "TestAuthOrder", TagSettings: map[string]string{"AUTH": "test_public"}}); p != byName {
+		t.Error("expected processor registered by model name")
+	}
+	if p := GetAuthProcessor(&Metadata{Name: "TestAuthNews", TagSettings: map[string]string{"AUTH": "test_public"}}); p != byTag {
+		t.Error("expected processor registered by tag")
+	}
+	if p := GetAuthProcessor(&Metadata{Name: "TestAuthOther"}); p != &ActiveAuthProcessor {
+		t.Error("expected default processor")
+	}
+	if p := GetAuthProcessor(nil); p != &ActiveAuthProcessor {
+		t.Error("expected default processor")
+	}
+}
+
+func TestChainedAuthProcessor(t *testing.T) {
+	next := &testAuthProcessor{}
+	p := PublicReadAuthProcessor(OwnerOnlyAuthProcessor(next))
+	auth := AuthProcessorDesc{}
+	for _, fn := range []func(AuthProcessorDesc) error{p.AllowCreate, p.AddWritableWheres, p.AddReadableWheres} {
+		if err := fn(auth); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if next.creates != 1 || next.writes != 1 || next.reads != 0 {
+		t.Errorf("unexpected calls: %+v", next)
+	}
+}
@@ -0,0 +1,438 @@
+package kuu
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+var ErrBatchInvalid = errors.New("invalid batch operation")
+
+// BatchOperation 批量接口中的单个操作，后续操作可在Cond和Doc中以{"$ref": "<Key>.<字段路径>"}引用之前操作的结果，如：
+//
+//	[
+//	  {"Key": "order", "Model": "Order", "Method": "create", "Doc": {"No": "SO001"}},
+//	  {"Model": "OrderLine", "Method": "create", "Doc": [{"OrderID": {"$ref": "order.ID"}, "Qty": 1}]},
+//	  {"Model": "Order", "Method": "query", "Cond": {"ID": {"$ref": "0.ID"}}, "Preload": "Lines"}
+//	]
+type BatchOperation struct {
+	Key     string                 // 操作标识，未指定时为操作序号，不能重复
+	Model   string                 `binding:"required"`
+	Method  string                 `binding:"required"` // create、update、delete、query
+	Cond    map[string]interface{} // update、delete、query的条件
+	Doc     interface{}            // create时为对象或数组，update时为对象
+	Multi   bool                   // update、delete是否操作多条记录
+	UnSoft  bool                   // delete是否物理删除
+	Project string                 // query返回字段
+	Sort    string                 // query排序字段
+	Preload string                 // query预加载字段
+	Page    int                    // query页码，默认为1
+	Size    int                    // query每页条数，默认及最大为batchQueryMaxSize（默认1000）
+}
+
+// BatchResult 批量接口中单个操作的结果
+type BatchResult struct {
+	Key    string
+	Model  string
+	Method string
+	Data   interface{}
+}
+
+// BatchError 批量操作中单个操作的错误
+type BatchError struct {
+	Index int    // 操作序号
+	Key   string // 操作标识
+	Err   error
+}
+
+// Error
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("operation %d (%s): %v", e.Index, e.Key, e.Err)
+}
+
+// Unwrap
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// batchRefKey 引用之前操作结果的对象键
+const batchRefKey = "$ref"
+
+// batchRefs 已执行操作的结果，用于解析引用
+type batchRefs map[string]interface{}
+
+// add 记录操作结果，结果统一转换为JSON值以便按字段路径引用
+func (refs batchRefs) add(key string, data interface{}) {
+	var value interface{}
+	_ = Copy(data, &value)
+	refs[key] = value
+}
+
+// resolve 将{"$ref": "<Key>.<字段路径>"}形式的对象替换为引用的值，其他值保持不变
+func (refs batchRefs) resolve(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if ref, has := v[batchRefKey]; has && len(v) == 1 {
+			path, ok := ref.(string)
+			if !ok {
+				return nil, fmt.Errorf("%w: invalid reference %v", ErrBatchInvalid, ref)
+			}
+			return refs.lookup(path)
+		}
+		resolved := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			r, err := refs.resolve(item)
+			if err != nil {
+				return nil, err
+			}
+			resolved[key] = r
+		}
+		return resolved, nil
+	case []interface{}:
+		resolved := make([]interface{}, len(v))
+		for i, item := range v {
+			r, err := refs.resolve(item)
+			if err != nil {
+				return nil, err
+			}
+			resolved[i] = r
+		}
+		return resolved, nil
+	}
+	return value, nil
+}
+
+// lookup 按"<Key>.<字段路径>"查找之前操作的结果
+func (refs batchRefs) lookup(path string) (interface{}, error) {
+	split := strings.Split(path, ".")
+	current, has := refs[split[0]]
+	if !has {
+		return nil, fmt.Errorf("%w: unresolved reference %s", ErrBatchInvalid, path)
+	}
+	for _, name := range split[1:] {
+		switch c := current.(type) {
+		case map[string]interface{}:
+			if current, has = c[name]; !has {
+				return nil, fmt.Errorf("%w: unresolved reference %s", ErrBatchInvalid, path)
+			}
+		case []interface{}:
+			index, err := strconv.Atoi(name)
+			if err != nil || index < 0 || index >= len(c) {
+				return nil, fmt.Errorf("%w: unresolved reference %s", ErrBatchInvalid, path)
+			}
+			current = c[index]
+		default:
+			return nil, fmt.Errorf("%w: unresolved reference %s", ErrBatchInvalid, path)
+		}
+	}
+	return current, nil
+}
+
+// batchKeys 校验并补全操作标识，操作标识不能重复，数字标识只能为操作自身的序号
+func batchKeys(ops []BatchOperation) error {
+	seen := make(map[string]bool, len(ops))
+	for i := range ops {
+		index := strconv.Itoa(i)
+		if ops[i].Key == "" {
+			ops[i].Key = index
+		}
+		if _, err := strconv.Atoi(ops[i].Key); err == nil && ops[i].Key != index {
+			err := fmt.Errorf("%w: key %s conflicts with an operation index", ErrBatchInvalid, ops[i].Key)
+			return &BatchError{Index: i, Key: ops[i].Key, Err: err}
+		}
+		if seen[ops[i].Key] {
+			err := fmt.Errorf("%w: duplicate key %s", ErrBatchInvalid, ops[i].Key)
+			return &BatchError{Index: i, Key: ops[i].Key, Err: err}
+		}
+		seen[ops[i].Key] = true
+	}
+	return nil
+}
+
+// batchMeta 校验操作对应的模型、接口是否开放及API权限
+func batchMeta(op *BatchOperation) (*Metadata, error) {
+	meta := Meta(op.Model)
+	if meta == nil || meta.RestDesc == nil || !meta.RestDesc.IsValid() {
+		return nil, fmt.Errorf("%w: model not found: %s", ErrBatchInvalid, op.Model)
+	}
+	op.Method = strings.ToLower(op.Method)
+	desc := meta.RestDesc
+	enabled := map[string]bool{
+		"create": desc.Create,
+		"update": desc.Update,
+		"delete": desc.Delete,
+		"query":  desc.Query,
+	}
+	if !enabled[op.Method] {
+		return nil, fmt.Errorf("%w: method %s is not allowed on %s", ErrBatchInvalid, op.Method, op.Model)
+	}
+	if prisDesc := GetRoutinePrivilegesDesc(); prisDesc != nil && !prisDesc.HasAPIPermission(desc.Methods[op.Method], desc.Path) {
+		return nil, fmt.Errorf("%w: no permission to %s %s", ErrBatchInvalid, op.Method, op.Model)
+	}
+	return meta, nil
+}
+
+// ExecBatch 在同一事务中按顺序执行批量操作，任一操作失败时全部回滚
+func ExecBatch(c *Context, ops []BatchOperation) ([]BatchResult, error) {
+	if len(ops) == 0 {
+		return nil, fmt.Errorf("%w: no operations", ErrBatchInvalid)
+	}
+	if limit := C().DefaultGetInt("batchMaxOperations", 100); len(ops) > limit {
+		return nil, fmt.Errorf("%w: too many operations: %d > %d", ErrBatchInvalid, len(ops), limit)
+	}
+	var (
+		metas      = make([]*Metadata, len(ops))
+		dataSource string
+	)
+	if err := batchKeys(ops); err != nil {
+		return nil, err
+	}
+	for i := range ops {
+		meta, err := batchMeta(&ops[i])
+		if err != nil {
+			return nil, &BatchError{Index: i, Key: ops[i].Key, Err: err}
+		}
+		// 跨数据源无法在同一事务中执行
+		if i > 0 && meta.DataSource != dataSource {
+			err := fmt.Errorf("%w: %s is bound to another data source", ErrBatchInvalid, ops[i].Model)
+			return nil, &BatchError{Index: i, Key: ops[i].Key, Err: err}
+		}
+		metas[i], dataSource = meta, meta.DataSource
+	}
+	results := make([]BatchResult, len(ops))
+	err := c.WithModelTransaction(reflect.New(metas[0].reflectType).Interface(), func(tx *gorm.DB) error {
+		refs := make(batchRefs)
+		for i, operation := range ops {
+			data, err := execBatchOperation(c, tx, metas[i].reflectType, operation, refs)
+			if err != nil {
+				return &BatchError{Index: i, Key: operation.Key, Err: err}
+			}
+			refs.add(operation.Key, data)
+			refs.add(strconv.Itoa(i), data)
+			results[i] = BatchResult{Key: operation.Key, Model: operation.Model, Method: operation.Method, Data: data}
+		}
+		return tx.Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// execBatchOperation 执行单个操作
+func execBatchOperation(c *Context, tx *gorm.DB, reflectType reflect.Type, operation BatchOperation, refs batchRefs) (interface{}, error) {
+	var cond map[string]interface{}
+	if operation.Cond != nil {
+		resolved, err := refs.resolve(operation.Cond)
+		if err != nil {
+			return nil, err
+		}
+		cond = resolved.(map[string]interface{})
+	}
+	doc, err := refs.resolve(operation.Doc)
+	if err != nil {
+		return nil, err
+	}
+	modelValue := reflect.New(reflectType).Interface()
+	switch operation.Method {
+	case "create":
+		return execBatchCreate(c, tx, reflectType, doc)
+	case "update":
+		docMap, ok := doc.(map[string]interface{})
+		if IsBlank(cond) || !ok || len(docMap) == 0 {
+			return nil, errors.New("'cond' and 'doc' are required")
+		}
+		return execBatchUpdate(c, tx, reflectType, &BizUpdateParams{Multi: operation.Multi, Cond: cond, Doc: docMap})
+	case "delete":
+		if IsBlank(cond) {
+			return nil, errors.New("'cond' is required")
+		}
+		_, db := ParseCond(cond, modelValue, tx)
+		var result interface{}
+		if operation.Multi {
+			result = reflect.New(reflect.SliceOf(reflectType)).Interface()
+			db = db.Find(result)
+		} else {
+			result = reflect.New(reflectType).Interface()
+			db = db.First(result)
+		}
+		if db.RowsAffected < 1 {
+			return nil, ErrAffectedDeleteToken
+		}
+		if operation.UnSoft {
+			db = db.Unscoped()
+		}
+		for _, value := range batchValues(result) {
+			if bizScope := NewBizScope(c, value, db).callCallbacks(BizDeleteKind); bizScope.HasError() {
+				return nil, bizScope.DB.Error
+			}
+		}
+		return Meta(modelValue).OmitPassword(result), nil
+	case "query":
+		// 始终分页，避免一次返回全表数据
+		size := C().DefaultGetInt("batchQueryMaxSize", 1000)
+		if operation.Size > 0 && operation.Size < size {
+			size = operation.Size
+		}
+		page := operation.Page
+		if page < 1 {
+			page = 1
+		}
+		ret := &BizQueryResult{Cond: cond, Sort: operation.Sort, Preload: operation.Preload, Range: "PAGE", Page: page, Size: size}
+		_, db := ParseCond(cond, modelValue, tx.Model(modelValue))
+		db = db.Offset((page - 1) * size).Limit(size)
+		scope := tx.NewScope(modelValue)
+		if operation.Project != "" {
+			db, ret.Project = selectProject(scope, modelValue, db, operation.Project)
+		}
+		for _, name := range strings.Split(operation.Sort, ",") {
+			direction := "asc"
+			if strings.HasPrefix(name, "-") {
+				name, direction = name[1:], "desc"
+			}
+			if field, ok := scope.FieldByName(name); ok {
+				db = db.Order(fmt.Sprintf("%s %s", scope.Quote(field.DBName), direction))
+			}
+		}
+		if operation.Preload != "" {
+			for _, name := range strings.Split(operation.Preload, ",") {
+				db = db.Preload(name)
+			}
+		}
+		ret.List = reflect.New(reflect.SliceOf(reflectType)).Interface()
+		bizScope := NewBizScope(c, modelValue, db)
+		bizScope.QueryResult = ret
+		if bizScope.callCallbacks(BizQueryKind); bizScope.HasError() {
+			return nil, bizScope.DB.Error
+		}
+		return ret, nil
+	}
+	return nil, fmt.Errorf("%w: unknown method %s", ErrBatchInvalid, operation.Method)
+}
+
+// execBatchCreate 新增一条或多条记录
+func execBatchCreate(c *Context, tx *gorm.DB, reflectType reflect.Type, body interface{}) (interface{}, error) {
+	items, multi := body.([]interface{})
+	if !multi {
+		if _, ok := body.(map[string]interface{}); !ok {
+			return nil, errors.New("'doc' is required")
+		}
+		items = []interface{}{body}
+	}
+	docs := make([]interface{}, len(items))
+	for i, item := range items {
+		doc := reflect.New(reflectType).Interface()
+		if err := Copy(item, doc); err != nil {
+			return nil, err
+		}
+		if bizScope := NewBizScope(c, doc, tx).callCallbacks(BizCreateKind); bizScope.HasError() {
+			return nil, bizScope.DB.Error
+		}
+		docs[i] = Meta(doc).OmitPassword(doc)
+	}
+	if multi {
+		return docs, nil
+	}
+	return docs[0], nil
+}
+
+// execBatchUpdate 按条件修改记录，文档中的Ts用于乐观锁校验
+func execBatchUpdate(c *Context, tx *gorm.DB, reflectType reflect.Type, params *BizUpdateParams) (interface{}, error) {
+	version, err := parseVersionExpectation("", params.Doc)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		modelValue = reflect.New(reflectType).Interface()
+		result     interface{}
+	)
+	_, queryDB := ParseCond(params.Cond, modelValue, tx.New())
+	if params.Multi {
+		result = reflect.New(reflect.SliceOf(reflectType)).Interface()
+		queryDB = queryDB.Find(result)
+	} else {
+		result = reflect.New(reflectType).Interface()
+		queryDB = queryDB.First(result)
+	}
+	if queryDB.RowsAffected < 1 {
+		return nil, ErrAffectedSaveToken
+	}
+	for _, value := range batchValues(result) {
+		if err := version.check(value); err != nil {
+			return nil, err
+		}
+		doc := reflect.New(reflectType).Interface()
+		if err := Copy(params.Doc, doc); err != nil {
+			return nil, err
+		}
+		bizScope := NewBizScope(c, value, tx)
+		if _, ts, ok := recordVersion(value); ok && version != nil {
+			bizScope.DB = bizScope.DB.Set(updateVersionKey, ts)
+		}
+		bizScope.UpdateParams = params
+		bizScope.UpdateCond = value
+		bizScope.Value = doc
+		if bizScope.callCallbacks(BizUpdateKind); bizScope.HasError() {
+			return nil, bizScope.DB.Error
+		}
+	}
+	return Meta(modelValue).OmitPassword(result), nil
+}
+
+// batchValues 展开单条或多条记录
+func batchValues(result interface{}) (values []interface{}) {
+	if v := indirectValue(result); v.Kind() == reflect.Slice {
+		for i := 0; i < v.Len(); i++ {
+			values = append(values, v.Index(i).Addr().Interface())
+		}
+		return
+	}
+	return []interface{}{result}
+}
+
+// BatchRoute 事务批量接口
+var BatchRoute = RouteInfo{
+	Name:   "事务批量操作",
+	Method: http.MethodPost,
+	Path:   "/batch",
+	IntlMessages: map[string]string{
+		"batch_failed": "Batch operations failed",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		var body struct {
+			Operations []BatchOperation `binding:"required,dive"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			return c.STDErr(err, "batch_failed")
+		}
+		results, err := ExecBatch(c, body.Operations)
+		if err == nil {
+			return c.STD(results)
+		}
+		// 失败的操作通过data返回，错误本身用于解析国际化消息
+		var (
+			batchErr *BatchError
+			detail   D
+			reply    *STDReply
+		)
+		if errors.As(err, &batchErr) {
+			detail = D{"Index": batchErr.Index, "Key": batchErr.Key}
+			err = batchErr.Err
+		}
+		if IsVersionConflict(err) {
+			reply = c.STDErrWithCode(err, http.StatusConflict)
+			reply.HTTPCode = http.StatusConflict
+		} else {
+			reply = c.STDErr(err, "batch_failed")
+		}
+		if detail != nil {
+			reply.Data = detail
+		}
+		return reply
+	},
+}
@@ -0,0 +1,96 @@
+package kuu
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBatchRefsResolve(t *testing.T) {
+	refs := make(batchRefs)
+	refs.add("order", map[string]interface{}{"ID": 12, "No": "SO001"})
+	refs.add("1", []map[string]interface{}{{"ID": 20}, {"ID": 21}})
+
+	resolved, err := refs.resolve(map[string]interface{}{
+		"OrderID": map[string]interface{}{"$ref": "order.ID"},
+		"LineIDs": []interface{}{map[string]interface{}{"$ref": "1.0.ID"}, map[string]interface{}{"$ref": "1.1.ID"}},
+		"Name":    map[string]interface{}{"$regex": "$order.No"},
+		"Price":   "$1.50",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := resolved.(map[string]interface{})
+	if doc["OrderID"] != float64(12) {
+		t.Errorf("unexpected OrderID: %v", doc["OrderID"])
+	}
+	if ids := doc["LineIDs"].([]interface{}); ids[0] != float64(20) || ids[1] != float64(21) {
+		t.Errorf("unexpected LineIDs: %v", ids)
+	}
+	// 字符串始终按字面值处理
+	if v := doc["Name"].(map[string]interface{})["$regex"]; v != "$order.No" {
+		t.Errorf("unexpected literal: %v", v)
+	}
+	if doc["Price"] != "$1.50" {
+		t.Errorf("unexpected literal: %v", doc["Price"])
+	}
+	for _, ref := range []interface{}{"order.Lines", "1.5.ID", "missing.ID", 1} {
+		if _, err := refs.resolve(map[string]interface{}{"$ref": ref}); !errors.Is(err, ErrBatchInvalid) {
+			t.Errorf("expected unresolved reference error for %v, got %v", ref, err)
+		}
+	}
+}
+
+func TestBatchError(t *testing.T) {
+	cause := newVersionConflictError("etag")
+	var err error = &BatchError{Index: 2, Key: "order", Err: cause}
+	if !IsVersionConflict(err) {
+		t.Error("expected version conflict")
+	}
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) || batchErr.Index != 2 || batchErr.Err != cause {
+		t.Errorf("unexpected batch error: %v", err)
+	}
+}
+
+func TestBatchKeys(t *testing.T) {
+	ops := []BatchOperation{{Key: "order"}, {}, {Key: "2"}}
+	if err := batchKeys(ops); err != nil {
+		t.Fatal(err)
+	}
+	if ops[1].Key != "1" {
+		t.Errorf("expected default key, got %s", ops[1].Key)
+	}
+	cases := map[int][]BatchOperation{
+		1: {{Key: "order"}, {Key: "order"}},
+		2: {{}, {Key: "line"}, {Key: "0"}},
+		0: {{Key: "1"}, {}},
+	}
+	for index, ops := range cases {
+		var batchErr *BatchError
+		if err := batchKeys(ops); !errors.Is(err, ErrBatchInvalid) || !errors.As(err, &batchErr) || batchErr.Index != index {
+			t.Errorf("expected invalid key at %d, got %v", index, err)
+		}
+	}
+}
+
+func TestSelectProject(t *testing.T) {
+	db := testSQLiteDB(t, "")
+	if err := db.AutoMigrate(&testExportItem{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	db.Create(&testExportItem{Code: "a", CreatedAt: time.Now()})
+
+	model := &testExportItem{}
+	query, project := selectProject(db.NewScope(model), model, db.Model(model), "Code,-ID,Missing")
+	if project != "Code,ID" {
+		t.Errorf("unexpected project: %s", project)
+	}
+	var list []testExportItem
+	if err := query.Find(&list).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Code != "a" || list[0].ID == 0 || !list[0].CreatedAt.IsZero() {
+		t.Errorf("unexpected list: %+v", list)
+	}
+}
@@ -23,11 +23,23 @@ func bizBeforeUpdateCallback(scope *Scope) {
 func bizUpdateCallback(scope *Scope) {
 	if !scope.HasError() {
 		scope.DB = scope.DB.Set("__KUU_UPDATE_BEFORE__", JSONStringify(scope.UpdateCond))
-		scope.DB = scope.DB.Model(scope.UpdateCond).Updates(scope.Value)
+		db := scope.DB.Model(scope.UpdateCond)
+		version, hasVersion := scope.DB.Get(updateVersionKey)
+		if hasVersion {
+			gormScope := db.NewScope(scope.UpdateCond)
+			if field, ok := gormScope.FieldByName("Ts"); ok {
+				db = db.Where(fmt.Sprintf("%v.%v = ?", gormScope.QuotedTableName(), gormScope.Quote(field.DBName)), version)
+			}
+		}
+		scope.DB = db.Updates(scope.Value)
 		if err := scope.DB.Error; err != nil {
 			_ = scope.Err(err)
 			return
 		}
+		if hasVersion && scope.DB.RowsAffected < 1 && versionChanged(scope.DB, scope.UpdateCond, version) {
+			_ = scope.Err(newVersionConflictError(RecordETag(scope.UpdateCond)))
+			return
+		}
 	}
 }
 
@@ -40,6 +40,7 @@ type BizQueryResult struct {
 	TotalRecords int                    `json:"totalrecords,omitempty"`
 	TotalPages   int                    `json:"totalpages,omitempty"`
 	List         interface{}            `json:"list,omitempty"`
+	ETags        map[uint]string        `json:"etags,omitempty"` // 记录ID与ETag的映射，可在更新时通过If-Match校验版本
 }
 
 type BizPreloadInterface interface {
@@ -2,6 +2,8 @@ package kuu
 
 import (
 	"encoding/binary"
+	"fmt"
+	"strings"
 	"time"
 )
 
@@ -11,6 +13,7 @@ var DefaultCache Cache
 // Cache todo
 type Cache interface {
 	SetString(string, string, ...time.Duration)
+	SetNX(string, string, ...time.Duration) bool
 	HasPrefix(string, int) map[string]string
 	HasSuffix(string, int) map[string]string
 	Contains(string, int) map[string]string
@@ -62,17 +65,33 @@ func btoi(b []byte) (v int) {
 	return
 }
 
+// tenantCacheKey 租户数据源中的缓存键增加数据源前缀，避免不同租户的缓存互相覆盖
+func tenantCacheKey(key string) string {
+	if name := GetRoutineDataSource(); name != "" && name != singleDSName {
+		return fmt.Sprintf("%s_%s", name, key)
+	}
+	return key
+}
+
 // SetCacheString
 func SetCacheString(key, val string, expiration ...time.Duration) {
 	if DefaultCache != nil {
-		DefaultCache.SetString(key, val, expiration...)
+		DefaultCache.SetString(tenantCacheKey(key), val, expiration...)
 	}
 }
 
+// SetCacheStringNX 仅在键不存在时写入，返回是否写入成功，可用于原子地占用某个键
+func SetCacheStringNX(key, val string, expiration ...time.Duration) bool {
+	if DefaultCache != nil {
+		return DefaultCache.SetNX(tenantCacheKey(key), val, expiration...)
+	}
+	return true
+}
+
 // GetCacheString
 func GetCacheString(key string) (val string) {
 	if DefaultCache != nil {
-		val = DefaultCache.GetString(key)
+		val = DefaultCache.GetString(tenantCacheKey(key))
 	}
 	return
 }
@@ -80,14 +99,14 @@ func GetCacheString(key string) (val string) {
 // SetCacheInt
 func SetCacheInt(key string, val int, expiration ...time.Duration) {
 	if DefaultCache != nil {
-		DefaultCache.SetInt(key, val, expiration...)
+		DefaultCache.SetInt(tenantCacheKey(key), val, expiration...)
 	}
 }
 
 // GetCacheInt
 func GetCacheInt(key string) (val int) {
 	if DefaultCache != nil {
-		val = DefaultCache.GetInt(key)
+		val = DefaultCache.GetInt(tenantCacheKey(key))
 	}
 	return
 }
@@ -95,7 +114,7 @@ func GetCacheInt(key string) (val int) {
 // IncrCache
 func IncrCache(key string) (val int) {
 	if DefaultCache != nil {
-		val = DefaultCache.Incr(key)
+		val = DefaultCache.Incr(tenantCacheKey(key))
 	}
 	return
 }
@@ -103,31 +122,49 @@ func IncrCache(key string) (val int) {
 // HasPrefixCache
 func HasPrefixCache(key string, limit int) (val map[string]string) {
 	if DefaultCache != nil {
-		val = DefaultCache.HasPrefix(key, limit)
+		val = DefaultCache.HasPrefix(tenantCacheKey(key), limit)
 	}
 	return
 }
 
-// HasSuffixCache
+// HasSuffixCache 租户数据源中仅返回该租户的缓存，limit在过滤前生效
 func HasSuffixCache(key string, limit int) (val map[string]string) {
 	if DefaultCache != nil {
-		val = DefaultCache.HasSuffix(key, limit)
+		val = filterTenantCache(DefaultCache.HasSuffix(key, limit))
 	}
 	return
 }
 
-// ContainsCache
+// ContainsCache 租户数据源中仅返回该租户的缓存，limit在过滤前生效
 func ContainsCache(key string, limit int) (val map[string]string) {
 	if DefaultCache != nil {
-		val = DefaultCache.Contains(key, limit)
+		val = filterTenantCache(DefaultCache.Contains(key, limit))
 	}
 	return
 }
 
+// filterTenantCache 过滤掉不属于当前租户数据源的缓存，Redis返回的键带有应用名前缀
+func filterTenantCache(values map[string]string) map[string]string {
+	prefix := tenantCacheKey("")
+	if prefix == "" {
+		return values
+	}
+	for k := range values {
+		if !strings.HasPrefix(k, prefix) && !strings.HasPrefix(k, BuildKey(prefix)) {
+			delete(values, k)
+		}
+	}
+	return values
+}
+
 // DelCache
 func DelCache(keys ...string) {
 	if DefaultCache != nil {
-		DefaultCache.Del(keys...)
+		list := make([]string, len(keys))
+		for i, key := range keys {
+			list[i] = tenantCacheKey(key)
+		}
+		DefaultCache.Del(list...)
 	}
 	return
 }
@@ -33,6 +33,22 @@ func (c *CacheBolt) SetString(key, val string, expiration ...time.Duration) {
 	}))
 }
 
+// SetNX
+func (c *CacheBolt) SetNX(key, val string, expiration ...time.Duration) (ok bool) {
+	ERROR(c.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(c.generalBucketName)
+		if err != nil {
+			return err
+		}
+		if bucket.Get([]byte(key)) != nil {
+			return nil
+		}
+		ok = true
+		return bucket.Put([]byte(key), []byte(val))
+	}))
+	return
+}
+
 // GetString
 func (c *CacheBolt) GetString(key string) (val string) {
 	ERROR(c.db.View(func(tx *bolt.Tx) error {
@@ -63,6 +79,28 @@ func (c *CacheBolt) seek(seek []byte, limit int, f func(k, v []byte) bool) (valu
 	return
 }
 
+// scan 遍历全部键，后缀和包含匹配的键不连续，无法使用Seek定位
+func (c *CacheBolt) scan(limit int, f func(k, v []byte) bool) (values map[string]string) {
+	values = make(map[string]string)
+	ERROR(c.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(c.generalBucketName)
+		if bucket != nil {
+			c := bucket.Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				if !f(k, v) {
+					continue
+				}
+				values[string(k)] = string(v)
+				if limit > 0 && len(values) >= limit {
+					break
+				}
+			}
+		}
+		return nil
+	}))
+	return
+}
+
 // HasPrefix
 func (c *CacheBolt) HasPrefix(prefix string, limit int) (values map[string]string) {
 	if len(prefix) == 0 {
@@ -80,7 +118,7 @@ func (c *CacheBolt) HasSuffix(suffix string, limit int) (values map[string]strin
 		return
 	}
 	seek := []byte(suffix)
-	return c.seek(seek, limit, func(k, v []byte) bool {
+	return c.scan(limit, func(k, v []byte) bool {
 		return bytes.HasSuffix(k, seek)
 	})
 }
@@ -91,7 +129,7 @@ func (c *CacheBolt) Contains(pattern string, limit int) (values map[string]strin
 		return
 	}
 	seek := []byte(pattern)
-	return c.seek(seek, limit, func(k, v []byte) bool {
+	return c.scan(limit, func(k, v []byte) bool {
 		return bytes.Contains(k, seek)
 	})
 }
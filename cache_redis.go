@@ -119,6 +119,19 @@ func (c *CacheRedis) SetString(rawKey, val string, expiration ...time.Duration)
 	}
 }
 
+// SetNX
+func (c *CacheRedis) SetNX(rawKey, val string, expiration ...time.Duration) bool {
+	var (
+		key, exp = c.buildKeyAndExp(rawKey, expiration)
+		cmd      = c.client.SetNX(context.Background(), key, val, exp)
+	)
+	if err := cmd.Err(); err != nil {
+		ERROR(err)
+		return false
+	}
+	return cmd.Val()
+}
+
 // GetString
 func (c *CacheRedis) GetString(rawKey string) (val string) {
 	var (
@@ -0,0 +1,432 @@
+package kuu
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jinzhu/gorm"
+)
+
+// cliSecretRegexp 打印配置时需要隐藏的配置项
+var cliSecretRegexp = regexp.MustCompile(`(?i)(password|passwd|secret|token|args|dsn|key)$`)
+
+// CLICommand 命令行子命令
+type CLICommand struct {
+	Name  string
+	Usage string
+	Run   func(args []string, out io.Writer) error
+}
+
+var cliCommands = []CLICommand{
+	{Name: "migrate", Usage: "migrate up|down|status [-mod a,b] [-steps n] [-dry-run]", Run: cliMigrate},
+	{Name: "seed", Usage: "seed [-env e] [-mod a,b]  导入模块种子数据，默认环境为配置项seedEnv", Run: cliSeed},
+	{Name: "root", Usage: "root [-password p]  初始化系统并创建root用户", Run: cliRoot},
+	{Name: "passwd", Usage: "passwd -username u [-password p] [-force]  重置用户密码，未指定密码时随机生成", Run: cliPasswd},
+	{Name: "routes", Usage: "routes  列出已注册的接口", Run: cliRoutes},
+	{Name: "jobs", Usage: "jobs list|run <codes>  查询或同步执行定时任务（需设置KUU_JOB=true）", Run: cliJobs},
+	{Name: "intl", Usage: "intl export [-o file] | intl import -f file [-overwrite]", Run: cliIntl},
+	{Name: "cache", Usage: "cache flush <prefix>...  删除指定前缀的缓存", Run: cliCache},
+	{Name: "config", Usage: "config [-raw] [path]  打印生效的配置，默认隐藏敏感项", Run: cliConfig},
+}
+
+// RegisterCLICommand 注册自定义子命令，同名时覆盖
+func RegisterCLICommand(cmd CLICommand) {
+	for i, item := range cliCommands {
+		if item.Name == cmd.Name {
+			cliCommands[i] = cmd
+			return
+		}
+	}
+	cliCommands = append(cliCommands, cmd)
+}
+
+// RunCLI 以命令行模式运行应用，需在Import之后调用，执行完成后退出进程，如：
+//
+//	app := kuu.Default()
+//	app.Import(kuu.Acc(), kuu.Sys())
+//	if len(os.Args) > 1 {
+//		app.RunCLI()
+//	}
+//	app.Run()
+func (app *Engine) RunCLI(args ...string) {
+	if len(args) == 0 {
+		args = os.Args[1:]
+	}
+	err := RunCommand(args, os.Stdout)
+	Release()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// RunCommand 执行子命令，与服务共用C()加载的配置
+func RunCommand(args []string, out io.Writer) error {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		printCLIUsage(out)
+		return nil
+	}
+	for _, cmd := range cliCommands {
+		if cmd.Name == args[0] {
+			return cmd.Run(args[1:], out)
+		}
+	}
+	printCLIUsage(out)
+	return fmt.Errorf("unknown command: %s", args[0])
+}
+
+func printCLIUsage(out io.Writer) {
+	fmt.Fprintln(out, "Usage:")
+	for _, cmd := range cliCommands {
+		fmt.Fprintf(out, "  %s\n", cmd.Usage)
+	}
+}
+
+func newCLIFlagSet(name string, out io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(out)
+	return fs
+}
+
+// splitCLIList 解析逗号分隔的参数
+func splitCLIList(s string) (list []string) {
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return
+}
+
+func cliMigrate(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up|down|status")
+	}
+	var (
+		fs     = newCLIFlagSet("migrate", out)
+		mods   = fs.String("mod", "", "模块编码，多个以逗号分隔")
+		steps  = fs.Int("steps", 1, "回滚步数")
+		dryRun = fs.Bool("dry-run", false, "只打印待执行的迁移")
+	)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	opts := MigrateOptions{Mods: splitCLIList(*mods), Steps: *steps, DryRun: *dryRun}
+	var (
+		list []MigrationStatus
+		err  error
+	)
+	switch args[0] {
+	case "up":
+		list, err = MigrateUp(opts)
+	case "down":
+		list, err = MigrateDown(opts)
+	case "status":
+		list, err = MigrationStatuses(opts.Mods...)
+	default:
+		return fmt.Errorf("unknown migrate command: %s", args[0])
+	}
+	printMigrationStatuses(out, list)
+	return err
+}
+
+func printMigrationStatuses(out io.Writer, list []MigrationStatus) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "MOD\tVERSION\tNAME\tSTATUS")
+	for _, item := range list {
+		status := "pending"
+		if item.Missing {
+			status = "missing"
+		} else if item.AppliedAt != nil {
+			status = item.AppliedAt.Format("2006-01-02 15:04:05")
+		} else if item.Applied {
+			status = "applied"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", item.ModCode, item.Version, item.Name, status)
+		for _, stmt := range item.SQL {
+			fmt.Fprintf(w, "\t\t%s\t\n", stmt)
+		}
+	}
+	_ = w.Flush()
+}
+
+func cliSeed(args []string, out io.Writer) error {
+	var (
+		fs   = newCLIFlagSet("seed", out)
+		env  = fs.String("env", "", "环境名称，如dev、test、demo")
+		mods = fs.String("mod", "", "模块编码，多个以逗号分隔")
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	list, err := LoadSeeds(SeedOptions{Env: *env, Mods: splitCLIList(*mods)})
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "MOD\tSEED\tMODEL\tCREATED\tUPDATED\tSKIPPED")
+	for _, item := range list {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\n", item.ModCode, item.Seed, item.Model, item.Created, item.Updated, item.Skipped)
+	}
+	_ = w.Flush()
+	return err
+}
+
+func cliRoot(args []string, out io.Writer) error {
+	var (
+		fs       = newCLIFlagSet("root", out)
+		password = fs.String("password", "", "root用户的新密码")
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if preflight() {
+		fmt.Fprintln(out, "system is already initialized")
+	} else {
+		for _, mod := range modList {
+			if mod.OnInit != nil {
+				if err := mod.OnInit(); err != nil {
+					return err
+				}
+			}
+		}
+		fmt.Fprintln(out, "system initialized")
+	}
+	var root User
+	if err := DB().Where("id = ?", RootUID()).First(&root).Error; err != nil {
+		return fmt.Errorf("root user not found: %w", err)
+	}
+	if *password == "" {
+		return nil
+	}
+	return cliChangePassword(out, root, *password, false)
+}
+
+func cliPasswd(args []string, out io.Writer) error {
+	var (
+		fs       = newCLIFlagSet("passwd", out)
+		username = fs.String("username", "", "用户名")
+		password = fs.String("password", "", "新密码，为空时随机生成")
+		force    = fs.Bool("force", false, "下次登录时强制修改密码")
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *username == "" {
+		return errors.New("username is required")
+	}
+	var user User
+	if err := DB().Where(&User{Username: *username}).First(&user).Error; err != nil {
+		return fmt.Errorf("user not found: %s", *username)
+	}
+	if *password == "" {
+		*password = GenPassword()
+		*force = true
+		fmt.Fprintf(out, "generated password: %s\n", *password)
+	}
+	return cliChangePassword(out, user, *password, *force)
+}
+
+// cliChangePassword 修改用户密码并注销已有会话，plain为明文密码
+func cliChangePassword(out io.Writer, user User, plain string, force bool) error {
+	err := WithTransaction(func(tx *gorm.DB) error {
+		passwd := MD5(plain)
+		if err := ValidateNewPassword(tx, user.ID, passwd, plain); err != nil {
+			return err
+		}
+		if err := ChangeUserPassword(tx, user.ID, passwd, force); err != nil {
+			return err
+		}
+		_, err := RevokeUserSessions(tx, user.ID)
+		return err
+	})
+	if err == nil {
+		fmt.Fprintf(out, "password changed: %s\n", user.Username)
+	}
+	return err
+}
+
+func cliRoutes(_ []string, out io.Writer) error {
+	routesMapMu.RLock()
+	keys := make([]string, 0, len(routesMap))
+	for key := range routesMap {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return cliRoutePath(keys[i]) < cliRoutePath(keys[j]) || (cliRoutePath(keys[i]) == cliRoutePath(keys[j]) && keys[i] < keys[j])
+	})
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "METHOD\tPATH\tNAME")
+	for _, key := range keys {
+		method := strings.SplitN(key, " ", 2)[0]
+		fmt.Fprintf(w, "%s\t%s\t%s\n", method, cliRoutePath(key), routesMap[key].Name)
+	}
+	routesMapMu.RUnlock()
+	return w.Flush()
+}
+
+// cliRoutePath 从routesMap键（METHOD PATH）中解析路径
+func cliRoutePath(key string) string {
+	if split := strings.SplitN(key, " ", 2); len(split) == 2 {
+		return split[1]
+	}
+	return key
+}
+
+func cliJobs(args []string, out io.Writer) error {
+	if len(args) == 0 || args[0] == "list" {
+		jobsMu.RLock()
+		list := make([]*Job, 0, len(jobs))
+		for _, job := range jobs {
+			list = append(list, job)
+		}
+		jobsMu.RUnlock()
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].Name < list[j].Name
+		})
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "CODE\tNAME\tSPEC")
+		for _, job := range list {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", job.Code, job.Name, job.Spec)
+		}
+		return w.Flush()
+	}
+	if args[0] != "run" {
+		return fmt.Errorf("unknown jobs command: %s", args[0])
+	}
+	if len(args) < 2 {
+		return errors.New("usage: jobs run <codes>")
+	}
+	return RunJob(strings.Join(args[1:], ","), true)
+}
+
+func cliIntl(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("usage: intl export|import")
+	}
+	var (
+		fs        = newCLIFlagSet("intl", out)
+		output    = fs.String("o", "", "导出文件，为空时输出到标准输出")
+		input     = fs.String("f", "", "导入文件，格式与导出一致")
+		overwrite = fs.Bool("overwrite", false, "覆盖已有翻译文件")
+	)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	switch args[0] {
+	case "export":
+		data := JSONStringify(getIntlMessages(), true)
+		if *output == "" {
+			_, err := fmt.Fprintln(out, data)
+			return err
+		}
+		return ioutil.WriteFile(*output, []byte(data), 0644)
+	case "import":
+		if *input == "" {
+			return errors.New("-f is required")
+		}
+		buf, err := ioutil.ReadFile(*input)
+		if err != nil {
+			return err
+		}
+		var messages map[string]map[string]string
+		if err := JSON().Unmarshal(buf, &messages); err != nil {
+			return err
+		}
+		if err := saveIntlMessages(messages, *overwrite); err != nil {
+			return err
+		}
+		ReloadIntlMessages()
+		fmt.Fprintf(out, "imported %d messages\n", len(messages))
+		return nil
+	}
+	return fmt.Errorf("unknown intl command: %s", args[0])
+}
+
+func cliCache(args []string, out io.Writer) error {
+	if len(args) < 2 || args[0] != "flush" {
+		return errors.New("usage: cache flush <prefix>...")
+	}
+	if DefaultCache == nil {
+		return errors.New("cache is not initialized")
+	}
+	for _, prefix := range args[1:] {
+		var count int
+		for {
+			values := HasPrefixCache(prefix, 1000)
+			if len(values) == 0 {
+				break
+			}
+			keys := make([]string, 0, len(values))
+			for key := range values {
+				keys = append(keys, key)
+			}
+			DefaultCache.Del(keys...)
+			count += len(keys)
+			if len(keys) < 1000 {
+				break
+			}
+		}
+		fmt.Fprintf(out, "%s: %d keys deleted\n", prefix, count)
+	}
+	return nil
+}
+
+func cliConfig(args []string, out io.Writer) error {
+	var (
+		fs  = newCLIFlagSet("config", out)
+		raw = fs.Bool("raw", false, "显示敏感配置项")
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	path := fs.Arg(0)
+	// flag遇到第一个位置参数即停止解析，兼容写在路径之后的参数
+	if fs.NArg() > 1 {
+		if err := fs.Parse(fs.Args()[1:]); err != nil {
+			return err
+		}
+	}
+	var data interface{}
+	if path != "" {
+		C().GetInterface(path, &data)
+	} else {
+		_ = JSON().Unmarshal(C().data, &data)
+	}
+	if !*raw {
+		// 按路径的最后一段判断是否为敏感配置项
+		data = maskCLIConfig(path[strings.LastIndex(path, ".")+1:], data)
+	}
+	_, err := fmt.Fprintln(out, JSONStringify(data, true))
+	return err
+}
+
+// maskCLIConfig 隐藏敏感配置项的值
+func maskCLIConfig(key string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		masked := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			masked[k] = maskCLIConfig(k, item)
+		}
+		return masked
+	case []interface{}:
+		masked := make([]interface{}, len(v))
+		for i, item := range v {
+			masked[i] = maskCLIConfig(key, item)
+		}
+		return masked
+	case nil:
+		return v
+	}
+	if key != "" && cliSecretRegexp.MatchString(key) {
+		return "******"
+	}
+	return value
+}
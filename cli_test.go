@@ -0,0 +1,67 @@
+package kuu
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRunCommandUsage(t *testing.T) {
+	var out bytes.Buffer
+	if err := RunCommand(nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "migrate up|down|status") {
+		t.Errorf("unexpected usage: %s", out.String())
+	}
+	if err := RunCommand([]string{"unknown"}, &out); err == nil {
+		t.Error("expected unknown command error")
+	}
+}
+
+func TestMaskCLIConfig(t *testing.T) {
+	data := map[string]interface{}{
+		"name": "app",
+		"db":   map[string]interface{}{"dialect": "mysql", "args": "root:123@/db"},
+		"ldap": []interface{}{map[string]interface{}{"bindPassword": "x"}},
+	}
+	masked := maskCLIConfig("", data).(map[string]interface{})
+	if masked["name"] != "app" {
+		t.Errorf("unexpected name: %v", masked["name"])
+	}
+	db := masked["db"].(map[string]interface{})
+	if db["dialect"] != "mysql" || db["args"] != "******" {
+		t.Errorf("unexpected db: %v", db)
+	}
+	ldap := masked["ldap"].([]interface{})[0].(map[string]interface{})
+	if ldap["bindPassword"] != "******" {
+		t.Errorf("unexpected ldap: %v", ldap)
+	}
+}
+
+func TestCLIConfigPath(t *testing.T) {
+	C(map[string]interface{}{"db": map[string]interface{}{"dialect": "mysql", "args": "root:123@/db"}})
+	defer C(map[string]interface{}{"db": nil})
+
+	cases := map[string][]string{
+		`"******"`:       {"config", "db.args"},
+		`"root:123@/db"`: {"config", "-raw", "db.args"},
+		`"mysql"`:        {"config", "db.dialect"},
+	}
+	for expected, args := range cases {
+		var out bytes.Buffer
+		if err := RunCommand(args, &out); err != nil {
+			t.Fatal(err)
+		}
+		if v := strings.TrimSpace(out.String()); v != expected {
+			t.Errorf("%v: expected %s, got %s", args, expected, v)
+		}
+	}
+	var out bytes.Buffer
+	if err := RunCommand([]string{"config", "db", "-raw"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "root:123@/db") {
+		t.Errorf("-raw after the path should be accepted: %s", out.String())
+	}
+}
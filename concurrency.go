@@ -0,0 +1,171 @@
+package kuu
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// updateVersionKey 乐观锁更新时记录读取到的Ts
+const updateVersionKey = "kuu:update_version"
+
+var ErrVersionConflict = errors.New("the record has been modified by someone else")
+
+// newVersionConflictError
+func newVersionConflictError(etag string) error {
+	return NewIntlError(fmt.Errorf("%w: %s", ErrVersionConflict, etag), "rest_update_conflict", "The record has been modified by someone else, please refresh and try again.")
+}
+
+// recordVersion 读取记录的ID和Ts，模型无Ts字段时ok为false
+func recordVersion(value interface{}) (id uint, ts time.Time, ok bool) {
+	v := indirectValue(value)
+	if v.Kind() != reflect.Struct {
+		return
+	}
+	if f := v.FieldByName("Ts"); f.IsValid() {
+		ts, ok = f.Interface().(time.Time)
+	}
+	if f := v.FieldByName("ID"); f.IsValid() {
+		id = explainUintValue(f.Interface())
+	}
+	return
+}
+
+// RecordETag 记录的ETag，格式为"<ID>-<Ts纳秒时间戳>"，模型无Ts字段时返回空
+func RecordETag(value interface{}) string {
+	id, ts, ok := recordVersion(value)
+	if !ok || ts.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("%d-%d", id, ts.UnixNano())
+}
+
+// recordETags 列表中各记录的ETag，以记录ID为键，模型无Ts字段时返回nil
+func recordETags(list interface{}) map[uint]string {
+	v := indirectValue(list)
+	if v.Kind() != reflect.Slice {
+		return nil
+	}
+	var etags map[uint]string
+	for i := 0; i < v.Len(); i++ {
+		item := v.Index(i).Interface()
+		if etag := RecordETag(item); etag != "" {
+			if etags == nil {
+				etags = make(map[uint]string, v.Len())
+			}
+			id, _, _ := recordVersion(item)
+			etags[id] = etag
+		}
+	}
+	return etags
+}
+
+// versionExpectation 客户端期望的记录版本
+type versionExpectation struct {
+	any   bool
+	etags map[string]bool
+	ts    *time.Time
+}
+
+// parseVersionExpectation 从If-Match请求头或更新文档中的Ts解析期望版本，均未指定时返回nil
+func parseVersionExpectation(ifMatch string, doc map[string]interface{}) (*versionExpectation, error) {
+	var e versionExpectation
+	if ifMatch = strings.TrimSpace(ifMatch); ifMatch == "*" {
+		e.any = true
+	} else if ifMatch != "" {
+		e.etags = make(map[string]bool)
+		for _, item := range strings.Split(ifMatch, ",") {
+			item = strings.TrimPrefix(strings.TrimSpace(item), "W/")
+			if item = strings.Trim(item, `"`); item != "" {
+				e.etags[item] = true
+			}
+		}
+	}
+	if raw, has := doc["Ts"]; has {
+		// Ts仅用于版本校验，由回调自动更新
+		delete(doc, "Ts")
+		if s, ok := raw.(string); ok && s != "" {
+			ts, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid Ts: %s", s)
+			}
+			e.ts = &ts
+		}
+	}
+	if !e.any && len(e.etags) == 0 && e.ts == nil {
+		return nil, nil
+	}
+	return &e, nil
+}
+
+// check 校验记录的当前版本，多条记录更新时每条记录均需匹配
+func (e *versionExpectation) check(value interface{}) error {
+	if e == nil || e.any {
+		return nil
+	}
+	_, ts, ok := recordVersion(value)
+	if !ok {
+		return nil
+	}
+	etag := RecordETag(value)
+	if e.ts != nil && !e.ts.Equal(ts) {
+		return newVersionConflictError(etag)
+	}
+	if len(e.etags) > 0 && !e.etags[etag] {
+		return newVersionConflictError(etag)
+	}
+	return nil
+}
+
+// versionChanged 判断记录的Ts是否已被修改（未更新任何记录时区分版本冲突和无权限）
+func versionChanged(tx *gorm.DB, value interface{}, version interface{}) bool {
+	IgnoreAuth()
+	defer IgnoreAuth(true)
+
+	scope := tx.NewScope(value)
+	current := reflect.New(indirectValue(value).Type()).Interface()
+	if err := tx.New().Where(fmt.Sprintf("%v.%v = ?", scope.QuotedTableName(), scope.Quote(scope.PrimaryKey())), scope.PrimaryKeyValue()).First(current).Error; err != nil {
+		// 记录已被删除
+		return gorm.IsRecordNotFoundError(err)
+	}
+	_, ts, ok := recordVersion(current)
+	expected, _ := version.(time.Time)
+	return ok && !ts.Equal(expected)
+}
+
+// repairTsPrecision 将MySQL中已存在的Ts列升级为声明的小数秒精度（自动迁移不会修改已有列），
+// 否则同一秒内两次写入的Ts相同，无法识别过期的更新
+func repairTsPrecision(db *gorm.DB, value interface{}) {
+	if db.Dialect().GetName() != "mysql" {
+		return
+	}
+	scope := db.NewScope(value)
+	field, ok := scope.FieldByName("Ts")
+	if !ok {
+		return
+	}
+	setting, _ := field.TagSettingsGet("PRECISION")
+	precision, err := strconv.Atoi(setting)
+	if err != nil || precision <= 0 {
+		return
+	}
+	var current sql.NullInt64
+	row := db.Raw("SELECT DATETIME_PRECISION FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?", scope.TableName(), field.DBName).Row()
+	if err := row.Scan(&current); err != nil || current.Int64 >= int64(precision) {
+		return
+	}
+	if err := db.Model(value).ModifyColumn(field.DBName, scope.Dialect().DataTypeOf(field.StructField)).Error; err != nil {
+		ERROR("failed to repair ts precision: table=%s, error=%s", scope.TableName(), err.Error())
+	}
+}
+
+// IsVersionConflict 判断是否为乐观锁冲突
+func IsVersionConflict(err error) bool {
+	return errors.Is(err, ErrVersionConflict)
+}
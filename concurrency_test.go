@@ -0,0 +1,96 @@
+package kuu
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+type testVersionedModel struct {
+	ID uint
+	Ts time.Time
+}
+
+func TestRecordETag(t *testing.T) {
+	ts := time.Unix(1600000000, 123)
+	if v := RecordETag(&testVersionedModel{ID: 7, Ts: ts}); v != "7-1600000000000000123" {
+		t.Errorf("unexpected etag: %s", v)
+	}
+	if v := RecordETag(&testVersionedModel{ID: 7}); v != "" {
+		t.Errorf("expected empty etag, got %s", v)
+	}
+	if v := RecordETag(&struct{ ID uint }{ID: 1}); v != "" {
+		t.Errorf("expected empty etag, got %s", v)
+	}
+}
+
+func TestVersionExpectation(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	record := &testVersionedModel{ID: 7, Ts: ts}
+	stale := &testVersionedModel{ID: 8, Ts: ts.Add(time.Second)}
+
+	e, err := parseVersionExpectation("", map[string]interface{}{"Name": "x"})
+	if err != nil || e != nil {
+		t.Fatalf("expected no expectation, got %v, %v", e, err)
+	}
+
+	e, err = parseVersionExpectation(`W/"7-1577934245000000000", "8-1"`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.check(record); err != nil {
+		t.Errorf("unexpected conflict: %v", err)
+	}
+	if err := e.check(stale); !IsVersionConflict(err) {
+		t.Errorf("expected conflict, got %v", err)
+	}
+
+	doc := map[string]interface{}{"Ts": ts.Format(time.RFC3339Nano), "Name": "x"}
+	if e, err = parseVersionExpectation("", doc); err != nil {
+		t.Fatal(err)
+	}
+	if _, has := doc["Ts"]; has {
+		t.Error("Ts should be removed from doc")
+	}
+	if err := e.check(record); err != nil {
+		t.Errorf("unexpected conflict: %v", err)
+	}
+	if err := e.check(stale); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("expected conflict, got %v", err)
+	}
+
+	if e, _ = parseVersionExpectation("*", nil); e.check(stale) != nil {
+		t.Error("wildcard should match any version")
+	}
+	if _, err := parseVersionExpectation("", map[string]interface{}{"Ts": "yesterday"}); err == nil {
+		t.Error("expected invalid Ts error")
+	}
+}
+
+func TestRecordETags(t *testing.T) {
+	ts := time.Unix(1600000000, 0)
+	etags := recordETags(&[]testVersionedModel{{ID: 1, Ts: ts}, {ID: 2}, {ID: 3, Ts: ts.Add(time.Microsecond)}})
+	if len(etags) != 2 || etags[1] != "1-1600000000000000000" || etags[3] != "3-1600000000000001000" {
+		t.Errorf("unexpected etags: %v", etags)
+	}
+	if v := recordETags(&[]struct{ ID uint }{{ID: 1}}); v != nil {
+		t.Errorf("expected nil etags, got %v", v)
+	}
+}
+
+func TestTsPrecision(t *testing.T) {
+	db := testSQLiteDB(t, "")
+	field, ok := db.NewScope(&Model{}).FieldByName("Ts")
+	if !ok {
+		t.Fatal("Ts not found")
+	}
+	dialect, _ := gorm.GetDialect("mysql")
+	if v := dialect.DataTypeOf(field.StructField); v != "DATETIME(6) NULL" {
+		t.Errorf("unexpected mysql type: %s", v)
+	}
+	if now := tsNow(); now.Nanosecond()%1000 != 0 {
+		t.Errorf("expected microsecond precision, got %v", now)
+	}
+}
@@ -98,8 +98,12 @@ func (c *Config) Has(path string) bool {
 // GetInterface returns the value associated with the key.
 func (c *Config) GetInterface(path string, out interface{}) {
 	keys := ParseJSONPath(path)
-	value, _, _, err := jsonparser.Get(c.data, keys...)
+	value, dataType, offset, err := jsonparser.Get(c.data, keys...)
 	if err == nil {
+		// 字符串值不含引号，需取回原始的JSON字符串
+		if dataType == jsonparser.String {
+			value = c.data[offset-len(value)-2 : offset]
+		}
 		_ = json.Unmarshal(value, out)
 	}
 }
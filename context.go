@@ -357,6 +357,11 @@ func (c *Context) WithTransaction(fn func(*gorm.DB) error) error {
 	return WithTransaction(fn)
 }
 
+// WithModelTransaction 在模型绑定的数据源上开启事务
+func (c *Context) WithModelTransaction(value interface{}, fn func(*gorm.DB) error) error {
+	return WithModelTransaction(value, fn)
+}
+
 // SetValue
 func (c *Context) SetRoutineCache(key string, value interface{}) {
 	SetRoutineCache(key, value)
@@ -378,6 +383,12 @@ func (c *Context) IgnoreAuth(cancel ...bool) *Context {
 	return c
 }
 
+// TrustedClientIP 用于登录限流、IP白名单等安全校验的客户端IP，
+// 与ClientIP不同，只有请求来自配置项trustedProxies中的代理时才采用X-Forwarded-For
+func (c *Context) TrustedClientIP() string {
+	return trustedClientIP(c.Request.RemoteAddr, c.GetHeader("X-Forwarded-For"), GetTrustedProxies())
+}
+
 // Scheme
 func (c *Context) Scheme() string {
 	// Can't use `r.Request.URL.Scheme`
@@ -19,6 +19,8 @@ var (
 	dataSourcesMap sync.Map
 	mgr            = gls.NewContextManager()
 	singleDSName   = "kuu_default_db"
+	// 系统callback注册后打开的数据源需立即注册
+	callbacksRegistered bool
 )
 
 type dataSource struct {
@@ -28,6 +30,7 @@ type dataSource struct {
 	EnableTLS bool
 	TLSName   string
 	CAPath    string
+	Replicas  []dataSource // 只读副本，未指定Dialect时与主库一致
 }
 
 func (ds *dataSource) isBlank() bool {
@@ -88,11 +91,17 @@ func initDataSources() {
 			singleDSName = firstDSName
 		}
 		openDB(ds)
+		openReplicas(ds)
 	}
 }
 
 func openDB(ds dataSource) {
+	if err := openDataSource(ds); err != nil {
+		panic(err)
+	}
+}
 
+func openDataSource(ds dataSource) error {
 	if ds.EnableTLS {
 		RegisterDBTLS(ds.TLSName, ds.CAPath)
 		ds.Args = ResetDSN(ds.Dialect, ds.Args, ds.TLSName)
@@ -100,20 +109,69 @@ func openDB(ds dataSource) {
 
 	db, err := gorm.Open(ds.Dialect, ds.Args)
 	if err != nil {
-		panic(err)
-	} else {
-		connectedPrint(strings.Title(db.Dialect().GetName()), db.Dialect().CurrentDatabase())
-		dataSourcesMap.Store(ds.Name, db)
-		if gin.IsDebugging() {
-			db.LogMode(true)
-			db.SetLogger(dbLogger{})
+		return err
+	}
+	connectedPrint(strings.Title(db.Dialect().GetName()), db.Dialect().CurrentDatabase())
+	if callbacksRegistered {
+		registerDBCallbacks(db)
+	}
+	dataSourcesMap.Store(ds.Name, db)
+	if gin.IsDebugging() {
+		db.LogMode(true)
+		db.SetLogger(dbLogger{})
+	}
+	return nil
+}
+
+// RegisterDataSource 运行时注册数据源，同名数据源已存在时忽略
+func RegisterDataSource(name, dialect, args string) error {
+	if name == "" || dialect == "" || args == "" {
+		return fmt.Errorf("invalid data source: name=%s, dialect=%s", name, dialect)
+	}
+	if HasDataSource(name) {
+		return nil
+	}
+	return openDataSource(dataSource{Name: name, Dialect: dialect, Args: args})
+}
+
+// unregisterDataSource 关闭并移除运行时注册的数据源
+func unregisterDataSource(name string) {
+	if v, ok := dataSourcesMap.Load(name); ok {
+		dataSourcesMap.Delete(name)
+		if err := v.(*gorm.DB).Close(); err != nil {
+			ERROR(err)
 		}
 	}
 }
 
-// DB
+// HasDataSource 判断数据源是否已注册
+func HasDataSource(name string) bool {
+	_, ok := dataSourcesMap.Load(name)
+	return ok
+}
+
+// DB 返回当前协程绑定的数据源（如租户数据源），未绑定时返回默认数据源
 func DB() *gorm.DB {
-	return DS("")
+	return DS(GetRoutineDataSource())
+}
+
+// GetRoutineDataSource 查询当前协程绑定的数据源名称
+func GetRoutineDataSource() string {
+	if raw, ok := GetGLSValue(GLSDataSourceKey); ok && raw != nil {
+		return raw.(string)
+	}
+	return ""
+}
+
+// WithDataSource 在指定数据源上执行fn，期间DB()返回该数据源
+func WithDataSource(name string, fn func() error) (err error) {
+	if !HasDataSource(name) {
+		return fmt.Errorf("no data source named \"%s\"", name)
+	}
+	SetGLSValues(gls.Values{GLSDataSourceKey: name}, func() {
+		err = fn()
+	})
+	return
 }
 
 // DS
@@ -131,9 +189,52 @@ func DS(name string) *gorm.DB {
 	return nil
 }
 
+// ModelDataSource 查询模型绑定的数据源名称（ds标签或Mod.DataSource），未绑定时返回空
+func ModelDataSource(value interface{}) string {
+	if meta := Meta(value); meta != nil {
+		return meta.DataSource
+	}
+	return ""
+}
+
+// ModelDB 返回模型绑定的数据源，未绑定时返回DB()
+func ModelDB(value interface{}) *gorm.DB {
+	if name := ModelDataSource(value); name != "" {
+		return DS(name)
+	}
+	return DB()
+}
+
+// ModelReadDB 返回模型绑定数据源的只读副本，未绑定时返回ReadDB()
+func ModelReadDB(value interface{}) *gorm.DB {
+	if name := ModelDataSource(value); name != "" {
+		return DSRead(name)
+	}
+	return ReadDB()
+}
+
+// WithModelDataSource 在模型绑定的数据源上执行fn，期间DB()和WithTransaction均使用该数据源，未绑定时直接执行
+func WithModelDataSource(value interface{}, fn func() error) error {
+	name := ModelDataSource(value)
+	if name == "" || name == GetRoutineDataSource() {
+		return fn()
+	}
+	return WithDataSource(name, fn)
+}
+
 // WithTransaction
-func WithTransaction(fn func(*gorm.DB) error) (err error) {
-	tx := DB().Begin()
+func WithTransaction(fn func(*gorm.DB) error) error {
+	return withDBTransaction(DB(), fn)
+}
+
+// WithModelTransaction 在模型绑定的数据源上开启事务执行fn，仅fn中的tx使用该数据源，DB()不受影响
+func WithModelTransaction(value interface{}, fn func(*gorm.DB) error) error {
+	return withDBTransaction(ModelDB(value), fn)
+}
+
+// withDBTransaction 在指定数据源上开启事务执行fn
+func withDBTransaction(db *gorm.DB, fn func(*gorm.DB) error) (err error) {
+	tx := db.Begin()
 	if tx.Error != nil {
 		err = tx.Error
 		return
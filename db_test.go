@@ -0,0 +1,108 @@
+package kuu
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// testSQLiteDB 注册基于临时sqlite文件的数据源，name为空时注册为默认数据源，测试结束后关闭
+func testSQLiteDB(t *testing.T, name string) *gorm.DB {
+	t.Helper()
+	if name == "" {
+		name = singleDSName
+	}
+	file := filepath.Join(t.TempDir(), name+".db")
+	if err := RegisterDataSource(name, "sqlite3", file); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { unregisterDataSource(name) })
+	return DS(name)
+}
+
+// testContext 创建测试请求上下文
+func testContext(method, target, body string) *Context {
+	ginCtx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ginCtx.Request = httptest.NewRequest(method, target, strings.NewReader(body))
+	ginCtx.Request.Header.Set("Content-Type", "application/json")
+	return &Context{Context: ginCtx, SignInfo: &SignContext{}}
+}
+
+type testReportRow struct {
+	ID    uint `gorm:"primary_key"`
+	Title string
+}
+
+type testTaggedReportRow struct {
+	ID    uint   `gorm:"primary_key"`
+	Title string `ds:"report_tagged"`
+}
+
+func TestResolveModelDataSource(t *testing.T) {
+	testSQLiteDB(t, "")
+	testSQLiteDB(t, "report")
+
+	meta := parseMetadata(&testReportRow{})
+	if err := resolveModelDataSource(meta, &Mod{Code: "report", DataSource: "report"}); err != nil {
+		t.Fatal(err)
+	}
+	if v := ModelDataSource(&testReportRow{}); v != "report" {
+		t.Errorf("expected mod data source, got %q", v)
+	}
+
+	// ds标签优先于Mod.DataSource，数据源未注册时返回错误
+	tagged := parseMetadata(&testTaggedReportRow{})
+	if tagged.DataSource != "report_tagged" {
+		t.Fatalf("expected ds tag, got %q", tagged.DataSource)
+	}
+	err := resolveModelDataSource(tagged, &Mod{Code: "report", DataSource: "report"})
+	if err == nil || !strings.Contains(err.Error(), `"report_tagged"`) {
+		t.Errorf("expected unknown data source error, got %v", err)
+	}
+	if tagged.DataSource != "report_tagged" {
+		t.Errorf("ds tag should not be overridden, got %q", tagged.DataSource)
+	}
+}
+
+func TestRestHandlerModelDataSource(t *testing.T) {
+	defaultDB := testSQLiteDB(t, "")
+	reportDB := testSQLiteDB(t, "report")
+
+	meta := parseMetadata(&testReportRow{})
+	if err := resolveModelDataSource(meta, &Mod{Code: "report", DataSource: "report"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := reportDB.AutoMigrate(&testReportRow{}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 业务回调中的DB()仍为默认数据源，只有模型自身的读写使用绑定的数据源
+	var routineDS string
+	DefaultCallback.Create().Before("kuu:biz_before_create").Register("test:report_ds", func(scope *Scope) {
+		if _, ok := scope.Value.(*testReportRow); ok {
+			routineDS = GetRoutineDataSource()
+		}
+	})
+	defer DefaultCallback.Create().Remove("test:report_ds")
+
+	reply := restCreateHandler(meta.reflectType)(testContext("POST", "/api/testreportrow", `{"Title":"q1"}`))
+	if reply.Code != 0 {
+		t.Fatalf("unexpected reply: %+v", reply)
+	}
+	if routineDS != "" {
+		t.Errorf("DB() should not be rebound, got %q", routineDS)
+	}
+	var count int
+	reportDB.Model(&testReportRow{}).Count(&count)
+	if count != 1 {
+		t.Errorf("expected the row in the report data source, got %d", count)
+	}
+	if defaultDB.HasTable(&testReportRow{}) {
+		t.Error("unexpected table in the default data source")
+	}
+}
@@ -0,0 +1,520 @@
+package kuu
+
+import (
+	"database/sql/driver"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/jtolds/gls"
+	uuid "github.com/satori/go.uuid"
+	"github.com/xuri/excelize/v2"
+)
+
+// 导出格式
+const (
+	ExportFormatXLSX = "xlsx"
+	ExportFormatCSV  = "csv"
+)
+
+var ErrExportFormat = errors.New("unsupported export format")
+
+// ExportConfig 导出配置，对应配置项export
+type ExportConfig struct {
+	BatchSize      int // 每批查询的记录数
+	AsyncThreshold int // 记录数超过该值时转为异步任务，生成文件后可通过文件UID查询
+}
+
+// GetExportConfig 读取导出配置
+func GetExportConfig() ExportConfig {
+	config := ExportConfig{BatchSize: 1000, AsyncThreshold: 50000}
+	C().GetInterface("export", &config)
+	if config.BatchSize <= 0 {
+		config.BatchSize = 1000
+	}
+	return config
+}
+
+// ExportColumn 导出列
+type ExportColumn struct {
+	Field string // 字段名
+	Label string // 表头
+	Enum  string // 枚举编码，导出时显示枚举标签
+}
+
+// ExportAsyncResult 异步导出的响应，同时生成Class为export、状态为pending的文件记录，
+// 导出完成后状态清空，失败时为failed
+type ExportAsyncResult struct {
+	Async bool
+	UID   string
+	Name  string
+	Total int
+}
+
+// exportIgnoreFields 导出时默认忽略的字段
+var exportIgnoreFields = map[string]bool{
+	"DeletedAt":   true,
+	"DeletedByID": true,
+	"Dr":          true,
+}
+
+// ExportColumns 根据project和preload生成导出列，表头优先使用国际化标签
+func ExportColumns(c *Context, meta *Metadata, project, preload string) (columns []ExportColumn) {
+	label := func(field MetadataField) string {
+		name := field.Name
+		if name == "" {
+			name = field.Code
+		}
+		if field.LocaleKey != "" && c != nil {
+			return c.L(field.LocaleKey, name)
+		}
+		return name
+	}
+	fields := make(map[string]MetadataField, len(meta.Fields))
+	for _, field := range meta.Fields {
+		fields[field.Code] = field
+	}
+	if project != "" {
+		for _, name := range strings.Split(project, ",") {
+			if field, ok := fields[name]; ok && !field.IsPassword {
+				columns = append(columns, ExportColumn{Field: field.Code, Label: label(field), Enum: field.Enum})
+			}
+		}
+	} else {
+		for _, field := range meta.Fields {
+			if field.IsPassword || exportIgnoreFields[field.Code] {
+				continue
+			}
+			columns = append(columns, ExportColumn{Field: field.Code, Label: label(field), Enum: field.Enum})
+		}
+	}
+	// 预加载的关联字段
+	for _, name := range strings.Split(preload, ",") {
+		if name == "" || strings.Contains(name, ".") {
+			continue
+		}
+		if fieldStruct, ok := meta.reflectType.FieldByName(name); ok {
+			field := MetadataField{Code: name, Name: fieldStruct.Tag.Get("name"), LocaleKey: fieldStruct.Tag.Get("locale")}
+			columns = append(columns, ExportColumn{Field: name, Label: label(field)})
+		}
+	}
+	return
+}
+
+// exportRow 生成导出行
+func exportRow(columns []ExportColumn, record reflect.Value) []interface{} {
+	for record.Kind() == reflect.Ptr {
+		record = record.Elem()
+	}
+	row := make([]interface{}, len(columns))
+	for i, column := range columns {
+		value := record.FieldByName(column.Field)
+		if !value.IsValid() {
+			continue
+		}
+		cell := exportValue(value)
+		if column.Enum != "" && cell != nil {
+			if label := GetEnumLabel(column.Enum, cell); label != "" {
+				cell = label
+			} else if label := GetEnumLabel(column.Enum, fmt.Sprint(cell)); label != "" {
+				cell = label
+			}
+		}
+		row[i] = cell
+	}
+	return row
+}
+
+// exportValue 单元格的值，关联记录显示其名称、编码或ID
+func exportValue(value reflect.Value) interface{} {
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	raw := value.Interface()
+	switch v := raw.(type) {
+	case time.Time:
+		if v.IsZero() {
+			return nil
+		}
+		return v
+	case driver.Valuer:
+		if dv, err := v.Value(); err == nil {
+			return dv
+		}
+		return nil
+	}
+	switch value.Kind() {
+	case reflect.Struct:
+		for _, name := range []string{"Name", "Code", "ID"} {
+			if f := value.FieldByName(name); f.IsValid() {
+				return exportValue(f)
+			}
+		}
+		return JSONStringify(raw)
+	case reflect.Slice:
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			return string(value.Bytes())
+		}
+		items := make([]string, 0, value.Len())
+		for i := 0; i < value.Len(); i++ {
+			if item := exportValue(value.Index(i)); item != nil {
+				items = append(items, fmt.Sprint(item))
+			}
+		}
+		return strings.Join(items, ",")
+	case reflect.Map:
+		return JSONStringify(raw)
+	}
+	return raw
+}
+
+// exportWriter 导出写入器
+type exportWriter interface {
+	WriteRow(row []interface{}) error
+	Close() error
+}
+
+// xlsxExportWriter 基于StreamWriter逐行写入，避免大数据量时占用过多内存
+type xlsxExportWriter struct {
+	out    io.Writer
+	file   *excelize.File
+	stream *excelize.StreamWriter
+	rows   int
+}
+
+func (w *xlsxExportWriter) WriteRow(row []interface{}) error {
+	w.rows++
+	axis, err := excelize.CoordinatesToCellName(1, w.rows)
+	if err != nil {
+		return err
+	}
+	return w.stream.SetRow(axis, row)
+}
+
+func (w *xlsxExportWriter) Close() error {
+	defer func() {
+		if err := w.file.Close(); err != nil {
+			ERROR(err)
+		}
+	}()
+	if err := w.stream.Flush(); err != nil {
+		return err
+	}
+	_, err := w.file.WriteTo(w.out)
+	return err
+}
+
+// csvExportWriter
+type csvExportWriter struct {
+	writer *csv.Writer
+	rows   int
+}
+
+func (w *csvExportWriter) WriteRow(row []interface{}) error {
+	record := make([]string, len(row))
+	for i, cell := range row {
+		switch v := cell.(type) {
+		case nil:
+		case time.Time:
+			record[i] = v.Format("2006-01-02 15:04:05")
+		case string:
+			record[i] = csvEscapeFormula(v)
+		default:
+			record[i] = fmt.Sprint(v)
+		}
+	}
+	if w.rows++; w.rows%1000 == 0 {
+		w.writer.Flush()
+	}
+	return w.writer.Write(record)
+}
+
+// csvEscapeFormula 以'前缀转义可能被表格软件解析为公式的文本，避免公式注入
+func csvEscapeFormula(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func (w *csvExportWriter) Close() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+// newExportWriter
+func newExportWriter(format string, out io.Writer) (exportWriter, error) {
+	switch format {
+	case ExportFormatXLSX:
+		file := excelize.NewFile()
+		stream, err := file.NewStreamWriter("Sheet1")
+		if err != nil {
+			return nil, err
+		}
+		return &xlsxExportWriter{out: out, file: file, stream: stream}, nil
+	case ExportFormatCSV:
+		// 写入BOM，避免Excel打开中文乱码
+		if _, err := out.Write([]byte("\xEF\xBB\xBF")); err != nil {
+			return nil, err
+		}
+		return &csvExportWriter{writer: csv.NewWriter(out)}, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrExportFormat, format)
+}
+
+// exportKeysetKey 按主键游标分批查询的排序方向，未设置时使用offset分批查询
+const exportKeysetKey = "kuu:export_keyset"
+
+// ExportQuery 分批查询并写入导出文件，查询前执行BizBeforeFind钩子，每批查询后执行BizAfterFind钩子
+func ExportQuery(c *Context, reflectType reflect.Type, db *gorm.DB, columns []ExportColumn, format string, out io.Writer) error {
+	db, err := exportBeforeQuery(c, reflectType, db)
+	if err != nil {
+		return err
+	}
+	return exportRows(c, reflectType, db, columns, format, out)
+}
+
+// exportBeforeQuery 执行查询前钩子，钩子可追加查询条件
+func exportBeforeQuery(c *Context, reflectType reflect.Type, db *gorm.DB) (*gorm.DB, error) {
+	bizScope := NewBizScope(c, reflect.New(reflectType).Interface(), db)
+	bizScope.QueryResult = &BizQueryResult{Range: "ALL"}
+	if bizBeforeQueryCallback(bizScope); bizScope.HasError() {
+		return nil, bizScope.DB.Error
+	}
+	return bizScope.DB, nil
+}
+
+// exportRows 分批查询已执行查询前钩子的db并写入导出文件。
+// 设置了exportKeysetKey时按主键游标分批，否则以主键作为最后的排序字段按offset分批，
+// 后者在数据量大时越往后越慢，且导出期间有新增或删除时可能重复或遗漏记录
+func exportRows(c *Context, reflectType reflect.Type, db *gorm.DB, columns []ExportColumn, format string, out io.Writer) (err error) {
+	writer, err := newExportWriter(format, out)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := writer.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	header := make([]interface{}, len(columns))
+	for i, column := range columns {
+		header[i] = column.Label
+	}
+	if err := writer.WriteRow(header); err != nil {
+		return err
+	}
+	var (
+		bizScope  = NewBizScope(c, reflect.New(reflectType).Interface(), db)
+		query     = bizScope.DB
+		scope     = query.NewScope(bizScope.Value)
+		pk        = scope.PrimaryField()
+		pkColumn  string
+		keyset, _ = query.Get(exportKeysetKey)
+		direction = fmt.Sprint(keyset)
+		last      interface{}
+	)
+	bizScope.QueryResult = &BizQueryResult{Range: "ALL"}
+	if pk != nil {
+		pkColumn = fmt.Sprintf("%s.%s", scope.QuotedTableName(), scope.Quote(pk.DBName))
+		if keyset != nil {
+			query = query.Order(fmt.Sprintf("%s %s", pkColumn, direction), true)
+		} else {
+			query = query.Order(pkColumn)
+		}
+	} else {
+		keyset = nil
+	}
+	batchSize := GetExportConfig().BatchSize
+	for offset := 0; ; offset += batchSize {
+		list := reflect.New(reflect.SliceOf(reflectType)).Interface()
+		batch := query.Limit(batchSize)
+		switch {
+		case keyset == nil:
+			batch = batch.Offset(offset)
+		case last != nil && direction == "desc":
+			batch = batch.Where(fmt.Sprintf("%s < ?", pkColumn), last)
+		case last != nil:
+			batch = batch.Where(fmt.Sprintf("%s > ?", pkColumn), last)
+		}
+		if err := batch.Find(list).Error; err != nil {
+			return err
+		}
+		// 在钩子修改结果前记录本批数量和最后一条记录的主键
+		count := indirectValue(list).Len()
+		if keyset != nil && count > 0 {
+			last = reflect.Indirect(indirectValue(list).Index(count - 1)).FieldByName(pk.Name).Interface()
+		}
+		bizScope.QueryResult.List = list
+		if bizAfterQueryCallback(bizScope); bizScope.HasError() {
+			return bizScope.DB.Error
+		}
+		records := indirectValue(bizScope.QueryResult.List)
+		for i := 0; i < records.Len(); i++ {
+			if err := writer.WriteRow(exportRow(columns, records.Index(i))); err != nil {
+				return err
+			}
+		}
+		if count < batchSize {
+			return nil
+		}
+	}
+}
+
+// exportFileName 导出文件名
+func exportFileName(meta *Metadata, format string) string {
+	name := meta.DisplayName
+	if name == "" {
+		name = meta.Name
+	}
+	return fmt.Sprintf("%s_%s.%s", name, time.Now().Format("20060102150405"), format)
+}
+
+// restExportHandler 导出查询结果，记录数超过阈值或指定async时转为异步任务
+func restExportHandler(c *Context, reflectType reflect.Type, db *gorm.DB, ret *BizQueryResult, format string) *STDReply {
+	format = strings.ToLower(format)
+	if format != ExportFormatXLSX && format != ExportFormatCSV {
+		return c.STDErr(fmt.Errorf("%w: %s", ErrExportFormat, format), "rest_export_failed", "Export failed")
+	}
+	var (
+		meta     = Meta(reflect.New(reflectType).Interface())
+		columns  = ExportColumns(c, meta, ret.Project, ret.Preload)
+		fileName = exportFileName(meta, format)
+		total    int
+	)
+	// 未指定排序时按主键游标分批查询，默认排序与列表查询一致
+	if ret.Sort == "" {
+		direction := "asc"
+		if db.NewScope(reflect.New(reflectType).Interface()).HasColumn("created_at") {
+			direction = "desc"
+		}
+		db = db.Set(exportKeysetKey, direction)
+	}
+	// 查询前钩子可追加查询条件，需在统计记录数前执行
+	db, err := exportBeforeQuery(c, reflectType, db)
+	if err != nil {
+		return c.STDErr(err, "rest_export_failed", "Export failed")
+	}
+	if err := db.Count(&total).Error; err != nil {
+		return c.STDErr(err, "rest_export_failed", "Export failed")
+	}
+	if threshold := GetExportConfig().AsyncThreshold; c.Query("async") != "" || (threshold > 0 && total > threshold) {
+		result := ExportAsyncResult{
+			Async: true,
+			UID:   strings.ReplaceAll(uuid.NewV4().String(), "-", ""),
+			Name:  fileName,
+			Total: total,
+		}
+		if err := exportAsync(c, reflectType, db, columns, format, result); err != nil {
+			return c.STDErr(err, "rest_export_failed", "Export failed")
+		}
+		return c.STD(result)
+	}
+
+	c.Header("Content-Transfer-Encoding", "binary")
+	c.Header("Content-Disposition", "attachment; filename="+url.QueryEscape(fileName))
+	if format == ExportFormatCSV {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		c.Header("Content-Type", "application/octet-stream")
+	}
+	if err := exportRows(c, reflectType, db, columns, format, c.Writer); err != nil {
+		// 响应已开始写入时无法再返回错误信息
+		ERROR("export %s failed: %v", meta.Name, err)
+		if !c.Writer.Written() {
+			return c.STDErr(err, "rest_export_failed", "Export failed")
+		}
+	}
+	return nil
+}
+
+// exportFileDB 导出文件记录所在的数据源：当前租户数据源或默认数据源，不受模型绑定的数据源影响
+func exportFileDB() *gorm.DB {
+	if code := GetRoutineTenant(); code != "" {
+		return DS(TenantDataSourceName(code))
+	}
+	return DS("")
+}
+
+// exportAsync 生成状态为pending的文件记录，并在后台协程中导出到上传目录，完成或失败后更新文件状态
+func exportAsync(c *Context, reflectType reflect.Type, db *gorm.DB, columns []ExportColumn, format string, result ExportAsyncResult) error {
+	var (
+		uploadDir = GetUploadDir()
+		name      = fmt.Sprintf("%s.%s", result.UID, format)
+		dst       = path.Join(uploadDir, name)
+		fileDB    = exportFileDB()
+		file      = File{
+			UID:       result.UID,
+			Class:     "export",
+			OwnerType: Meta(reflect.New(reflectType).Interface()).Name,
+			Type:      map[string]string{ExportFormatXLSX: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", ExportFormatCSV: "text/csv"}[format],
+			Name:      result.Name,
+			URL:       fmt.Sprintf("/%s/%s", strings.Trim(uploadDir, "/"), name),
+			Path:      dst,
+			Status:    FileStatusPending,
+		}
+	)
+	if err := fileDB.Create(&file).Error; err != nil {
+		return err
+	}
+	// 请求结束后gin.Context会被复用，需复制上下文并沿用当前用户的数据权限和租户
+	ac := &Context{
+		Context:       c.Context.Copy(),
+		app:           c.app,
+		SignInfo:      c.SignInfo,
+		PrisDesc:      c.PrisDesc,
+		RoutineCaches: make(RoutineCaches),
+		RouteInfo:     c.RouteInfo,
+	}
+	values := gls.Values{
+		GLSSignInfoKey:       ac.SignInfo,
+		GLSPrisDescKey:       ac.PrisDesc,
+		GLSRoutineCachesKey:  ac.RoutineCaches,
+		GLSRequestContextKey: ac,
+	}
+	if code := GetRoutineTenant(); code != "" {
+		values[GLSTenantKey] = code
+		values[GLSDataSourceKey] = TenantDataSourceName(code)
+	}
+	go SetGLSValues(values, func() {
+		err := func() (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("%v", r)
+				}
+			}()
+			out, err := os.Create(dst)
+			if err != nil {
+				return err
+			}
+			defer out.Close()
+			if err := exportRows(ac, reflectType, db, columns, format, out); err != nil {
+				return err
+			}
+			info, err := out.Stat()
+			if err != nil {
+				return err
+			}
+			return fileDB.Model(&file).Updates(map[string]interface{}{"size": info.Size(), "status": ""}).Error
+		}()
+		if err != nil {
+			ERROR("async export %s failed: %v", result.Name, err)
+			_ = os.Remove(dst)
+			if err := fileDB.Model(&file).Update("status", FileStatusFailed).Error; err != nil {
+				ERROR("failed to mark export %s as failed: %v", result.Name, err)
+			}
+		}
+	})
+	return nil
+}
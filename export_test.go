@@ -0,0 +1,233 @@
+package kuu
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"gopkg.in/guregu/null.v3"
+)
+
+func TestExportRowCSV(t *testing.T) {
+	type exportRole struct {
+		Code string
+		Name string
+	}
+	type exportUser struct {
+		ID        uint
+		Username  string
+		Remark    null.String
+		CreatedAt time.Time
+		Role      *exportRole
+		Roles     []exportRole
+	}
+	columns := []ExportColumn{
+		{Field: "ID", Label: "ID"},
+		{Field: "Username", Label: "账号"},
+		{Field: "Remark", Label: "备注"},
+		{Field: "CreatedAt", Label: "创建时间"},
+		{Field: "Role", Label: "角色"},
+		{Field: "Roles", Label: "角色列表"},
+		{Field: "Unknown", Label: "未知"},
+	}
+	user := exportUser{
+		ID:        1,
+		Username:  "admin",
+		Remark:    null.StringFrom("a,b"),
+		CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Role:      &exportRole{Code: "admin", Name: "管理员"},
+		Roles:     []exportRole{{Code: "a", Name: "A"}, {Code: "b", Name: "B"}},
+	}
+	var buf bytes.Buffer
+	writer, err := newExportWriter(ExportFormatCSV, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.WriteRow(exportRow(columns, reflect.ValueOf(&user))); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expected := "\xEF\xBB\xBF1,admin,\"a,b\",2024-01-02 03:04:05,管理员,\"A,B\",\n"
+	if buf.String() != expected {
+		t.Errorf("unexpected csv: %q", buf.String())
+	}
+	buf.Reset()
+	if writer, err = newExportWriter(ExportFormatXLSX, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.WriteRow(exportRow(columns, reflect.ValueOf(&user))); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("PK")) {
+		t.Error("expected xlsx content")
+	}
+	if _, err := newExportWriter("pdf", &buf); err == nil {
+		t.Error("expected unsupported format error")
+	}
+}
+
+func TestCSVEscapeFormula(t *testing.T) {
+	var buf bytes.Buffer
+	writer, err := newExportWriter(ExportFormatCSV, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.WriteRow([]interface{}{"=SUM(A1:A2)", "+1", "-1", "@cmd", "a=b", -1, ""}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expected := "\xEF\xBB\xBF'=SUM(A1:A2),'+1,'-1,'@cmd,a=b,-1,\n"
+	if buf.String() != expected {
+		t.Errorf("unexpected csv: %q", buf.String())
+	}
+}
+
+type testExportItem struct {
+	ID        uint `gorm:"primary_key"`
+	Code      string
+	CreatedAt time.Time
+}
+
+func TestExportQueryOrder(t *testing.T) {
+	db := testSQLiteDB(t, "")
+	parseMetadata(&testExportItem{})
+	if err := db.AutoMigrate(&testExportItem{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, code := range []string{"a", "b", "c", "d", "e"} {
+		db.Create(&testExportItem{Code: code, CreatedAt: now})
+	}
+	C(map[string]interface{}{"export": map[string]interface{}{"batchSize": 2}})
+	defer C(map[string]interface{}{"export": nil})
+
+	var buf bytes.Buffer
+	query := db.Model(&testExportItem{}).Order("created_at desc")
+	columns := []ExportColumn{{Field: "Code", Label: "Code"}}
+	if err := ExportQuery(testContext("GET", "/api/testexportitem", ""), reflect.TypeOf(testExportItem{}), query, columns, ExportFormatCSV, &buf); err != nil {
+		t.Fatal(err)
+	}
+	// 排序字段相同时按主键排序，分批查询不重复、不遗漏
+	expected := "\xEF\xBB\xBFCode\na\nb\nc\nd\ne\n"
+	if buf.String() != expected {
+		t.Errorf("unexpected csv: %q", buf.String())
+	}
+}
+
+func TestExportAsyncStatus(t *testing.T) {
+	db := testSQLiteDB(t, "")
+	parseMetadata(&testExportItem{})
+	if err := db.AutoMigrate(&File{}, &testExportItem{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	C(map[string]interface{}{"uploadDir": t.TempDir()})
+	defer C(map[string]interface{}{"uploadDir": nil})
+
+	export := func(uid string, query *gorm.DB) File {
+		result := ExportAsyncResult{Async: true, UID: uid, Name: uid + ".csv"}
+		columns := []ExportColumn{{Field: "Code", Label: "Code"}}
+		if err := exportAsync(testContext("GET", "/api/testexportitem", ""), reflect.TypeOf(testExportItem{}), query, columns, ExportFormatCSV, result); err != nil {
+			t.Fatal(err)
+		}
+		var file File
+		for i := 0; i < 100; i++ {
+			if err := db.Where(&File{UID: uid}).First(&file).Error; err != nil {
+				t.Fatal(err)
+			}
+			if file.Status != FileStatusPending {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		return file
+	}
+	db.Create(&testExportItem{Code: "a"})
+	if file := export("ok", db.Model(&testExportItem{})); file.Status != "" || file.Size == 0 {
+		t.Errorf("unexpected file: %+v", file)
+	}
+	// 导出失败时记录失败状态
+	if file := export("failed", db.Table("missing_table")); file.Status != FileStatusFailed {
+		t.Errorf("expected failed status, got %+v", file)
+	}
+}
+
+func TestExportQueryKeyset(t *testing.T) {
+	db := testSQLiteDB(t, "")
+	parseMetadata(&testExportItem{})
+	if err := db.AutoMigrate(&testExportItem{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, code := range []string{"a", "b", "c", "d", "e"} {
+		db.Create(&testExportItem{Code: code})
+	}
+	C(map[string]interface{}{"export": map[string]interface{}{"batchSize": 2}})
+	defer C(map[string]interface{}{"export": nil})
+
+	// 按主键游标分批时替换原有排序
+	var buf bytes.Buffer
+	query := db.Model(&testExportItem{}).Order("code").Set(exportKeysetKey, "desc")
+	columns := []ExportColumn{{Field: "Code", Label: "Code"}}
+	if err := ExportQuery(testContext("GET", "/api/testexportitem", ""), reflect.TypeOf(testExportItem{}), query, columns, ExportFormatCSV, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "\xEF\xBB\xBFCode\ne\nd\nc\nb\na\n"; buf.String() != expected {
+		t.Errorf("unexpected csv: %q", buf.String())
+	}
+}
+
+type testExportHookItem struct {
+	ID   uint `gorm:"primary_key"`
+	Code string
+}
+
+func TestRestExportHandlerTotal(t *testing.T) {
+	db := testSQLiteDB(t, "")
+	parseMetadata(&testExportHookItem{})
+	if err := db.AutoMigrate(&File{}, &testExportHookItem{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, code := range []string{"a", "b", "c"} {
+		db.Create(&testExportHookItem{Code: code})
+	}
+	C(map[string]interface{}{"uploadDir": t.TempDir()})
+	defer C(map[string]interface{}{"uploadDir": nil})
+	RegisterBizHook("testExportHookItem:BizBeforeFind", func(scope *Scope) error {
+		scope.DB = scope.DB.Where("code <> ?", "a")
+		return nil
+	})
+
+	// 记录数在查询前钩子追加条件后统计
+	c := testContext("GET", "/api/testexporthookitem?async=1", "")
+	reply := restExportHandler(c, reflect.TypeOf(testExportHookItem{}), db.Model(&testExportHookItem{}), &BizQueryResult{}, ExportFormatCSV)
+	result, ok := reply.Data.(ExportAsyncResult)
+	if !ok || result.Total != 2 {
+		t.Fatalf("unexpected reply: %+v", reply.Data)
+	}
+	var file File
+	for i := 0; i < 100; i++ {
+		if err := db.Where(&File{UID: result.UID}).First(&file).Error; err != nil {
+			t.Fatal(err)
+		}
+		if file.Status != FileStatusPending {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	buf, err := ioutil.ReadFile(file.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "\xEF\xBB\xBFID,Code\n2,b\n3,c\n"; string(buf) != expected {
+		t.Errorf("unexpected csv: %q", buf)
+	}
+}
@@ -29,6 +29,10 @@ func (e *IntlError) Error() string {
 	return e.Err.Error()
 }
 
+func (e *IntlError) Unwrap() error {
+	return e.Err
+}
+
 func NewIntlError(err error, id string, args ...interface{}) error {
 	ie := &IntlError{
 		Err: err,
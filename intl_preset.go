@@ -1377,11 +1377,21 @@ var presetIntlMessages = map[string]map[string]string{
 		"zh-Hans": "查询失败",
 		"zh-Hant": "查詢失敗",
 	},
+	"rest_update_conflict": {
+		"en":      "The record has been modified by someone else, please refresh and try again.",
+		"zh-Hans": "记录已被他人修改，请刷新后重试",
+		"zh-Hant": "記錄已被他人修改，請刷新後重試",
+	},
 	"rest_update_failed": {
 		"en":      "Update failed",
 		"zh-Hans": "更新失败",
 		"zh-Hant": "更新失敗",
 	},
+	"rest_upsert_failed": {
+		"en":      "Upsert failed",
+		"zh-Hans": "批量写入失败",
+		"zh-Hant": "批量寫入失敗",
+	},
 	"role_assigns_failed": {
 		"en":      "User roles query failed",
 		"zh-Hans": "用户角色查询失败",
@@ -3,7 +3,9 @@ package kuu
 import (
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"strings"
 )
 
 // IPInfo
@@ -35,3 +37,58 @@ func GetIPInfo(ip string) (*IPInfo, error) {
 	}
 	return &body.Data, err
 }
+
+// GetTrustedProxies 受信任的反向代理，对应配置项trustedProxies（IP或CIDR列表）
+func GetTrustedProxies() (proxies []*net.IPNet) {
+	var items []string
+	C().GetInterface("trustedProxies", &items)
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if !strings.Contains(item, "/") {
+			if ip := net.ParseIP(item); ip != nil && ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+		if _, cidr, err := net.ParseCIDR(item); err == nil {
+			proxies = append(proxies, cidr)
+		}
+	}
+	return
+}
+
+// trustedClientIP 解析客户端IP：仅当直连地址为受信任代理时才读取X-Forwarded-For，
+// 并从右向左跳过受信任代理，取第一个不受信任的地址
+func trustedClientIP(remoteAddr, forwardedFor string, proxies []*net.IPNet) string {
+	remoteIP, _, err := net.SplitHostPort(strings.TrimSpace(remoteAddr))
+	if err != nil {
+		remoteIP = strings.TrimSpace(remoteAddr)
+	}
+	isTrusted := func(s string) bool {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return false
+		}
+		for _, cidr := range proxies {
+			if cidr.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	if forwardedFor == "" || !isTrusted(remoteIP) {
+		return remoteIP
+	}
+	items := strings.Split(forwardedFor, ",")
+	for i := len(items) - 1; i >= 0; i-- {
+		item := strings.TrimSpace(items[i])
+		if net.ParseIP(item) == nil {
+			break
+		}
+		if !isTrusted(item) || i == 0 {
+			return item
+		}
+	}
+	return remoteIP
+}
@@ -1,6 +1,7 @@
 package kuu
 
 import (
+	"net"
 	"testing"
 )
 
@@ -11,3 +12,29 @@ func TestGetIPInfo(t *testing.T) {
 		t.Log(info)
 	}
 }
+
+func TestTrustedClientIP(t *testing.T) {
+	_, proxy, _ := net.ParseCIDR("10.0.0.0/8")
+	proxies := []*net.IPNet{proxy}
+	cases := []struct {
+		remoteAddr   string
+		forwardedFor string
+		proxies      []*net.IPNet
+		expected     string
+	}{
+		// 未配置受信任代理时忽略请求头
+		{"203.0.113.9:5000", "1.2.3.4", nil, "203.0.113.9"},
+		// 直连地址不受信任时忽略请求头
+		{"203.0.113.9:5000", "1.2.3.4", proxies, "203.0.113.9"},
+		// 跳过受信任代理，客户端伪造的左侧地址无效
+		{"10.0.0.1:5000", "1.2.3.4, 198.51.100.7, 10.0.0.2", proxies, "198.51.100.7"},
+		{"10.0.0.1:5000", "10.0.0.3", proxies, "10.0.0.3"},
+		{"10.0.0.1:5000", "", proxies, "10.0.0.1"},
+		{"10.0.0.1:5000", "not-an-ip", proxies, "10.0.0.1"},
+	}
+	for _, item := range cases {
+		if ip := trustedClientIP(item.remoteAddr, item.forwardedFor, item.proxies); ip != item.expected {
+			t.Errorf("trustedClientIP(%q, %q) = %q, expected %q", item.remoteAddr, item.forwardedFor, ip, item.expected)
+		}
+	}
+}
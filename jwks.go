@@ -0,0 +1,457 @@
+package kuu
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/guregu/null.v3"
+)
+
+const (
+	JWTAlgHS256 = "HS256"
+	JWTAlgRS256 = "RS256"
+	JWTAlgES256 = "ES256"
+)
+
+var (
+	ErrSigningKeyNotFound = errors.New("signing key not found")
+	ErrSigningKeyInvalid  = errors.New("signing key invalid")
+)
+
+// JWTConfig 令牌签名配置，对应配置项jwt
+type JWTConfig struct {
+	Alg             string         `json:"alg"`             // 签名算法：HS256（默认）、RS256、ES256
+	Keys            []JWTKeyConfig `json:"keys"`            // 配置文件中的密钥
+	ActiveKid       string         `json:"activeKid"`       // 签名使用的密钥ID，为空时使用最新的密钥
+	CheckRevocation *bool          `json:"checkRevocation"` // 验证非对称令牌时是否检查吊销状态（默认检查）
+	Issuer          string         `json:"issuer"`          // 令牌签发者，默认为应用名称
+}
+
+// JWTKeyConfig 配置文件中的密钥，PrivateKey/PublicKey为PEM字符串
+type JWTKeyConfig struct {
+	Kid        string `json:"kid"`
+	Alg        string `json:"alg"`
+	PrivateKey string `json:"privateKey"`
+	PublicKey  string `json:"publicKey"`
+}
+
+// GetJWTConfig
+func GetJWTConfig() (config JWTConfig) {
+	C().GetInterface("jwt", &config)
+	config.Alg = strings.ToUpper(config.Alg)
+	if config.Alg == "" {
+		config.Alg = JWTAlgHS256
+	}
+	if config.Issuer == "" {
+		config.Issuer = GetAppName()
+	}
+	return
+}
+
+// IsAsymmetric 是否启用非对称签名
+func (c JWTConfig) IsAsymmetric() bool {
+	return c.Alg == JWTAlgRS256 || c.Alg == JWTAlgES256
+}
+
+// RevocationEnabled 是否检查令牌吊销状态
+func (c JWTConfig) RevocationEnabled() bool {
+	return c.CheckRevocation == nil || *c.CheckRevocation
+}
+
+// jwtKey 已解析的签名密钥
+type jwtKey struct {
+	Kid        string
+	Alg        string
+	PrivateKey interface{}
+	PublicKey  interface{}
+	CreatedAt  time.Time
+	ExpiresAt  int64
+	Active     bool
+}
+
+type jwtKeyring struct {
+	mu       sync.RWMutex
+	keys     map[string]*jwtKey
+	loadedAt time.Time
+}
+
+var defaultKeyring = &jwtKeyring{}
+
+// jwtKeyringTTL 密钥列表的本地缓存时间，多实例部署时其他实例在此时间内感知密钥轮换
+var jwtKeyringTTL = time.Minute
+
+func (r *jwtKeyring) load(force bool) (map[string]*jwtKey, error) {
+	r.mu.RLock()
+	if !force && r.keys != nil && time.Since(r.loadedAt) < jwtKeyringTTL {
+		keys := r.keys
+		r.mu.RUnlock()
+		return keys, nil
+	}
+	r.mu.RUnlock()
+
+	keys := make(map[string]*jwtKey)
+	for _, item := range GetJWTConfig().Keys {
+		key, err := parseJWTKey(item.Kid, item.Alg, item.PrivateKey, item.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		key.Active = true
+		keys[key.Kid] = key
+	}
+	var list []SigningKey
+	if err := DB().Order("id asc").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	for _, item := range list {
+		key, err := parseJWTKey(item.Kid, item.Alg, item.PrivateKey, item.PublicKey)
+		if err != nil {
+			ERROR(err)
+			continue
+		}
+		key.CreatedAt = item.CreatedAt
+		key.ExpiresAt = item.ExpiresAt
+		key.Active = item.Active.Bool
+		keys[key.Kid] = key
+	}
+	r.mu.Lock()
+	r.keys = keys
+	r.loadedAt = time.Now()
+	r.mu.Unlock()
+	return keys, nil
+}
+
+func (r *jwtKeyring) reset() {
+	r.mu.Lock()
+	r.keys = nil
+	r.mu.Unlock()
+}
+
+// get 按kid查询密钥，未找到时强制刷新一次
+func (r *jwtKeyring) get(kid string) (*jwtKey, error) {
+	keys, err := r.load(false)
+	if err != nil {
+		return nil, err
+	}
+	key := keys[kid]
+	if key == nil {
+		if keys, err = r.load(true); err != nil {
+			return nil, err
+		}
+		key = keys[kid]
+	}
+	if key == nil || (key.ExpiresAt > 0 && key.ExpiresAt < time.Now().Unix()) {
+		return nil, ErrSigningKeyNotFound
+	}
+	return key, nil
+}
+
+// active 返回当前用于签名的密钥，不存在时自动生成
+func (r *jwtKeyring) active(config JWTConfig) (*jwtKey, error) {
+	keys, err := r.load(false)
+	if err != nil {
+		return nil, err
+	}
+	if config.ActiveKid != "" {
+		if key := keys[config.ActiveKid]; key != nil && key.PrivateKey != nil {
+			return key, nil
+		}
+		return nil, ErrSigningKeyNotFound
+	}
+	var latest *jwtKey
+	for _, key := range keys {
+		if !key.Active || key.PrivateKey == nil || key.Alg != config.Alg {
+			continue
+		}
+		if latest == nil || key.CreatedAt.After(latest.CreatedAt) {
+			latest = key
+		}
+	}
+	if latest != nil {
+		return latest, nil
+	}
+	if _, err := RotateSigningKey(config.Alg, 0); err != nil {
+		return nil, err
+	}
+	return r.active(config)
+}
+
+func parseJWTKey(kid, alg, privatePEM, publicPEM string) (*jwtKey, error) {
+	key := &jwtKey{Kid: kid, Alg: strings.ToUpper(alg)}
+	if key.Kid == "" {
+		return nil, fmt.Errorf("%w: kid is required", ErrSigningKeyInvalid)
+	}
+	var err error
+	switch key.Alg {
+	case JWTAlgRS256:
+		if privatePEM != "" {
+			var prv *rsa.PrivateKey
+			if prv, err = jwt.ParseRSAPrivateKeyFromPEM([]byte(privatePEM)); err != nil {
+				return nil, err
+			}
+			key.PrivateKey = prv
+			key.PublicKey = &prv.PublicKey
+		} else if key.PublicKey, err = jwt.ParseRSAPublicKeyFromPEM([]byte(publicPEM)); err != nil {
+			return nil, err
+		}
+	case JWTAlgES256:
+		if privatePEM != "" {
+			var prv *ecdsa.PrivateKey
+			if prv, err = jwt.ParseECPrivateKeyFromPEM([]byte(privatePEM)); err != nil {
+				return nil, err
+			}
+			key.PrivateKey = prv
+			key.PublicKey = &prv.PublicKey
+		} else if key.PublicKey, err = jwt.ParseECPublicKeyFromPEM([]byte(publicPEM)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported alg %s", ErrSigningKeyInvalid, alg)
+	}
+	return key, nil
+}
+
+// GenECDSAKey 生成P-256椭圆曲线密钥
+func GenECDSAKey() (prvKey, pubKey []byte) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	der, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		panic(err)
+	}
+	prvKey = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	derPkix, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		panic(err)
+	}
+	pubKey = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: derPkix})
+	return
+}
+
+// RotateSigningKey 生成新的签名密钥并停用旧密钥，旧密钥在graceSeconds后从JWKS中移除（0表示使用访问令牌有效期）
+func RotateSigningKey(alg string, graceSeconds int64) (*SigningKey, error) {
+	alg = strings.ToUpper(alg)
+	var prv, pub []byte
+	switch alg {
+	case JWTAlgRS256:
+		prv, pub = GenRSAKey(2048)
+	case JWTAlgES256:
+		prv, pub = GenECDSAKey()
+	default:
+		return nil, fmt.Errorf("%w: unsupported alg %s", ErrSigningKeyInvalid, alg)
+	}
+	if graceSeconds <= 0 {
+		graceSeconds = int64(AccessTokenExpiresSeconds())
+	}
+	key := SigningKey{
+		Kid:        strings.ReplaceAll(uuid.NewV4().String(), "-", "")[:16],
+		Alg:        alg,
+		PrivateKey: string(prv),
+		PublicKey:  string(pub),
+		Active:     null.BoolFrom(true),
+	}
+	err := WithTransaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&SigningKey{}).Where("active = ?", true).Updates(map[string]interface{}{
+			"active":     false,
+			"expires_at": time.Now().Unix() + graceSeconds,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&key).Error
+	})
+	defaultKeyring.reset()
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// signAsymmetricToken 使用当前密钥签发令牌
+func signAsymmetricToken(config JWTConfig, claims jwt.MapClaims) (string, error) {
+	key, err := defaultKeyring.active(config)
+	if err != nil {
+		return "", err
+	}
+	var method jwt.SigningMethod
+	switch key.Alg {
+	case JWTAlgRS256:
+		method = jwt.SigningMethodRS256
+	case JWTAlgES256:
+		method = jwt.SigningMethodES256
+	default:
+		return "", ErrSigningKeyInvalid
+	}
+	claims["iss"] = config.Issuer
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = key.Kid
+	return token.SignedString(key.PrivateKey)
+}
+
+// IsAsymmetricToken 判断令牌是否使用非对称算法签名
+func IsAsymmetricToken(tokenString string) bool {
+	token, _, err := new(jwt.Parser).ParseUnverified(tokenString, jwt.MapClaims{})
+	if err != nil {
+		return false
+	}
+	alg := token.Method.Alg()
+	return alg == JWTAlgRS256 || alg == JWTAlgES256
+}
+
+// DecodedAsymmetricToken 使用JWKS中的公钥验证令牌
+func DecodedAsymmetricToken(tokenString string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, asymmetricKeyFunc)
+	if err != nil {
+		return nil, err
+	}
+	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
+		return claims, nil
+	}
+	return nil, ErrInvalidToken
+}
+
+func asymmetricKeyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, err := defaultKeyring.get(kid)
+	if err != nil {
+		return nil, err
+	}
+	if token.Method.Alg() != key.Alg {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.PublicKey, nil
+}
+
+// secretFromAsymmetricToken 不检查吊销状态时，直接从令牌内容构造密钥信息
+func secretFromAsymmetricToken(tokenString string) (*SignSecret, error) {
+	claims, err := DecodedAsymmetricToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	secret := &SignSecret{
+		Token:  tokenString,
+		Method: SignMethodLogin,
+	}
+	if v, ok := claims["UID"].(float64); ok {
+		secret.UID = uint(v)
+	}
+	if v, ok := claims["SubDocID"].(float64); ok {
+		secret.SubDocID = uint(v)
+	}
+	if v, ok := claims["exp"].(float64); ok {
+		secret.Exp = int64(v)
+	}
+	if v, ok := claims["iat"].(float64); ok {
+		secret.Iat = int64(v)
+	}
+	secret.Username, _ = claims["Username"].(string)
+	secret.Type, _ = claims["Type"].(string)
+	return secret, nil
+}
+
+// JWK
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// GetJWKS 返回当前有效的公钥集合
+func GetJWKS() ([]JWK, error) {
+	keys, err := defaultKeyring.load(false)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	list := make([]JWK, 0, len(keys))
+	for _, key := range keys {
+		if key.ExpiresAt > 0 && key.ExpiresAt < now {
+			continue
+		}
+		jwk := JWK{Kid: key.Kid, Use: "sig", Alg: key.Alg}
+		switch pub := key.PublicKey.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (pub.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = pub.Curve.Params().Name
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size)))
+			jwk.Y = base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size)))
+		default:
+			continue
+		}
+		list = append(list, jwk)
+	}
+	return list, nil
+}
+
+// JWKSRoute
+var JWKSRoute = RouteInfo{
+	Name:         "查询令牌验证公钥（JWKS）",
+	Method:       http.MethodGet,
+	Path:         "/.well-known/jwks.json",
+	IgnorePrefix: true,
+	HandlerFunc: func(c *Context) *STDReply {
+		keys, err := GetJWKS()
+		if err != nil {
+			c.ERROR(err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return nil
+		}
+		c.JSON(http.StatusOK, D{"keys": keys})
+		return nil
+	},
+}
+
+// JWKSRotateRoute
+var JWKSRotateRoute = RouteInfo{
+	Name:   "轮换令牌签名密钥（该接口仅限root调用）",
+	Method: http.MethodPost,
+	Path:   "/jwks/rotate",
+	IntlMessages: map[string]string{
+		"jwks_rotate_failed":    "Failed to rotate signing key",
+		"login_as_unauthorized": "Unauthorized operation",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		if c.SignInfo.UID != RootUID() {
+			return c.STDErr(fmt.Errorf("unauthorized operation: uid=%v", c.SignInfo.UID), "login_as_unauthorized")
+		}
+		var body struct {
+			Alg          string
+			GraceSeconds int64
+		}
+		_ = c.ShouldBindJSON(&body)
+		if body.Alg == "" {
+			body.Alg = GetJWTConfig().Alg
+		}
+		key, err := RotateSigningKey(body.Alg, body.GraceSeconds)
+		if err != nil {
+			return c.STDErr(err, "jwks_rotate_failed")
+		}
+		return c.STD(D{"Kid": key.Kid, "Alg": key.Alg})
+	},
+}
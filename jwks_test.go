@@ -0,0 +1,37 @@
+package kuu
+
+import (
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestAsymmetricToken(t *testing.T) {
+	for _, alg := range []string{JWTAlgRS256, JWTAlgES256} {
+		var prv []byte
+		if alg == JWTAlgRS256 {
+			prv, _ = GenRSAKey(2048)
+		} else {
+			prv, _ = GenECDSAKey()
+		}
+		key, err := parseJWTKey("test", alg, string(prv), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		token := jwt.NewWithClaims(jwt.GetSigningMethod(alg), jwt.MapClaims{"UID": 1})
+		token.Header["kid"] = key.Kid
+		signed, err := token.SignedString(key.PrivateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsAsymmetricToken(signed) {
+			t.Errorf("%s token should be asymmetric", alg)
+		}
+	}
+	if hs, _ := EncodedToken(jwt.MapClaims{"UID": 1}, "secret"); IsAsymmetricToken(hs) {
+		t.Error("HS256 token should not be asymmetric")
+	}
+	if _, err := parseJWTKey("test", JWTAlgHS256, "", ""); err == nil {
+		t.Error("HS256 key should be rejected")
+	}
+}
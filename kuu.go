@@ -31,6 +31,10 @@ var (
 	// GLSRequestContextKey
 	GLSRequestContextKey = "RequestContext"
 	GLSRequestIDKey      = "Request ID"
+	// GLSDataSourceKey
+	GLSDataSourceKey = "DataSource"
+	// GLSTenantKey
+	GLSTenantKey = "Tenant"
 	// Uptime
 	Uptime time.Time
 	// IsProduction
@@ -109,6 +113,11 @@ func beforeRun() {
 			}
 		}
 	}
+	if C().GetBool("gorm:migrate") {
+		if _, err := LoadSeeds(SeedOptions{}); err != nil {
+			panic(err)
+		}
+	}
 	DefaultCron.Start()
 	RunAllRunAfterJobs()
 }
@@ -411,6 +420,10 @@ func IgnoreAuth(cancel ...bool) (success bool) {
 }
 
 func (app *Engine) initMiddleware() {
+	// 租户识别需先于其他中间件，以便后续处理均使用租户数据源
+	if GetTenantConfig().Enabled {
+		app.Use(TenantMiddleware)
+	}
 	if len(ginHandlerLiist) != 0 {
 		app.UseGin(ginHandlerLiist...)
 	}
@@ -490,6 +503,7 @@ func connectedPrint(name, args string) {
 
 func (app *Engine) init() {
 	initDataSources()
+	initTenants()
 	app.initMiddleware()
 	app.initStatics()
 	// Register default callbacks
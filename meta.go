@@ -29,6 +29,7 @@ type Metadata struct {
 	UIDNames      []string          `json:"-" gorm:"-"`
 	OrgIDNames    []string          `json:"-" gorm:"-"`
 	TagSettings   map[string]string `json:"-" gorm:"-"`
+	DataSource    string            `gorm:"-"` // 绑定的数据源名称，为空时使用DB()
 }
 
 // MetadataField
@@ -130,6 +131,9 @@ func parseMetadata(value interface{}) (m *Metadata) {
 				m.LocaleKey = v
 			}
 		}
+		if ds := fieldStruct.Tag.Get("ds"); m.DataSource == "" && ds != "" {
+			m.DataSource = ds
+		}
 		indirectType := fieldStruct.Type
 		for indirectType.Kind() == reflect.Ptr {
 			indirectType = indirectType.Elem()
@@ -188,7 +192,12 @@ func parseMetadata(value interface{}) (m *Metadata) {
 		}
 		tagSettings := parseTagSetting(fieldStruct.Tag, "kuu")
 		if len(tagSettings) > 0 {
-			m.TagSettings = tagSettings
+			if m.TagSettings == nil {
+				m.TagSettings = make(map[string]string)
+			}
+			for k, v := range tagSettings {
+				m.TagSettings[k] = v
+			}
 			if _, exists := tagSettings["PASSWORD"]; exists {
 				field.IsPassword = true
 			}
@@ -0,0 +1,466 @@
+package kuu
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// migrationLockName 迁移咨询锁名称
+const migrationLockName = "kuu_schema_migrations"
+
+// migrationLockKey PostgreSQL咨询锁键值
+const migrationLockKey = 7283126512
+
+var (
+	ErrMigrationInvalid      = errors.New("invalid migration")
+	ErrMigrationIrreversible = errors.New("irreversible migration")
+	ErrMigrationLocked       = errors.New("failed to acquire migration lock")
+	ErrMigrationModRequired  = errors.New("mod is required")
+)
+
+var migrationMu sync.Mutex
+
+// Migration 版本化迁移，Up/Down与UpSQL/DownSQL二选一
+type Migration struct {
+	Version string               // 版本号，按字符串顺序执行，建议使用时间戳如20200101120000
+	Name    string               // 迁移说明
+	Up      func(*gorm.DB) error // 升级函数，在事务中执行
+	Down    func(*gorm.DB) error // 回滚函数，在事务中执行
+	UpSQL   []string             // 升级语句，每项为一条语句
+	DownSQL []string             // 回滚语句，每项为一条语句
+}
+
+func (m *Migration) validate() error {
+	if strings.TrimSpace(m.Version) == "" {
+		return fmt.Errorf("%w: version is required", ErrMigrationInvalid)
+	}
+	if m.Up == nil && len(m.UpSQL) == 0 {
+		return fmt.Errorf("%w: %s has no up script", ErrMigrationInvalid, m.Version)
+	}
+	return nil
+}
+
+func (m *Migration) reversible() bool {
+	return m.Down != nil || len(m.DownSQL) > 0
+}
+
+func runMigrationScript(tx *gorm.DB, fn func(*gorm.DB) error, stmts []string) error {
+	if fn != nil {
+		return fn(tx)
+	}
+	for _, stmt := range stmts {
+		if err := tx.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SchemaMigration 已执行的迁移记录
+type SchemaMigration struct {
+	ID        uint   `gorm:"primary_key"`
+	ModCode   string `gorm:"not null;unique_index:kuu_schema_migration"`
+	Version   string `gorm:"not null;unique_index:kuu_schema_migration"`
+	Name      string
+	AppliedAt time.Time
+}
+
+// TableName
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// MigrationStatus 迁移状态
+type MigrationStatus struct {
+	ModCode   string
+	Version   string
+	Name      string
+	Applied   bool
+	AppliedAt *time.Time
+	Missing   bool     `json:",omitempty"` // 已执行但代码中已不存在
+	SQL       []string `json:",omitempty"` // 试运行时待执行的语句，Go迁移为空
+}
+
+// MigrateOptions 迁移参数
+type MigrateOptions struct {
+	Mods   []string // 模块编码，为空时为全部已导入模块（回滚时必填）
+	Steps  int      // 回滚步数，默认为1
+	DryRun bool     // 只返回待执行的迁移，不实际执行
+}
+
+// modDB 返回模块绑定的数据源
+func modDB(mod *Mod) *gorm.DB {
+	if mod.DataSource != "" {
+		return DS(mod.DataSource)
+	}
+	return DB()
+}
+
+// sortedMigrations 按版本号排序并校验模块迁移
+func sortedMigrations(mod *Mod) ([]Migration, error) {
+	list := make([]Migration, len(mod.Migrations))
+	copy(list, mod.Migrations)
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Version < list[j].Version
+	})
+	for i := range list {
+		if err := list[i].validate(); err != nil {
+			return nil, fmt.Errorf("mod %s: %w", mod.Code, err)
+		}
+		if i > 0 && list[i].Version == list[i-1].Version {
+			return nil, fmt.Errorf("%w: duplicate version %s in mod %s", ErrMigrationInvalid, list[i].Version, mod.Code)
+		}
+	}
+	return list, nil
+}
+
+// selectMigrationMods 查询参数指定的模块
+func selectMigrationMods(codes []string) ([]*Mod, error) {
+	if len(codes) == 0 {
+		return modList, nil
+	}
+	var mods []*Mod
+	for _, code := range codes {
+		mod, ok := modMap[code]
+		if !ok {
+			return nil, fmt.Errorf("mod not found: %s", code)
+		}
+		mods = append(mods, mod)
+	}
+	return mods, nil
+}
+
+func appliedMigrations(db *gorm.DB, modCode string) (map[string]SchemaMigration, error) {
+	var list []SchemaMigration
+	if err := db.Where(&SchemaMigration{ModCode: modCode}).Order("id").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[string]SchemaMigration, len(list))
+	for _, item := range list {
+		applied[item.Version] = item
+	}
+	return applied, nil
+}
+
+// withMigrationLock 在咨询锁内执行fn，避免多个实例同时迁移（SQLite等不支持时只使用进程内锁）
+func withMigrationLock(db *gorm.DB, fn func() error) error {
+	migrationMu.Lock()
+	defer migrationMu.Unlock()
+
+	dialect := db.Dialect().GetName()
+	if dialect != "mysql" && dialect != "postgres" {
+		return fn()
+	}
+	// 咨询锁与连接绑定，需使用独立连接持有
+	ctx := context.Background()
+	conn, err := db.DB().Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	timeout := C().DefaultGetInt("migrationLockTimeout", 60)
+	switch dialect {
+	case "mysql":
+		var ok sql.NullInt64
+		if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", migrationLockName, timeout).Scan(&ok); err != nil {
+			return err
+		}
+		if !ok.Valid || ok.Int64 != 1 {
+			return ErrMigrationLocked
+		}
+		defer func() {
+			if _, err := conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", migrationLockName); err != nil {
+				ERROR("failed to release migration lock: %s", err.Error())
+			}
+		}()
+	case "postgres":
+		lockCtx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		defer cancel()
+		if _, err := conn.ExecContext(lockCtx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+			return fmt.Errorf("%w: %s", ErrMigrationLocked, err.Error())
+		}
+		defer func() {
+			if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockKey); err != nil {
+				ERROR("failed to release migration lock: %s", err.Error())
+			}
+		}()
+	}
+	return fn()
+}
+
+// MigrationStatuses 查询模块迁移状态
+func MigrationStatuses(modCodes ...string) ([]MigrationStatus, error) {
+	mods, err := selectMigrationMods(modCodes)
+	if err != nil {
+		return nil, err
+	}
+	var list []MigrationStatus
+	for _, mod := range mods {
+		migrations, err := sortedMigrations(mod)
+		if err != nil {
+			return nil, err
+		}
+		db := modDB(mod)
+		if err := db.AutoMigrate(&SchemaMigration{}).Error; err != nil {
+			return nil, err
+		}
+		applied, err := appliedMigrations(db, mod.Code)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, mergeMigrationStatuses(mod.Code, migrations, applied)...)
+	}
+	return list, nil
+}
+
+// mergeMigrationStatuses 合并已注册和已执行的迁移
+func mergeMigrationStatuses(modCode string, migrations []Migration, applied map[string]SchemaMigration) []MigrationStatus {
+	var (
+		list       []MigrationStatus
+		registered = make(map[string]bool, len(migrations))
+	)
+	for _, m := range migrations {
+		registered[m.Version] = true
+		status := MigrationStatus{ModCode: modCode, Version: m.Version, Name: m.Name}
+		if record, ok := applied[m.Version]; ok {
+			appliedAt := record.AppliedAt
+			status.Applied = true
+			status.AppliedAt = &appliedAt
+		}
+		list = append(list, status)
+	}
+	for version, record := range applied {
+		if registered[version] {
+			continue
+		}
+		appliedAt := record.AppliedAt
+		list = append(list, MigrationStatus{
+			ModCode:   modCode,
+			Version:   version,
+			Name:      record.Name,
+			Applied:   true,
+			AppliedAt: &appliedAt,
+			Missing:   true,
+		})
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Version < list[j].Version
+	})
+	return list
+}
+
+// MigrateUp 按版本顺序执行未执行的迁移，返回已执行（试运行时为待执行）的迁移
+func MigrateUp(opts MigrateOptions) ([]MigrationStatus, error) {
+	mods, err := selectMigrationMods(opts.Mods)
+	if err != nil {
+		return nil, err
+	}
+	var done []MigrationStatus
+	for _, mod := range mods {
+		migrations, err := sortedMigrations(mod)
+		if err != nil {
+			return done, err
+		}
+		if len(migrations) == 0 {
+			continue
+		}
+		db := modDB(mod)
+		err = withMigrationLock(db, func() error {
+			if err := db.AutoMigrate(&SchemaMigration{}).Error; err != nil {
+				return err
+			}
+			applied, err := appliedMigrations(db, mod.Code)
+			if err != nil {
+				return err
+			}
+			for _, m := range migrations {
+				if _, ok := applied[m.Version]; ok {
+					continue
+				}
+				status := MigrationStatus{ModCode: mod.Code, Version: m.Version, Name: m.Name}
+				if opts.DryRun {
+					status.SQL = m.UpSQL
+					done = append(done, status)
+					continue
+				}
+				err := withDBTransaction(db, func(tx *gorm.DB) error {
+					if err := runMigrationScript(tx, m.Up, m.UpSQL); err != nil {
+						return err
+					}
+					return tx.Create(&SchemaMigration{
+						ModCode:   mod.Code,
+						Version:   m.Version,
+						Name:      m.Name,
+						AppliedAt: time.Now(),
+					}).Error
+				})
+				if err != nil {
+					return fmt.Errorf("failed to migrate %s@%s: %w", mod.Code, m.Version, err)
+				}
+				INFO("migrated: %s@%s %s", mod.Code, m.Version, m.Name)
+				status.Applied = true
+				done = append(done, status)
+			}
+			return nil
+		})
+		if err != nil {
+			return done, err
+		}
+	}
+	return done, nil
+}
+
+// MigrateDown 按执行顺序倒序回滚指定模块的迁移，返回已回滚（试运行时为待回滚）的迁移
+func MigrateDown(opts MigrateOptions) ([]MigrationStatus, error) {
+	if len(opts.Mods) == 0 {
+		return nil, ErrMigrationModRequired
+	}
+	if opts.Steps <= 0 {
+		opts.Steps = 1
+	}
+	mods, err := selectMigrationMods(opts.Mods)
+	if err != nil {
+		return nil, err
+	}
+	var done []MigrationStatus
+	for _, mod := range mods {
+		migrations, err := sortedMigrations(mod)
+		if err != nil {
+			return done, err
+		}
+		migrationMap := make(map[string]Migration, len(migrations))
+		for _, m := range migrations {
+			migrationMap[m.Version] = m
+		}
+		db := modDB(mod)
+		err = withMigrationLock(db, func() error {
+			if err := db.AutoMigrate(&SchemaMigration{}).Error; err != nil {
+				return err
+			}
+			var records []SchemaMigration
+			if err := db.Where(&SchemaMigration{ModCode: mod.Code}).Order("id desc").Limit(opts.Steps).Find(&records).Error; err != nil {
+				return err
+			}
+			for _, record := range records {
+				m, ok := migrationMap[record.Version]
+				if !ok || !m.reversible() {
+					return fmt.Errorf("%w: %s@%s", ErrMigrationIrreversible, mod.Code, record.Version)
+				}
+				status := MigrationStatus{ModCode: mod.Code, Version: m.Version, Name: m.Name}
+				if opts.DryRun {
+					status.SQL = m.DownSQL
+					done = append(done, status)
+					continue
+				}
+				err := withDBTransaction(db, func(tx *gorm.DB) error {
+					if err := runMigrationScript(tx, m.Down, m.DownSQL); err != nil {
+						return err
+					}
+					return tx.Delete(&SchemaMigration{}, record.ID).Error
+				})
+				if err != nil {
+					return fmt.Errorf("failed to roll back %s@%s: %w", mod.Code, m.Version, err)
+				}
+				INFO("rolled back: %s@%s %s", mod.Code, m.Version, m.Name)
+				done = append(done, status)
+			}
+			return nil
+		})
+		if err != nil {
+			return done, err
+		}
+	}
+	return done, nil
+}
+
+func checkMigrationRoot(c *Context) *STDReply {
+	if c.SignInfo.UID != RootUID() {
+		return c.STDErr(fmt.Errorf("unauthorized operation: uid=%v", c.SignInfo.UID), "migration_unauthorized")
+	}
+	return nil
+}
+
+// MigrationsRoute
+var MigrationsRoute = RouteInfo{
+	Name:   "查询迁移状态",
+	Method: http.MethodGet,
+	Path:   "/migrations",
+	IntlMessages: map[string]string{
+		"migration_status_failed": "Failed to query migrations",
+		"migration_unauthorized":  "Unauthorized operation",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		if reply := checkMigrationRoot(c); reply != nil {
+			return reply
+		}
+		var mods []string
+		if v := c.Query("mod"); v != "" {
+			mods = strings.Split(v, ",")
+		}
+		list, err := MigrationStatuses(mods...)
+		if err != nil {
+			return c.STDErr(err, "migration_status_failed")
+		}
+		return c.STD(list)
+	},
+}
+
+// MigrateUpRoute
+var MigrateUpRoute = RouteInfo{
+	Name:   "执行迁移",
+	Method: http.MethodPost,
+	Path:   "/migrations/up",
+	IntlMessages: map[string]string{
+		"migration_up_failed":    "Failed to run migrations",
+		"migration_unauthorized": "Unauthorized operation",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		if reply := checkMigrationRoot(c); reply != nil {
+			return reply
+		}
+		var opts MigrateOptions
+		if err := c.ShouldBindJSON(&opts); err != nil {
+			return c.STDErr(err, "migration_up_failed")
+		}
+		list, err := MigrateUp(opts)
+		if err != nil {
+			return c.STDErr(err, "migration_up_failed")
+		}
+		return c.STD(list)
+	},
+}
+
+// MigrateDownRoute
+var MigrateDownRoute = RouteInfo{
+	Name:   "回滚迁移",
+	Method: http.MethodPost,
+	Path:   "/migrations/down",
+	IntlMessages: map[string]string{
+		"migration_down_failed":  "Failed to roll back migrations",
+		"migration_unauthorized": "Unauthorized operation",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		if reply := checkMigrationRoot(c); reply != nil {
+			return reply
+		}
+		var opts MigrateOptions
+		if err := c.ShouldBindJSON(&opts); err != nil {
+			return c.STDErr(err, "migration_down_failed")
+		}
+		list, err := MigrateDown(opts)
+		if err != nil {
+			return c.STDErr(err, "migration_down_failed")
+		}
+		return c.STD(list)
+	},
+}
@@ -0,0 +1,159 @@
+package kuu
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+func TestSortedMigrations(t *testing.T) {
+	mod := &Mod{Code: "report", Migrations: []Migration{
+		{Version: "20200102", UpSQL: []string{"ALTER TABLE a DROP COLUMN b"}},
+		{Version: "20200101", UpSQL: []string{"CREATE TABLE a (b INT)"}},
+	}}
+	list, err := sortedMigrations(mod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list[0].Version != "20200101" || list[1].Version != "20200102" {
+		t.Errorf("unexpected order: %v, %v", list[0].Version, list[1].Version)
+	}
+	if mod.Migrations[0].Version != "20200102" {
+		t.Error("registered migrations should not be reordered")
+	}
+
+	mod.Migrations = append(mod.Migrations, Migration{Version: "20200101", UpSQL: []string{"SELECT 1"}})
+	if _, err := sortedMigrations(mod); !errors.Is(err, ErrMigrationInvalid) {
+		t.Errorf("expected duplicate version error, got %v", err)
+	}
+	mod.Migrations = []Migration{{Version: "20200101"}}
+	if _, err := sortedMigrations(mod); !errors.Is(err, ErrMigrationInvalid) {
+		t.Errorf("expected missing up script error, got %v", err)
+	}
+}
+
+func TestMergeMigrationStatuses(t *testing.T) {
+	migrations := []Migration{
+		{Version: "1", Name: "one"},
+		{Version: "3", Name: "three"},
+	}
+	applied := map[string]SchemaMigration{
+		"1": {Version: "1", Name: "one", AppliedAt: time.Now()},
+		"2": {Version: "2", Name: "two", AppliedAt: time.Now()},
+	}
+	list := mergeMigrationStatuses("report", migrations, applied)
+	if len(list) != 3 {
+		t.Fatalf("expected 3 statuses, got %d", len(list))
+	}
+	if !list[0].Applied || list[0].Missing {
+		t.Errorf("unexpected status: %+v", list[0])
+	}
+	if !list[1].Applied || !list[1].Missing || list[1].Version != "2" {
+		t.Errorf("unexpected status: %+v", list[1])
+	}
+	if list[2].Applied || list[2].AppliedAt != nil {
+		t.Errorf("unexpected status: %+v", list[2])
+	}
+}
+
+func TestMigrateUpDown(t *testing.T) {
+	db := testSQLiteDB(t, "migration_test")
+	mod := &Mod{Code: "migration_test", DataSource: "migration_test", Migrations: []Migration{
+		{
+			Version: "20200101",
+			Name:    "create items",
+			UpSQL:   []string{"CREATE TABLE migration_items (id INTEGER PRIMARY KEY, title TEXT)"},
+			DownSQL: []string{"DROP TABLE migration_items"},
+		},
+		{
+			Version: "20200102",
+			Name:    "seed items",
+			Up: func(tx *gorm.DB) error {
+				return tx.Exec("INSERT INTO migration_items (title) VALUES (?)", "hello").Error
+			},
+			Down: func(tx *gorm.DB) error {
+				return tx.Exec("DELETE FROM migration_items").Error
+			},
+		},
+		{
+			Version: "20200103",
+			Name:    "index items",
+			UpSQL:   []string{"CREATE INDEX migration_items_title ON migration_items (title)"},
+		},
+	}}
+	modMap[mod.Code] = mod
+	defer delete(modMap, mod.Code)
+	opts := MigrateOptions{Mods: []string{mod.Code}}
+	countRecords := func() (count int) {
+		db.Model(&SchemaMigration{}).Where(&SchemaMigration{ModCode: mod.Code}).Count(&count)
+		return
+	}
+
+	// 试运行不执行迁移也不写入迁移记录
+	list, err := MigrateUp(MigrateOptions{Mods: opts.Mods, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 || list[0].SQL[0] != mod.Migrations[0].UpSQL[0] || list[1].SQL != nil || list[0].Applied {
+		t.Errorf("unexpected dry run: %+v", list)
+	}
+	if db.HasTable("migration_items") || countRecords() != 0 {
+		t.Error("dry run should not write anything")
+	}
+
+	if list, err = MigrateUp(opts); err != nil || len(list) != 3 {
+		t.Fatalf("unexpected migrate result: %+v, %v", list, err)
+	}
+	var count int
+	db.Table("migration_items").Count(&count)
+	if count != 1 || countRecords() != 3 {
+		t.Errorf("unexpected migrated state: items=%d, records=%d", count, countRecords())
+	}
+	statuses, err := MigrationStatuses(mod.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if !status.Applied || status.AppliedAt == nil || status.Missing {
+			t.Errorf("unexpected status: %+v", status)
+		}
+	}
+	if list, err = MigrateUp(opts); err != nil || len(list) != 0 {
+		t.Errorf("applied migrations should be skipped: %+v, %v", list, err)
+	}
+
+	// 最近一次迁移不可回滚时不回滚任何迁移
+	opts.Steps = 2
+	if _, err := MigrateDown(opts); !errors.Is(err, ErrMigrationIrreversible) {
+		t.Errorf("expected irreversible error, got %v", err)
+	}
+	if countRecords() != 3 {
+		t.Error("irreversible migration should block the rollback")
+	}
+
+	mod.Migrations[2].DownSQL = []string{"DROP INDEX migration_items_title"}
+	if list, err = MigrateDown(MigrateOptions{Mods: opts.Mods, Steps: 2, DryRun: true}); err != nil || len(list) != 2 {
+		t.Fatalf("unexpected dry run: %+v, %v", list, err)
+	}
+	if list[0].Version != "20200103" || list[1].Version != "20200102" || countRecords() != 3 {
+		t.Errorf("unexpected dry run: %+v", list)
+	}
+	if list, err = MigrateDown(opts); err != nil || len(list) != 2 {
+		t.Fatalf("unexpected rollback result: %+v, %v", list, err)
+	}
+	db.Table("migration_items").Count(&count)
+	if count != 0 || countRecords() != 1 {
+		t.Errorf("unexpected rolled back state: items=%d, records=%d", count, countRecords())
+	}
+	if statuses, err = MigrationStatuses(mod.Code); err != nil {
+		t.Fatal(err)
+	}
+	if !statuses[0].Applied || statuses[1].Applied || statuses[2].Applied {
+		t.Errorf("unexpected statuses: %+v", statuses)
+	}
+	if _, err := MigrateDown(MigrateOptions{}); !errors.Is(err, ErrMigrationModRequired) {
+		t.Errorf("expected mod required error, got %v", err)
+	}
+}
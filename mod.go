@@ -15,6 +15,7 @@ var (
 	tableNames       = make(map[string]string)
 	tableNameMetaMap = make(map[string]*Metadata)
 	modMap           = make(map[string]*Mod)
+	modList          []*Mod
 )
 
 var (
@@ -59,6 +60,20 @@ type Mod struct {
 	IgnoreCrudRoutes bool
 	IgnoreModRoutes  bool
 	IgnoreDataDict   bool
+	DataSource       string      // 模块绑定的数据源，模型可通过ds标签单独指定
+	Migrations       []Migration // 版本化迁移，开启gorm:migrate时在自动迁移后执行
+	Seeds            []Seed      // 种子数据，开启gorm:migrate时在模块初始化后导入
+}
+
+// resolveModelDataSource 确定模型绑定的数据源（ds标签优先，其次为Mod.DataSource），数据源未注册时返回错误
+func resolveModelDataSource(meta *Metadata, mod *Mod) error {
+	if meta.DataSource == "" {
+		meta.DataSource = mod.DataSource
+	}
+	if meta.DataSource != "" && !HasDataSource(meta.DataSource) {
+		return fmt.Errorf("model %s of mod %s is bound to an unknown data source \"%s\", check the ds tag or Mod.DataSource", meta.Name, mod.Code, meta.DataSource)
+	}
+	return nil
 }
 
 // Import
@@ -129,6 +144,9 @@ func (app *Engine) Import(mods ...*Mod) {
 					meta.RestDesc = desc
 				}
 				meta.ModCode = mod.Code
+				if err := resolveModelDataSource(meta, mod); err != nil {
+					PANIC(err)
+				}
 				gormTableName := gorm.ToTableName(meta.Name)
 				pluralTableName := inflection.Plural(gormTableName)
 
@@ -136,7 +154,7 @@ func (app *Engine) Import(mods ...*Mod) {
 					tableName := v.TableName()
 					tableNames[tableName] = tableName
 				} else if v, ok := model.(dbTabler); ok {
-					tableName := v.TableName(DB())
+					tableName := v.TableName(ModelDB(model))
 					tableNames[tableName] = tableName
 				} else if C().GetBool("legacy_table_name") {
 					tableName := fmt.Sprintf("%s_%s", mod.Code, meta.Name)
@@ -159,7 +177,7 @@ func (app *Engine) Import(mods ...*Mod) {
 					tableNames[kuuTableName] = kuuTableName
 				}
 
-				metaTableName := DB().NewScope(model).TableName()
+				metaTableName := ModelDB(model).NewScope(model).TableName()
 				if metaTableName != "" {
 					tableNameMetaMap[metaTableName] = meta
 					if !mod.IgnoreDataDict {
@@ -172,7 +190,8 @@ func (app *Engine) Import(mods ...*Mod) {
 				}
 			}
 			if migrate {
-				DB().AutoMigrate(model)
+				ModelDB(model).AutoMigrate(model)
+				repairTsPrecision(ModelDB(model), model)
 
 				if v, ok := model.(DBTypeRepairer); ok {
 					v.RepairDBTypes()
@@ -186,6 +205,16 @@ func (app *Engine) Import(mods ...*Mod) {
 			}
 		}
 		modMap[mod.Code] = mod
+		modList = append(modList, mod)
+	}
+	if migrate {
+		codes := make([]string, 0, len(mods))
+		for _, mod := range mods {
+			codes = append(codes, mod.Code)
+		}
+		if _, err := MigrateUp(MigrateOptions{Mods: codes}); err != nil {
+			PANIC("failed to run migrations: %s", err.Error())
+		}
 	}
 }
 
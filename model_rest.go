@@ -2,6 +2,7 @@ package kuu
 
 import (
 	"fmt"
+	"net/http"
 	"reflect"
 	"strings"
 
@@ -18,6 +19,11 @@ type RestDesc struct {
 	Query  bool
 	Update bool
 	Import bool
+	Upsert bool
+	// Path 路由路径
+	Path string
+	// Methods 各操作对应的请求方法，键为create、delete、query、update
+	Methods map[string]string
 }
 
 type buildSelectField interface {
@@ -96,6 +102,13 @@ func RESTful(r *Engine, routePrefix string, value interface{}) (desc *RestDesc)
 					fmt.Sprintf(" - query  %s: %-8s %s", structName, queryMethod, routePath),
 				)
 			} else {
+				desc.Path = routePath
+				desc.Methods = map[string]string{
+					"create": createMethod,
+					"delete": deleteMethod,
+					"query":  queryMethod,
+					"update": updateMethod,
+				}
 				if createMethod != "-" {
 					desc.Create = true
 					r.Handle(createMethod, routePath, restCreateHandler(reflectType))
@@ -112,6 +125,10 @@ func RESTful(r *Engine, routePrefix string, value interface{}) (desc *RestDesc)
 					desc.Update = true
 					r.Handle(updateMethod, routePath, restUpdateHandler(reflectType))
 				}
+				if desc.Create && desc.Update {
+					desc.Upsert = true
+					r.Handle("POST", routePath+"/upsert", restUpsertHandler(reflectType))
+				}
 			}
 			break
 		}
@@ -131,7 +148,7 @@ type CondDesc struct {
 func ParseCond(cond interface{}, model interface{}, with ...*gorm.DB) (desc *CondDesc, db *gorm.DB) {
 	var (
 		data  map[string]interface{}
-		scope = DB().NewScope(model)
+		scope = ModelDB(model).NewScope(model)
 	)
 	switch cond.(type) {
 	case string:
@@ -259,7 +276,7 @@ func parseObject(filter map[string]interface{}, model interface{}) (sqls []strin
 			}
 		} else {
 			var (
-				scope           = DB().NewScope(model)
+				scope           = ModelDB(model).NewScope(model)
 				field, hasField = scope.FieldByName(key)
 				columnName      string
 				ss              []string
@@ -440,7 +457,7 @@ func restUpdateHandler(reflectType reflect.Type) HandlerFunc {
 			modelValue = reflect.New(reflectType).Elem().Addr().Interface()
 		)
 		// 事务执行
-		err = c.WithTransaction(func(tx *gorm.DB) error {
+		err = c.WithModelTransaction(modelValue, func(tx *gorm.DB) error {
 			var params BizUpdateParams
 			if err := c.ShouldBindBodyWith(&params, binding.JSON); err != nil {
 				return err
@@ -455,6 +472,11 @@ func restUpdateHandler(reflectType reflect.Type) HandlerFunc {
 			if IsBlank(params.Cond) && !multi {
 				return errors.New("'multi' is required")
 			}
+			// 乐观锁：If-Match请求头或文档中的Ts
+			version, err := parseVersionExpectation(c.GetHeader("If-Match"), params.Doc)
+			if err != nil {
+				return err
+			}
 			// 处理更新条件
 			queryDB := tx.New()
 			_, queryDB = ParseCond(params.Cond, modelValue, queryDB)
@@ -476,6 +498,15 @@ func restUpdateHandler(reflectType reflect.Type) HandlerFunc {
 					return err
 				}
 				bizScope := NewBizScope(c, val, tx)
+				if version != nil {
+					if err := version.check(val); err != nil {
+						return err
+					}
+					// 更新时同时比较Ts，避免读取后被并发修改
+					if _, ts, ok := recordVersion(val); ok {
+						bizScope.DB = bizScope.DB.Set(updateVersionKey, ts)
+					}
+				}
 				bizScope.UpdateParams = &params
 				bizScope.UpdateCond = val
 				bizScope.Value = doc
@@ -500,7 +531,11 @@ func restUpdateHandler(reflectType reflect.Type) HandlerFunc {
 			return tx.Error
 		})
 		// 响应结果
-		if err != nil {
+		if IsVersionConflict(err) {
+			reply := c.STDErrWithCode(err, http.StatusConflict)
+			reply.HTTPCode = http.StatusConflict
+			return reply
+		} else if err != nil {
 			return c.STDErr(err, "rest_update_failed", "Update failed")
 		} else {
 			result = Meta(reflect.New(reflectType).Interface()).OmitPassword(result)
@@ -509,12 +544,39 @@ func restUpdateHandler(reflectType reflect.Type) HandlerFunc {
 	}
 }
 
+// selectProject 按project查询指定字段，返回处理后的查询和有效的字段名
+func selectProject(scope *gorm.Scope, modelValue interface{}, db *gorm.DB, rawProject string) (*gorm.DB, string) {
+	bsf, sok := modelValue.(buildSelectField)
+	var (
+		retProject []string
+		columns    []string
+	)
+	for _, name := range strings.Split(rawProject, ",") {
+		if strings.HasPrefix(name, "-") {
+			name = name[1:]
+		}
+		if field, ok := scope.FieldByName(name); ok {
+			dbName := field.DBName
+			if sok {
+				dbName = bsf.BuildSelectField(field.DBName)
+			}
+			if dbName == field.DBName {
+				columns = append(columns, scope.Quote(dbName))
+			} else {
+				columns = append(columns, dbName)
+			}
+			retProject = append(retProject, field.Name)
+		}
+	}
+	return db.Select(columns), strings.Join(retProject, ",")
+}
+
 func restQueryHandler(reflectType reflect.Type) HandlerFunc {
 	return func(c *Context) *STDReply {
 		var (
 			modelValue = reflect.New(reflectType).Elem().Addr().Interface()
 			ret        = new(BizQueryResult)
-			scope      = DB().NewScope(modelValue)
+			scope      = ModelDB(modelValue).NewScope(modelValue)
 		)
 		// 处理cond
 		var cond map[string]interface{}
@@ -525,35 +587,13 @@ func restQueryHandler(reflectType reflect.Type) HandlerFunc {
 			_ = JSONParse(rawCond, &retCond)
 			ret.Cond = retCond
 		}
-		_, db := ParseCond(cond, modelValue, DB().Model(modelValue))
+		// 列表查询走只读副本
+		_, db := ParseCond(cond, modelValue, ModelReadDB(modelValue).Model(modelValue))
 		// 处理project
 		rawProject := c.Query("project")
 		bsf, sok := modelValue.(buildSelectField)
 		if rawProject != "" {
-			split := strings.Split(rawProject, ",")
-			var (
-				retProject []string
-				columns    []string
-			)
-			for _, name := range split {
-				if strings.HasPrefix(name, "-") {
-					name = name[1:]
-				}
-				if field, ok := scope.FieldByName(name); ok {
-					dbName := field.DBName
-					if sok {
-						dbName = bsf.BuildSelectField(field.DBName)
-					}
-					if dbName == field.DBName {
-						columns = append(columns, scope.Quote(dbName))
-					} else {
-						columns = append(columns, dbName)
-					}
-					retProject = append(retProject, field.Name)
-				}
-			}
-			db = db.Select(columns)
-			ret.Project = strings.Join(retProject, ",")
+			db, ret.Project = selectProject(scope, modelValue, db, rawProject)
 		} else if sok {
 			var columns []string
 			for _, field := range scope.Fields() {
@@ -705,6 +745,10 @@ func restQueryHandler(reflectType reflect.Type) HandlerFunc {
 			}
 			ret.Preload = rawPreload
 		}
+		// 处理export
+		if format := c.Query("export"); format != "" {
+			return restExportHandler(c, reflectType, db, ret, format)
+		}
 
 		ret.List = reflect.New(reflect.SliceOf(reflectType)).Interface()
 		// 处理range
@@ -724,6 +768,13 @@ func restQueryHandler(reflectType reflect.Type) HandlerFunc {
 		if err := bizScope.DB.Error; err != nil {
 			return c.STDErr(err, "rest_query_failed", "Query failed")
 		}
+		// 返回各记录的ETag，单条记录时同时设置ETag响应头，可在更新时通过If-Match校验版本
+		ret.ETags = recordETags(ret.List)
+		if list := indirectValue(ret.List); list.Kind() == reflect.Slice && list.Len() == 1 {
+			if etag := RecordETag(list.Index(0).Interface()); etag != "" {
+				c.Header("ETag", fmt.Sprintf(`"%s"`, etag))
+			}
+		}
 		return c.STD(ret)
 	}
 }
@@ -736,7 +787,7 @@ func restDeleteHandler(reflectType reflect.Type) HandlerFunc {
 			modelValue = reflect.New(reflectType).Elem().Addr().Interface()
 		)
 		// 事务执行
-		err = c.WithTransaction(func(tx *gorm.DB) error {
+		err = c.WithModelTransaction(modelValue, func(tx *gorm.DB) error {
 			var params struct {
 				All    bool
 				Multi  bool
@@ -820,12 +871,13 @@ func restDeleteHandler(reflectType reflect.Type) HandlerFunc {
 func restCreateHandler(reflectType reflect.Type) HandlerFunc {
 	return func(c *Context) *STDReply {
 		var (
-			docs  []interface{}
-			multi bool
-			err   error
+			docs       []interface{}
+			multi      bool
+			err        error
+			modelValue = reflect.New(reflectType).Interface()
 		)
 		// 事务执行
-		err = c.WithTransaction(func(tx *gorm.DB) error {
+		err = c.WithModelTransaction(modelValue, func(tx *gorm.DB) error {
 			var body interface{}
 			if err := c.ShouldBindBodyWith(&body, binding.JSON); err != nil {
 				return err
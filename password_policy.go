@@ -0,0 +1,249 @@
+package kuu
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jinzhu/gorm"
+	"gopkg.in/guregu/null.v3"
+)
+
+// PasswordExpiredWhitelist 密码过期（或被要求修改密码）时仍允许访问的接口
+var PasswordExpiredWhitelist = []interface{}{
+	"POST /changepasswd",
+	"POST /logout",
+	"POST /valid",
+	"GET /sessions",
+}
+
+var (
+	ErrPasswordPlainRequired = errors.New("plaintext password is required to check password policy")
+	ErrPasswordPlainMismatch = errors.New("plaintext password does not match the hashed password")
+	ErrPasswordExpired       = errors.New("password expired or must be changed")
+)
+
+// PasswordPolicy 密码策略，对应配置项passwordPolicy
+type PasswordPolicy struct {
+	MinLength      int      `json:"minLength"`      // 最小长度
+	RequireUpper   bool     `json:"requireUpper"`   // 必须包含大写字母
+	RequireLower   bool     `json:"requireLower"`   // 必须包含小写字母
+	RequireDigit   bool     `json:"requireDigit"`   // 必须包含数字
+	RequireSymbol  bool     `json:"requireSymbol"`  // 必须包含特殊字符
+	MinCharClasses int      `json:"minCharClasses"` // 至少包含的字符种类数（大写/小写/数字/特殊字符）
+	Dictionary     []string `json:"dictionary"`     // 弱密码字典
+	DictionaryFile string   `json:"dictionaryFile"` // 弱密码字典文件，每行一个
+	HistoryCount   int      `json:"historyCount"`   // 禁止重复使用最近N次密码
+	MaxAgeDays     int      `json:"maxAgeDays"`     // 密码最长有效天数，0表示不过期
+}
+
+// GetPasswordPolicy 读取密码策略配置
+func GetPasswordPolicy() (policy PasswordPolicy) {
+	C().GetInterface("passwordPolicy", &policy)
+	return
+}
+
+// RequirePlain 是否需要明文密码才能完成校验（长度和字符种类）
+func (p PasswordPolicy) RequirePlain() bool {
+	return p.MinLength > 0 || p.RequireUpper || p.RequireLower || p.RequireDigit || p.RequireSymbol || p.MinCharClasses > 0
+}
+
+// CheckPlain 校验明文密码的长度、字符种类和弱密码字典
+func (p PasswordPolicy) CheckPlain(plain string) error {
+	if p.MinLength > 0 && len([]rune(plain)) < p.MinLength {
+		return NewIntlError(fmt.Errorf("password is shorter than %d", p.MinLength), "password_policy_min_length", "Password must be at least {{min}} characters.", D{"min": p.MinLength})
+	}
+	var upper, lower, digit, symbol bool
+	for _, r := range plain {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	if p.RequireUpper && !upper {
+		return NewIntlError(errors.New("password requires an uppercase letter"), "password_policy_require_upper", "Password must contain an uppercase letter.")
+	}
+	if p.RequireLower && !lower {
+		return NewIntlError(errors.New("password requires a lowercase letter"), "password_policy_require_lower", "Password must contain a lowercase letter.")
+	}
+	if p.RequireDigit && !digit {
+		return NewIntlError(errors.New("password requires a digit"), "password_policy_require_digit", "Password must contain a digit.")
+	}
+	if p.RequireSymbol && !symbol {
+		return NewIntlError(errors.New("password requires a symbol"), "password_policy_require_symbol", "Password must contain a special character.")
+	}
+	if p.MinCharClasses > 0 {
+		var classes int
+		for _, b := range []bool{upper, lower, digit, symbol} {
+			if b {
+				classes++
+			}
+		}
+		if classes < p.MinCharClasses {
+			return NewIntlError(fmt.Errorf("password contains %d character classes, requires %d", classes, p.MinCharClasses), "password_policy_char_classes", "Password must contain at least {{min}} of uppercase letters, lowercase letters, digits and special characters.", D{"min": p.MinCharClasses})
+		}
+	}
+	return p.checkDictionary(MD5(plain))
+}
+
+// CheckHashed 校验客户端MD5后的密码：弱密码字典和历史密码
+func (p PasswordPolicy) CheckHashed(tx *gorm.DB, uid uint, hashed string) error {
+	hashed = strings.ToLower(hashed)
+	if err := p.checkDictionary(hashed); err != nil {
+		return err
+	}
+	if p.HistoryCount <= 0 || uid == 0 {
+		return nil
+	}
+	var histories []PasswordHistory
+	if err := tx.Where(&PasswordHistory{UID: uid}).Order("id desc").Limit(p.HistoryCount).Find(&histories).Error; err != nil {
+		return err
+	}
+	for _, item := range histories {
+		if CompareHashAndPassword(item.Password, hashed) == nil {
+			return NewIntlError(errors.New("password was used recently"), "password_policy_reused", "Password cannot be the same as the last {{count}} passwords.", D{"count": p.HistoryCount})
+		}
+	}
+	return nil
+}
+
+func (p PasswordPolicy) checkDictionary(hashed string) error {
+	for _, word := range p.dictionaryWords() {
+		if MD5(word) == hashed {
+			return NewIntlError(errors.New("password is in the weak password dictionary"), "password_policy_dictionary", "Password is too common, please choose another one.")
+		}
+	}
+	return nil
+}
+
+func (p PasswordPolicy) dictionaryWords() []string {
+	words := append([]string{}, p.Dictionary...)
+	if p.DictionaryFile == "" {
+		return words
+	}
+	f, err := os.Open(p.DictionaryFile)
+	if err != nil {
+		ERROR(err)
+		return words
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if word := strings.TrimSpace(scanner.Text()); word != "" {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// ValidateNewPassword 按密码策略校验新密码，passwd为客户端MD5后的密码，plain为可选的明文密码
+func ValidateNewPassword(tx *gorm.DB, uid uint, passwd, plain string) error {
+	policy := GetPasswordPolicy()
+	if plain != "" {
+		if MD5(plain) != strings.ToLower(passwd) {
+			return NewIntlError(ErrPasswordPlainMismatch, "password_policy_plain_mismatch", "Password mismatch.")
+		}
+		if err := policy.CheckPlain(plain); err != nil {
+			return err
+		}
+	} else if policy.RequirePlain() {
+		return NewIntlError(ErrPasswordPlainRequired, "password_policy_plain_required", "Password does not meet the password policy.")
+	}
+	return policy.CheckHashed(tx, uid, passwd)
+}
+
+// SavePasswordHistory 记录历史密码，hashed为bcrypt后的密码
+func SavePasswordHistory(tx *gorm.DB, uid uint, hashed string) error {
+	if uid == 0 || hashed == "" {
+		return nil
+	}
+	return tx.Create(&PasswordHistory{UID: uid, Password: hashed}).Error
+}
+
+// IsPasswordExpired 判断用户是否需要修改密码（管理员要求修改或密码已过期）
+func IsPasswordExpired(user *User) bool {
+	if user == nil || user.ID == 0 {
+		return false
+	}
+	if user.ForceChangePassword.Bool {
+		return true
+	}
+	maxAgeDays := GetPasswordPolicy().MaxAgeDays
+	if maxAgeDays <= 0 {
+		return false
+	}
+	last := user.CreatedAt
+	if user.LastChangePasswordTime != nil {
+		last = *user.LastChangePasswordTime
+	}
+	return time.Since(last) > time.Duration(maxAgeDays)*24*time.Hour
+}
+
+// ChangeUserPassword 修改用户密码，passwd为客户端MD5后的密码
+func ChangeUserPassword(tx *gorm.DB, uid uint, passwd string, forceChange bool) error {
+	hashed, err := GenerateFromPassword(strings.ToLower(passwd))
+	if err != nil {
+		return err
+	}
+	if err := tx.Model(&User{}).Where("id = ?", uid).Updates(map[string]interface{}{
+		"Password":               hashed,
+		"LastChangePasswordTime": time.Now(),
+		"ForceChangePassword":    null.BoolFrom(forceChange),
+	}).Error; err != nil {
+		return err
+	}
+	DelCache(fmt.Sprintf("user_%d", uid))
+	return SavePasswordHistory(tx, uid, hashed)
+}
+
+// PasswordResetRoute
+var PasswordResetRoute = RouteInfo{
+	Name:   "重置用户密码（该接口仅限root调用）",
+	Method: "POST",
+	Path:   "/resetpasswd",
+	IntlMessages: map[string]string{
+		"parse_body_failed":     "解析请求参数失败",
+		"newpasswd_error":       "新密码错误",
+		"login_as_unauthorized": "Unauthorized operation",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		if c.SignInfo.UID != RootUID() {
+			return c.STDErr(fmt.Errorf("unauthorized operation: uid=%v", c.SignInfo.UID), "login_as_unauthorized")
+		}
+		var body struct {
+			UID         uint   `binding:"required"`
+			Passwd      string `binding:"required"`
+			PasswdPlain string
+			ForceChange null.Bool
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			return c.STDErr(err, "parse_body_failed")
+		}
+		forceChange := !body.ForceChange.Valid || body.ForceChange.Bool
+		err := c.IgnoreAuth().WithTransaction(func(tx *gorm.DB) error {
+			if err := ValidateNewPassword(tx, body.UID, body.Passwd, body.PasswdPlain); err != nil {
+				return err
+			}
+			if err := ChangeUserPassword(tx, body.UID, body.Passwd, forceChange); err != nil {
+				return err
+			}
+			_, err := RevokeUserSessions(tx, body.UID)
+			return err
+		})
+		c.IgnoreAuth(true)
+		if err != nil {
+			return c.STDErr(err, "newpasswd_error")
+		}
+		return c.STDOK()
+	},
+}
@@ -0,0 +1,48 @@
+package kuu
+
+import "testing"
+
+func TestPasswordPolicyCheckPlain(t *testing.T) {
+	policy := PasswordPolicy{
+		MinLength:      8,
+		MinCharClasses: 3,
+		Dictionary:     []string{"Passw0rd!"},
+	}
+	cases := map[string]string{
+		"Ab1!":      "password_policy_min_length",
+		"abcdefgh":  "password_policy_char_classes",
+		"abcdefg1":  "password_policy_char_classes",
+		"Passw0rd!": "password_policy_dictionary",
+		"abcDEF12":  "",
+		"abc-def12": "",
+	}
+	for plain, want := range cases {
+		err := policy.CheckPlain(plain)
+		if want == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", plain, err)
+			}
+			continue
+		}
+		ie, ok := err.(*IntlError)
+		if !ok || ie.ID != want {
+			t.Errorf("%s: want %s, got %v", plain, want, err)
+		}
+	}
+}
+
+func TestPasswordPolicyRequire(t *testing.T) {
+	policy := PasswordPolicy{RequireUpper: true, RequireDigit: true, RequireSymbol: true}
+	if err := policy.CheckPlain("abcdef1!"); err == nil || err.(*IntlError).ID != "password_policy_require_upper" {
+		t.Errorf("want password_policy_require_upper, got %v", err)
+	}
+	if err := policy.CheckPlain("Abcdefg!"); err == nil || err.(*IntlError).ID != "password_policy_require_digit" {
+		t.Errorf("want password_policy_require_digit, got %v", err)
+	}
+	if err := policy.CheckPlain("Abcdefg1"); err == nil || err.(*IntlError).ID != "password_policy_require_symbol" {
+		t.Errorf("want password_policy_require_symbol, got %v", err)
+	}
+	if err := policy.CheckPlain("Abcdef1!"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
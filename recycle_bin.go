@@ -0,0 +1,402 @@
+package kuu
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// recycleUniqueIndex 包含删除标记的联合唯一索引名称
+const recycleUniqueIndex = "kuu_unique"
+
+var (
+	ErrRecycleUnsupported = errors.New("model does not support soft delete")
+	ErrRecycleConflict    = errors.New("restore conflicts with an existing record")
+)
+
+// RecycleBinConfig 回收站配置，对应配置项recycleBin
+type RecycleBinConfig struct {
+	Spec          string         `json:"spec"`          // 清理任务cron表达式
+	RetentionDays int            `json:"retentionDays"` // 默认保留天数，0表示永久保留
+	Models        map[string]int `json:"models"`        // 按模型名称指定保留天数，0表示永久保留
+}
+
+// GetRecycleBinConfig
+func GetRecycleBinConfig() RecycleBinConfig {
+	config := RecycleBinConfig{
+		Spec: "@daily",
+	}
+	C().GetInterface("recycleBin", &config)
+	return config
+}
+
+// PurgeEnabled 是否配置了保留天数，配置后导入模块时自动添加清理任务
+func (config RecycleBinConfig) PurgeEnabled() bool {
+	if config.RetentionDays > 0 {
+		return true
+	}
+	for _, days := range config.Models {
+		if days > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ModelRetentionDays 模型的保留天数
+func (config RecycleBinConfig) ModelRetentionDays(modelName string) int {
+	if days, ok := config.Models[modelName]; ok {
+		return days
+	}
+	return config.RetentionDays
+}
+
+// RecycleItem 回收站记录
+type RecycleItem struct {
+	ID            uint
+	DeletedAt     *time.Time
+	DeletedByID   uint
+	DeletedByName string
+	Record        interface{}
+}
+
+// recycleMeta 查询支持软删除的模型
+func recycleMeta(modelName string) (*Metadata, *gorm.Field, error) {
+	meta := Meta(modelName)
+	if meta == nil || meta.reflectType == nil {
+		return nil, nil, fmt.Errorf("model not found: %s", modelName)
+	}
+	field, ok := DB().NewScope(meta.NewValue()).FieldByName("DeletedAt")
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", ErrRecycleUnsupported, modelName)
+	}
+	return meta, field, nil
+}
+
+// recycleRouteMeta 回收站接口只开放已注册REST接口的模型，并按对应接口（查询、恢复对应修改、彻底删除对应删除）校验API权限
+func recycleRouteMeta(modelName, op string) (*Metadata, error) {
+	meta := Meta(modelName)
+	if meta == nil || meta.RestDesc == nil || !meta.RestDesc.IsValid() {
+		return nil, fmt.Errorf("model not found: %s", modelName)
+	}
+	desc := meta.RestDesc
+	enabled := map[string]bool{
+		"query":  desc.Query,
+		"update": desc.Update,
+		"delete": desc.Delete,
+	}
+	if !enabled[op] {
+		return nil, fmt.Errorf("%s is not allowed on %s", op, modelName)
+	}
+	if prisDesc := GetRoutinePrivilegesDesc(); prisDesc != nil && !prisDesc.HasAPIPermission(desc.Methods[op], desc.Path) {
+		return nil, fmt.Errorf("no permission to %s %s", op, modelName)
+	}
+	return meta, nil
+}
+
+// recycleUniqueFields 联合唯一索引kuu_unique中除删除标记外的字段
+func recycleUniqueFields(scope *gorm.Scope) (fields []*gorm.Field) {
+	for _, field := range scope.Fields() {
+		if field.Name == "Dr" {
+			continue
+		}
+		setting, _ := field.TagSettingsGet("UNIQUE_INDEX")
+		for _, name := range strings.Split(setting, ",") {
+			if strings.TrimSpace(name) == recycleUniqueIndex {
+				fields = append(fields, field)
+				break
+			}
+		}
+	}
+	return
+}
+
+// ListRecycleBin 分页查询模型已删除的记录（受数据权限控制）
+func ListRecycleBin(modelName string, page, size int) (*BizQueryResult, error) {
+	meta, deletedAtField, err := recycleMeta(modelName)
+	if err != nil {
+		return nil, err
+	}
+	if page < 1 {
+		page = 1
+	}
+	if size < 1 {
+		size = 30
+	}
+	var (
+		value   = meta.NewValue()
+		db      = ModelReadDB(value)
+		scope   = db.NewScope(value)
+		where   = fmt.Sprintf("%v.%v IS NOT NULL", scope.QuotedTableName(), scope.Quote(deletedAtField.DBName))
+		list    = reflect.New(reflect.SliceOf(meta.reflectType))
+		reply   = &BizQueryResult{Page: page, Size: size}
+		orderBy = fmt.Sprintf("%v.%v DESC", scope.QuotedTableName(), scope.Quote(deletedAtField.DBName))
+	)
+	if err := db.Unscoped().Model(value).Where(where).Count(&reply.TotalRecords).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Unscoped().Where(where).Order(orderBy).Offset((page - 1) * size).Limit(size).Find(list.Interface()).Error; err != nil {
+		return nil, err
+	}
+	reply.TotalPages = (reply.TotalRecords + size - 1) / size
+
+	rows := list.Elem()
+	items := make([]RecycleItem, rows.Len())
+	userIDs := make([]uint, 0, rows.Len())
+	for i := 0; i < rows.Len(); i++ {
+		record := rows.Index(i).Addr().Interface()
+		itemScope := db.NewScope(record)
+		item := RecycleItem{Record: meta.OmitPassword(record)}
+		if field, ok := itemScope.FieldByName("ID"); ok {
+			item.ID = explainUintValue(field.Field.Interface())
+		}
+		if field, ok := itemScope.FieldByName("DeletedAt"); ok {
+			item.DeletedAt, _ = field.Field.Interface().(*time.Time)
+		}
+		if field, ok := itemScope.FieldByName("DeletedByID"); ok {
+			item.DeletedByID = explainUintValue(field.Field.Interface())
+			userIDs = append(userIDs, item.DeletedByID)
+		}
+		items[i] = item
+	}
+	// 删除人可能在当前用户的数据权限之外，忽略权限查询姓名
+	if len(userIDs) > 0 {
+		var users []User
+		func() {
+			IgnoreAuth()
+			defer IgnoreAuth(true)
+			DB().Select("id, name, username").Where("id IN (?)", userIDs).Find(&users)
+		}()
+		names := make(map[uint]string, len(users))
+		for _, user := range users {
+			names[user.ID] = user.Name
+			if names[user.ID] == "" {
+				names[user.ID] = user.Username
+			}
+		}
+		for i := range items {
+			items[i].DeletedByName = names[items[i].DeletedByID]
+		}
+	}
+	reply.List = items
+	return reply, nil
+}
+
+// RestoreDeleted 恢复已删除的记录，恢复后与现有记录唯一键冲突时返回ErrRecycleConflict
+func RestoreDeleted(tx *gorm.DB, modelName string, ids []uint) (int64, error) {
+	meta, deletedAtField, err := recycleMeta(modelName)
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	var (
+		value   = meta.NewValue()
+		scope   = tx.NewScope(value)
+		deleted = fmt.Sprintf("%v.%v IS NOT NULL", scope.QuotedTableName(), scope.Quote(deletedAtField.DBName))
+		idIn    = fmt.Sprintf("%v.%v IN (?)", scope.QuotedTableName(), scope.Quote(scope.PrimaryKey()))
+		list    = reflect.New(reflect.SliceOf(meta.reflectType))
+	)
+	if err := tx.Unscoped().Where(deleted).Where(idIn, ids).Find(list.Interface()).Error; err != nil {
+		return 0, err
+	}
+	// 校验唯一键冲突
+	if fields := recycleUniqueFields(scope); len(fields) > 0 {
+		rows := list.Elem()
+		for i := 0; i < rows.Len(); i++ {
+			rowScope := tx.NewScope(rows.Index(i).Addr().Interface())
+			query := tx.Model(meta.NewValue())
+			var values []string
+			for _, field := range fields {
+				rowField, _ := rowScope.FieldByName(field.Name)
+				query = query.Where(fmt.Sprintf("%v.%v = ?", scope.QuotedTableName(), scope.Quote(field.DBName)), rowField.Field.Interface())
+				values = append(values, fmt.Sprintf("%s=%v", field.Name, rowField.Field.Interface()))
+			}
+			var count int
+			// 冲突校验不受数据权限限制
+			IgnoreAuth()
+			err := query.Count(&count).Error
+			IgnoreAuth(true)
+			if err != nil {
+				return 0, err
+			}
+			if count > 0 {
+				return 0, NewIntlError(fmt.Errorf("%w: %s(%s)", ErrRecycleConflict, meta.Name, strings.Join(values, ", ")),
+					"recycle_restore_conflict", "Restore conflicts with an existing record: {{values}}", D{"values": strings.Join(values, ", ")})
+			}
+		}
+	}
+	attrs := map[string]interface{}{deletedAtField.DBName: nil}
+	for _, name := range []string{"DeletedByID", "Dr"} {
+		if field, ok := scope.FieldByName(name); ok {
+			attrs[field.DBName] = 0
+		}
+	}
+	db := tx.Unscoped().Model(value).Where(deleted).Where(idIn, ids).Updates(attrs)
+	return db.RowsAffected, db.Error
+}
+
+// PurgeDeleted 彻底删除回收站中的记录（受数据权限控制）
+func PurgeDeleted(tx *gorm.DB, modelName string, ids []uint) (int64, error) {
+	meta, deletedAtField, err := recycleMeta(modelName)
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	value := meta.NewValue()
+	scope := tx.NewScope(value)
+	db := tx.Unscoped().
+		Where(fmt.Sprintf("%v.%v IS NOT NULL", scope.QuotedTableName(), scope.Quote(deletedAtField.DBName))).
+		Where(fmt.Sprintf("%v.%v IN (?)", scope.QuotedTableName(), scope.Quote(scope.PrimaryKey())), ids).
+		Delete(value)
+	return db.RowsAffected, db.Error
+}
+
+// PurgeExpiredDeleted 按保留天数彻底删除各模型过期的已删除记录，返回各模型删除数量
+func PurgeExpiredDeleted(now time.Time) (map[string]int64, error) {
+	IgnoreAuth()
+	defer IgnoreAuth(true)
+
+	var (
+		config = GetRecycleBinConfig()
+		result = make(map[string]int64)
+	)
+	for _, meta := range metadataList {
+		days := config.ModelRetentionDays(meta.Name)
+		if days <= 0 || meta.reflectType == nil {
+			continue
+		}
+		value := meta.NewValue()
+		db := ModelDB(value)
+		field, ok := db.NewScope(value).FieldByName("DeletedAt")
+		if !ok {
+			continue
+		}
+		scope := db.NewScope(value)
+		expired := now.AddDate(0, 0, -days)
+		res := db.Unscoped().
+			Where(fmt.Sprintf("%v.%v < ?", scope.QuotedTableName(), scope.Quote(field.DBName)), expired).
+			Delete(value)
+		if res.Error != nil {
+			return result, fmt.Errorf("%s: %w", meta.Name, res.Error)
+		}
+		if res.RowsAffected > 0 {
+			result[meta.Name] = res.RowsAffected
+		}
+	}
+	return result, nil
+}
+
+// AddRecycleBinPurgeJob 按配置项recycleBin:spec添加回收站清理任务，启用多租户时依次清理各租户数据源
+func AddRecycleBinPurgeJob() error {
+	_, err := AddJob(GetRecycleBinConfig().Spec, "回收站清理", func(c *JobContext) {
+		err := EachTenant(func(code string) error {
+			result, err := PurgeExpiredDeleted(time.Now())
+			if err != nil {
+				return err
+			}
+			INFO("recycle bin purged: tenant=%s, %v", code, result)
+			return nil
+		})
+		if err != nil {
+			c.Error(err)
+		}
+	})
+	return err
+}
+
+// RecycleBinRoute
+var RecycleBinRoute = RouteInfo{
+	Name:   "查询回收站记录",
+	Method: http.MethodGet,
+	Path:   "/recycle",
+	IntlMessages: map[string]string{
+		"recycle_query_failed": "Failed to query the recycle bin",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		if _, err := recycleRouteMeta(c.Query("model"), "query"); err != nil {
+			return c.STDErr(err, "recycle_query_failed")
+		}
+		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+		size, _ := strconv.Atoi(c.DefaultQuery("size", "30"))
+		reply, err := ListRecycleBin(c.Query("model"), page, size)
+		if err != nil {
+			return c.STDErr(err, "recycle_query_failed")
+		}
+		return c.STD(reply)
+	},
+}
+
+// RecycleRestoreRoute
+var RecycleRestoreRoute = RouteInfo{
+	Name:   "恢复回收站记录",
+	Method: http.MethodPost,
+	Path:   "/recycle/restore",
+	IntlMessages: map[string]string{
+		"recycle_restore_failed":   "Failed to restore records",
+		"recycle_restore_conflict": "Restore conflicts with an existing record: {{values}}",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		var body struct {
+			Model string `binding:"required"`
+			IDs   []uint `binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			return c.STDErr(err, "recycle_restore_failed")
+		}
+		meta, err := recycleRouteMeta(body.Model, "update")
+		if err != nil {
+			return c.STDErr(err, "recycle_restore_failed")
+		}
+		var count int64
+		err = c.WithModelTransaction(meta.NewValue(), func(tx *gorm.DB) (err error) {
+			count, err = RestoreDeleted(tx, body.Model, body.IDs)
+			return
+		})
+		if err != nil {
+			return c.STDErr(err, "recycle_restore_failed")
+		}
+		return c.STD(count)
+	},
+}
+
+// RecyclePurgeRoute
+var RecyclePurgeRoute = RouteInfo{
+	Name:   "彻底删除回收站记录",
+	Method: http.MethodPost,
+	Path:   "/recycle/purge",
+	IntlMessages: map[string]string{
+		"recycle_purge_failed": "Failed to purge records",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		var body struct {
+			Model string `binding:"required"`
+			IDs   []uint `binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			return c.STDErr(err, "recycle_purge_failed")
+		}
+		meta, err := recycleRouteMeta(body.Model, "delete")
+		if err != nil {
+			return c.STDErr(err, "recycle_purge_failed")
+		}
+		var count int64
+		err = c.WithModelTransaction(meta.NewValue(), func(tx *gorm.DB) (err error) {
+			count, err = PurgeDeleted(tx, body.Model, body.IDs)
+			return
+		})
+		if err != nil {
+			return c.STDErr(err, "recycle_purge_failed")
+		}
+		return c.STD(count)
+	},
+}
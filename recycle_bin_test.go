@@ -0,0 +1,103 @@
+package kuu
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+func TestRecycleBinRetentionDays(t *testing.T) {
+	config := RecycleBinConfig{
+		RetentionDays: 30,
+		Models:        map[string]int{"Order": 90, "User": 0},
+	}
+	if v := config.ModelRetentionDays("Order"); v != 90 {
+		t.Errorf("expected 90, got %d", v)
+	}
+	if v := config.ModelRetentionDays("User"); v != 0 {
+		t.Errorf("expected model to be kept forever, got %d", v)
+	}
+	if v := config.ModelRetentionDays("Param"); v != 30 {
+		t.Errorf("expected default 30, got %d", v)
+	}
+	if !config.PurgeEnabled() || (RecycleBinConfig{Models: map[string]int{"User": 0}}).PurgeEnabled() {
+		t.Error("unexpected purge enabled")
+	}
+}
+
+type testRecycleItem struct {
+	gorm.Model
+	Dr   int64  `gorm:"DEFAULT:0;UNIQUE_INDEX:kuu_unique"`
+	Code string `gorm:"UNIQUE_INDEX:kuu_unique"`
+}
+
+// testSoftDelete 模拟系统回调的软删除（同时写入删除标记）
+func testSoftDelete(t *testing.T, db *gorm.DB, id uint) {
+	t.Helper()
+	now := time.Now()
+	if err := db.Model(&testRecycleItem{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{"deleted_at": now, "dr": now.UnixNano()}).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecycleRestoreAndPurge(t *testing.T) {
+	db := testSQLiteDB(t, "")
+	parseMetadata(&testRecycleItem{})
+	if err := db.AutoMigrate(&testRecycleItem{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	deleted := testRecycleItem{Code: "x"}
+	db.Create(&deleted)
+	testSoftDelete(t, db, deleted.ID)
+	active := testRecycleItem{Code: "x"}
+	if err := db.Create(&active).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 存在唯一键相同的记录时拒绝恢复
+	if _, err := RestoreDeleted(db, "testRecycleItem", []uint{deleted.ID}); !errors.Is(err, ErrRecycleConflict) {
+		t.Fatalf("expected restore conflict, got %v", err)
+	}
+	testSoftDelete(t, db, active.ID)
+	count, err := RestoreDeleted(db, "testRecycleItem", []uint{deleted.ID})
+	if err != nil || count != 1 {
+		t.Fatalf("unexpected restore: %d, %v", count, err)
+	}
+	var restored testRecycleItem
+	if err := db.First(&restored, deleted.ID).Error; err != nil || restored.Dr != 0 {
+		t.Errorf("unexpected restored record: %+v, %v", restored, err)
+	}
+
+	// 只彻底删除回收站中的记录
+	count, err = PurgeDeleted(db, "testRecycleItem", []uint{deleted.ID, active.ID})
+	if err != nil || count != 1 {
+		t.Fatalf("unexpected purge: %d, %v", count, err)
+	}
+	var total int
+	db.Unscoped().Model(&testRecycleItem{}).Count(&total)
+	if total != 1 {
+		t.Errorf("expected only the restored record left, got %d", total)
+	}
+}
+
+func TestRecycleRouteMeta(t *testing.T) {
+	testSQLiteDB(t, "")
+	meta := parseMetadata(&testRecycleItem{})
+	defer func() { meta.RestDesc = nil }()
+
+	if _, err := recycleRouteMeta("testRecycleItem", "update"); err == nil {
+		t.Error("expected models without REST routes to be rejected")
+	}
+	meta.RestDesc = &RestDesc{Query: true, Update: true, Path: "/api/testrecycleitem", Methods: map[string]string{"query": "GET", "update": "PUT", "delete": "-"}}
+	if _, err := recycleRouteMeta("testRecycleItem", "update"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := recycleRouteMeta("testRecycleItem", "delete"); err == nil {
+		t.Error("expected purge to be rejected when delete route is disabled")
+	}
+	if _, err := recycleRouteMeta("Unknown", "query"); err == nil {
+		t.Error("expected unknown model to be rejected")
+	}
+}
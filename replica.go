@@ -0,0 +1,224 @@
+package kuu
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// routineWrittenKey 当前请求已发生写入的标记
+const routineWrittenKey = "DBWritten"
+
+var replicaGroups sync.Map
+
+// ReplicaConfig 读写分离配置，对应配置项dbReplica
+type ReplicaConfig struct {
+	StickySeconds      int `json:"stickySeconds"`      // 会话写入后继续读取主库的时长（秒），0表示不粘滞
+	HealthCheckSeconds int `json:"healthCheckSeconds"` // 副本健康检查间隔（秒）
+}
+
+// GetReplicaConfig
+func GetReplicaConfig() ReplicaConfig {
+	config := ReplicaConfig{
+		StickySeconds:      5,
+		HealthCheckSeconds: 10,
+	}
+	C().GetInterface("dbReplica", &config)
+	return config
+}
+
+// replicaGroup 主数据源的只读副本组
+type replicaGroup struct {
+	primary string
+	names   []string
+	healthy []int32
+	next    uint32
+}
+
+// pick 轮询选择健康的副本，无可用副本时返回空
+func (g *replicaGroup) pick() string {
+	n := len(g.names)
+	if n == 0 {
+		return ""
+	}
+	start := atomic.AddUint32(&g.next, 1)
+	for i := 0; i < n; i++ {
+		index := int((start + uint32(i)) % uint32(n))
+		if atomic.LoadInt32(&g.healthy[index]) == 1 {
+			return g.names[index]
+		}
+	}
+	return ""
+}
+
+func (g *replicaGroup) setHealthy(index int, healthy bool) {
+	var v int32
+	if healthy {
+		v = 1
+	}
+	if old := atomic.SwapInt32(&g.healthy[index], v); old != v {
+		if healthy {
+			INFO("replica is healthy again: %s", g.names[index])
+		} else {
+			WARN("replica is unhealthy: %s", g.names[index])
+		}
+	}
+}
+
+// checkHealth 检查全部副本的连通性
+func (g *replicaGroup) checkHealth() {
+	for index, name := range g.names {
+		v, ok := dataSourcesMap.Load(name)
+		if !ok {
+			g.setHealthy(index, false)
+			continue
+		}
+		g.setHealthy(index, v.(*gorm.DB).DB().Ping() == nil)
+	}
+}
+
+// openReplicas 打开主数据源的副本，副本连接失败时不影响启动，由健康检查自动恢复
+func openReplicas(primary dataSource) {
+	if len(primary.Replicas) == 0 {
+		return
+	}
+	var (
+		group  = &replicaGroup{primary: primary.Name}
+		failed = make(map[int]dataSource)
+	)
+	for index, replica := range primary.Replicas {
+		replica.Name = fmt.Sprintf("%s_replica_%d", primary.Name, index)
+		if replica.Dialect == "" {
+			replica.Dialect = primary.Dialect
+		}
+		healthy := int32(1)
+		if err := openDataSource(replica); err != nil {
+			ERROR("failed to open replica %s: %s", replica.Name, err.Error())
+			healthy = 0
+			failed[index] = replica
+		}
+		group.names = append(group.names, replica.Name)
+		group.healthy = append(group.healthy, healthy)
+	}
+	replicaGroups.Store(primary.Name, group)
+	for index, replica := range failed {
+		go reopenReplica(group, index, replica)
+	}
+
+	interval := time.Duration(GetReplicaConfig().HealthCheckSeconds) * time.Second
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			group.checkHealth()
+		}
+	}()
+}
+
+// reopenReplica 定时重试打开启动时连接失败的副本
+func reopenReplica(group *replicaGroup, index int, replica dataSource) {
+	interval := time.Duration(GetReplicaConfig().HealthCheckSeconds) * time.Second
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	for {
+		time.Sleep(interval)
+		if err := openDataSource(replica); err == nil {
+			group.setHealthy(index, true)
+			return
+		}
+	}
+}
+
+// ReadDB 返回当前数据源的只读副本（轮询健康副本），会话写入后的粘滞期内或无可用副本时返回主库
+func ReadDB() *gorm.DB {
+	return DSRead(GetRoutineDataSource())
+}
+
+// DSRead 返回指定数据源的只读副本
+func DSRead(name string) *gorm.DB {
+	if name == "" {
+		name = singleDSName
+	}
+	if v, ok := replicaGroups.Load(name); ok && !isRoutineWriteSticky() {
+		if replica := v.(*replicaGroup).pick(); replica != "" {
+			return DS(replica)
+		}
+	}
+	return DS(name)
+}
+
+// routineStickyKey 读写粘滞的用户缓存键，未登录时为空
+// 按用户而非令牌记录，缓存键数量不会随会话增长
+func routineStickyKey() string {
+	raw, _ := GetGLSValue(GLSSignInfoKey)
+	if sign, ok := raw.(*SignContext); ok && sign != nil && sign.UID != 0 {
+		return fmt.Sprintf("db_sticky_%d", sign.UID)
+	}
+	return ""
+}
+
+// isWriteStickyAt 判断最近写入时间（Unix秒）是否仍在粘滞期内
+// 部分缓存实现（如bolt）不支持过期时间，因此不能依赖缓存键是否存在
+func isWriteStickyAt(raw string, now time.Time, seconds int) bool {
+	if raw == "" || seconds <= 0 {
+		return false
+	}
+	written, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return false
+	}
+	return now.Unix()-written < int64(seconds)
+}
+
+// isRoutineWriteSticky 判断当前请求或会话是否需要读取主库
+func isRoutineWriteSticky() bool {
+	if caches := GetRoutineCaches(); caches != nil {
+		if _, ok := caches[routineWrittenKey]; ok {
+			return true
+		}
+	}
+	if key := routineStickyKey(); key != "" {
+		return isWriteStickyAt(GetCacheString(key), time.Now(), GetReplicaConfig().StickySeconds)
+	}
+	return false
+}
+
+// markRoutineWrite 记录当前请求和会话发生了写入，同一请求内只记录一次
+func markRoutineWrite() {
+	if caches := GetRoutineCaches(); caches != nil {
+		if _, ok := caches[routineWrittenKey]; ok {
+			return
+		}
+		caches[routineWrittenKey] = true
+	}
+	seconds := GetReplicaConfig().StickySeconds
+	if seconds <= 0 {
+		return
+	}
+	if key := routineStickyKey(); key != "" {
+		SetCacheString(key, strconv.FormatInt(time.Now().Unix(), 10), time.Duration(seconds)*time.Second)
+	}
+}
+
+// markWriteCallback 配置了副本的数据源发生写入时开启读主库粘滞
+func markWriteCallback(scope *gorm.Scope) {
+	if scope.HasError() {
+		return
+	}
+	hasReplicas := false
+	replicaGroups.Range(func(_, _ interface{}) bool {
+		hasReplicas = true
+		return false
+	})
+	if hasReplicas {
+		markRoutineWrite()
+	}
+}
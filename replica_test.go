@@ -0,0 +1,59 @@
+package kuu
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jtolds/gls"
+)
+
+func TestReplicaGroupPick(t *testing.T) {
+	group := &replicaGroup{
+		primary: "main",
+		names:   []string{"r0", "r1", "r2"},
+		healthy: []int32{1, 0, 1},
+	}
+	counts := make(map[string]int)
+	for i := 0; i < 10; i++ {
+		counts[group.pick()]++
+	}
+	if counts["r1"] != 0 || counts["r0"] == 0 || counts["r2"] == 0 || counts["r0"]+counts["r2"] != 10 {
+		t.Errorf("unexpected distribution: %v", counts)
+	}
+	group.setHealthy(0, false)
+	group.setHealthy(2, false)
+	if v := group.pick(); v != "" {
+		t.Errorf("expected no healthy replica, got %s", v)
+	}
+	group.setHealthy(1, true)
+	if v := group.pick(); v != "r1" {
+		t.Errorf("expected r1, got %s", v)
+	}
+}
+
+func TestRoutineWriteSticky(t *testing.T) {
+	SetGLSValues(gls.Values{GLSRoutineCachesKey: make(RoutineCaches)}, func() {
+		if isRoutineWriteSticky() {
+			t.Error("unexpected sticky before write")
+		}
+		markRoutineWrite()
+		if !isRoutineWriteSticky() {
+			t.Error("expected sticky after write")
+		}
+	})
+}
+
+func TestIsWriteStickyAt(t *testing.T) {
+	now := time.Now()
+	written := strconv.FormatInt(now.Unix(), 10)
+	if !isWriteStickyAt(written, now.Add(4*time.Second), 5) {
+		t.Error("expected sticky within window")
+	}
+	if isWriteStickyAt(written, now.Add(5*time.Second), 5) {
+		t.Error("unexpected sticky after window")
+	}
+	if isWriteStickyAt(written, now, 0) || isWriteStickyAt("", now, 5) || isWriteStickyAt("1", now, 5) {
+		t.Error("unexpected sticky")
+	}
+}
@@ -0,0 +1,316 @@
+package kuu
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/kuuland/kuu/intl"
+	"gopkg.in/guregu/null.v3"
+)
+
+// RoleAssignSourceDelegation 用户将自己的角色临时委托给他人
+const RoleAssignSourceDelegation = "DELEGATION"
+
+var (
+	ErrRoleDelegationNotHeld  = errors.New("role is not held by the delegator")
+	ErrRoleDelegationInvalid  = errors.New("invalid role delegation")
+	ErrRoleDelegationTooLong  = errors.New("role delegation exceeds the maximum period")
+	ErrRoleDelegationNotOwner = errors.New("role delegation is not granted by current user")
+)
+
+// IsActive 判断角色分配在指定时间是否生效
+func (a *RoleAssign) IsActive(now time.Time) bool {
+	if a.StartUnix > 0 && now.Unix() < a.StartUnix {
+		return false
+	}
+	return a.ExpireUnix <= 0 || now.Unix() < a.ExpireUnix
+}
+
+// mergePermissionExp 合并同一权限的多个有效期，0表示永久有效
+func mergePermissionExp(current int64, exists bool, exp int64) int64 {
+	if !exists {
+		return exp
+	}
+	if current <= 0 || exp <= 0 {
+		return 0
+	}
+	if exp > current {
+		return exp
+	}
+	return current
+}
+
+// RoleAssignExpiryConfig 角色分配到期处理配置，对应配置项roleAssignExpiry
+type RoleAssignExpiryConfig struct {
+	Spec                 string `json:"spec"`                 // 定时任务cron表达式
+	Disabled             bool   `json:"disabled"`             // 是否停用定时任务，停用后导入模块时不再自动添加
+	NoticeSeconds        int64  `json:"noticeSeconds"`        // 到期前多久发送提醒（秒），0表示不提醒
+	MaxDelegationSeconds int64  `json:"maxDelegationSeconds"` // 单次委托的最长期限（秒）
+}
+
+// GetRoleAssignExpiryConfig
+func GetRoleAssignExpiryConfig() RoleAssignExpiryConfig {
+	config := RoleAssignExpiryConfig{
+		Spec:                 "@every 10m",
+		NoticeSeconds:        3 * 86400,
+		MaxDelegationSeconds: 30 * 86400,
+	}
+	C().GetInterface("roleAssignExpiry", &config)
+	return config
+}
+
+// RoleAssignExpiryResult 到期处理结果
+type RoleAssignExpiryResult struct {
+	Expired  int
+	Orphaned int
+	Noticed  int
+}
+
+// ProcessRoleAssignExpiry 清理已过期的角色分配和失效的委托，并向即将到期的用户发送提醒
+func ProcessRoleAssignExpiry(tx *gorm.DB, now time.Time) (*RoleAssignExpiryResult, error) {
+	var (
+		config = GetRoleAssignExpiryConfig()
+		result = &RoleAssignExpiryResult{}
+		quote  = tx.Dialect().Quote
+	)
+	// 1.清理已过期的分配
+	var expired []RoleAssign
+	if err := tx.Where(fmt.Sprintf("%s > 0 AND %s <= ?", quote("expire_unix"), quote("expire_unix")), now.Unix()).Find(&expired).Error; err != nil {
+		return nil, err
+	}
+	for _, assign := range expired {
+		if err := tx.Where("id = ?", assign.ID).Delete(&RoleAssign{}).Error; err != nil {
+			return nil, err
+		}
+	}
+	result.Expired = len(expired)
+	// 2.委托人不再持有该角色时，委托同时失效
+	var delegations []RoleAssign
+	if err := tx.Where(&RoleAssign{Source: RoleAssignSourceDelegation}).Find(&delegations).Error; err != nil {
+		return nil, err
+	}
+	for _, assign := range delegations {
+		held, err := holdsRole(tx, assign.DelegatorID, assign.RoleID, now)
+		if err != nil {
+			return nil, err
+		}
+		if held {
+			continue
+		}
+		if err := tx.Where("id = ?", assign.ID).Delete(&RoleAssign{}).Error; err != nil {
+			return nil, err
+		}
+		result.Orphaned++
+	}
+	// 3.到期提醒
+	if config.NoticeSeconds <= 0 {
+		return result, nil
+	}
+	var expiring []RoleAssign
+	if err := tx.Where(fmt.Sprintf("%s > ? AND %s <= ? AND %s = 0", quote("expire_unix"), quote("expire_unix"), quote("notice_unix")),
+		now.Unix(), now.Unix()+config.NoticeSeconds).Preload("Role").Find(&expiring).Error; err != nil {
+		return nil, err
+	}
+	for _, assign := range expiring {
+		if err := sendRoleAssignExpiryNotice(tx, &assign); err != nil {
+			return nil, err
+		}
+		if err := tx.Model(&RoleAssign{}).Where("id = ?", assign.ID).UpdateColumn("notice_unix", now.Unix()).Error; err != nil {
+			return nil, err
+		}
+		result.Noticed++
+	}
+	return result, nil
+}
+
+func sendRoleAssignExpiryNotice(tx *gorm.DB, assign *RoleAssign) error {
+	var user User
+	if err := tx.Where("id = ?", assign.UserID).First(&user).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil
+		}
+		return err
+	}
+	roleName := fmt.Sprintf("%d", assign.RoleID)
+	if assign.Role != nil && assign.Role.Name != "" {
+		roleName = assign.Role.Name
+	}
+	values := D{"role": roleName, "time": time.Unix(assign.ExpireUnix, 0).Format("2006-01-02 15:04:05")}
+	messages := GetIntlMessagesByLang(user.Lang)
+	subject := messages["role_assign_expiry_subject"]
+	if subject == "" {
+		subject = "Role assignment is about to expire"
+	}
+	content := messages["role_assign_expiry_content"]
+	if content == "" {
+		content = "Your role {{role}} will expire at {{time}}."
+	}
+	message := Message{
+		Subject:          intl.FormatMessage(subject, values),
+		Content:          null.StringFrom(intl.FormatMessage(content, values)),
+		Status:           MessageStatusSent,
+		RecipientUserIDs: strconv.Itoa(int(user.ID)),
+	}
+	return tx.Create(&message).Error
+}
+
+// holdsRole 判断用户是否通过非委托方式持有有效的角色
+func holdsRole(tx *gorm.DB, uid, roleID uint, now time.Time) (bool, error) {
+	if uid == 0 {
+		return false, nil
+	}
+	var assigns []RoleAssign
+	if err := tx.Where(&RoleAssign{UserID: uid, RoleID: roleID}).Find(&assigns).Error; err != nil {
+		return false, err
+	}
+	for _, assign := range assigns {
+		if assign.Source != RoleAssignSourceDelegation && assign.IsActive(now) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// AddRoleAssignExpiryJob 按配置项roleAssignExpiry:spec添加到期处理任务
+func AddRoleAssignExpiryJob() error {
+	_, err := AddJob(GetRoleAssignExpiryConfig().Spec, "角色分配到期处理", func(c *JobContext) {
+		IgnoreAuth()
+		defer IgnoreAuth(true)
+
+		var result *RoleAssignExpiryResult
+		err := WithTransaction(func(tx *gorm.DB) (err error) {
+			result, err = ProcessRoleAssignExpiry(tx, time.Now())
+			return
+		})
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		INFO("role assign expiry processed: expired=%d, orphaned=%d, noticed=%d", result.Expired, result.Orphaned, result.Noticed)
+	})
+	return err
+}
+
+// RoleDelegateRoute
+var RoleDelegateRoute = RouteInfo{
+	Name:   "将本人角色临时委托给其他用户",
+	Method: http.MethodPost,
+	Path:   "/role/delegations",
+	IntlMessages: map[string]string{
+		"role_delegate_failed": "Failed to delegate role",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		var body struct {
+			UserID     uint `binding:"required"`
+			RoleID     uint `binding:"required"`
+			StartUnix  int64
+			ExpireUnix int64 `binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			return c.STDErr(err, "role_delegate_failed")
+		}
+		var (
+			now    = time.Now()
+			config = GetRoleAssignExpiryConfig()
+			start  = body.StartUnix
+		)
+		if start <= 0 {
+			start = now.Unix()
+		}
+		if body.UserID == c.SignInfo.UID || body.ExpireUnix <= now.Unix() || body.ExpireUnix <= start {
+			return c.STDErr(ErrRoleDelegationInvalid, "role_delegate_failed")
+		}
+		if config.MaxDelegationSeconds > 0 && body.ExpireUnix-start > config.MaxDelegationSeconds {
+			return c.STDErr(NewIntlError(ErrRoleDelegationTooLong, "role_delegate_too_long", "Role delegation cannot exceed {{days}} days.", D{"days": config.MaxDelegationSeconds / 86400}), "role_delegate_failed")
+		}
+		var assign RoleAssign
+		err := c.IgnoreAuth().WithTransaction(func(tx *gorm.DB) error {
+			var own []RoleAssign
+			if err := tx.Where(&RoleAssign{UserID: c.SignInfo.UID, RoleID: body.RoleID}).Find(&own).Error; err != nil {
+				return err
+			}
+			// 委托期限不能超过委托人自身的角色有效期
+			var held bool
+			for _, item := range own {
+				if item.Source == RoleAssignSourceDelegation || !item.IsActive(now) {
+					continue
+				}
+				if item.ExpireUnix <= 0 || item.ExpireUnix >= body.ExpireUnix {
+					held = true
+					break
+				}
+			}
+			if !held {
+				return NewIntlError(ErrRoleDelegationNotHeld, "role_delegate_not_held", "You can only delegate roles you hold for the whole delegation period.")
+			}
+			assign = RoleAssign{
+				UserID:      body.UserID,
+				RoleID:      body.RoleID,
+				StartUnix:   body.StartUnix,
+				ExpireUnix:  body.ExpireUnix,
+				DelegatorID: c.SignInfo.UID,
+				Source:      RoleAssignSourceDelegation,
+			}
+			return tx.Create(&assign).Error
+		})
+		c.IgnoreAuth(true)
+		if err != nil {
+			return c.STDErr(err, "role_delegate_failed")
+		}
+		return c.STD(assign)
+	},
+}
+
+// RoleDelegationsRoute
+var RoleDelegationsRoute = RouteInfo{
+	Name:   "查询本人委托及被委托的角色",
+	Method: http.MethodGet,
+	Path:   "/role/delegations",
+	IntlMessages: map[string]string{
+		"role_delegations_failed": "Failed to query role delegations",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		var reply struct {
+			Granted  []RoleAssign
+			Received []RoleAssign
+		}
+		db := c.IgnoreAuth().DB()
+		defer c.IgnoreAuth(true)
+		if err := db.Where(&RoleAssign{DelegatorID: c.SignInfo.UID, Source: RoleAssignSourceDelegation}).Preload("Role").Order("id desc").Find(&reply.Granted).Error; err != nil {
+			return c.STDErr(err, "role_delegations_failed")
+		}
+		if err := db.Where(&RoleAssign{UserID: c.SignInfo.UID, Source: RoleAssignSourceDelegation}).Preload("Role").Order("id desc").Find(&reply.Received).Error; err != nil {
+			return c.STDErr(err, "role_delegations_failed")
+		}
+		return c.STD(reply)
+	},
+}
+
+// RoleDelegationRevokeRoute
+var RoleDelegationRevokeRoute = RouteInfo{
+	Name:   "撤销角色委托",
+	Method: http.MethodDelete,
+	Path:   "/role/delegations/:id",
+	IntlMessages: map[string]string{
+		"role_delegation_revoke_failed": "Failed to revoke role delegation",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		var assign RoleAssign
+		db := c.IgnoreAuth().DB()
+		defer c.IgnoreAuth(true)
+		if err := db.Where("id = ?", ParseID(c.Param("id"))).Where(&RoleAssign{Source: RoleAssignSourceDelegation}).First(&assign).Error; err != nil {
+			return c.STDErr(err, "role_delegation_revoke_failed")
+		}
+		if assign.DelegatorID != c.SignInfo.UID && c.SignInfo.UID != RootUID() {
+			return c.STDErr(ErrRoleDelegationNotOwner, "role_delegation_revoke_failed")
+		}
+		if err := db.Where("id = ?", assign.ID).Delete(&RoleAssign{}).Error; err != nil {
+			return c.STDErr(err, "role_delegation_revoke_failed")
+		}
+		return c.STDOK()
+	},
+}
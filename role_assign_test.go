@@ -0,0 +1,40 @@
+package kuu
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRoleAssignIsActive(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		assign RoleAssign
+		want   bool
+	}{
+		{RoleAssign{}, true},
+		{RoleAssign{ExpireUnix: now.Add(time.Hour).Unix()}, true},
+		{RoleAssign{ExpireUnix: now.Add(-time.Hour).Unix()}, false},
+		{RoleAssign{StartUnix: now.Add(time.Hour).Unix()}, false},
+		{RoleAssign{StartUnix: now.Add(-time.Hour).Unix(), ExpireUnix: now.Add(time.Hour).Unix()}, true},
+	}
+	for i, item := range cases {
+		if got := item.assign.IsActive(now); got != item.want {
+			t.Errorf("case %d: expected %v, got %v", i, item.want, got)
+		}
+	}
+}
+
+func TestMergePermissionExp(t *testing.T) {
+	if v := mergePermissionExp(0, false, 100); v != 100 {
+		t.Errorf("expected 100, got %d", v)
+	}
+	if v := mergePermissionExp(100, true, 0); v != 0 {
+		t.Errorf("permanent grant should win, got %d", v)
+	}
+	if v := mergePermissionExp(0, true, 100); v != 0 {
+		t.Errorf("permanent grant should win, got %d", v)
+	}
+	if v := mergePermissionExp(100, true, 200); v != 200 {
+		t.Errorf("later expiry should win, got %d", v)
+	}
+}
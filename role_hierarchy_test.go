@@ -0,0 +1,27 @@
+package kuu
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveRoleAncestors(t *testing.T) {
+	parents := map[uint][]uint{
+		4: {3, 2},
+		3: {1},
+		2: {1},
+		// 历史数据中的环
+		5: {6},
+		6: {5},
+	}
+	result := resolveRoleAncestors(parents, []uint{4, 1, 5})
+	if !reflect.DeepEqual(result[4], []uint{3, 2, 1}) {
+		t.Errorf("unexpected ancestors of 4: %v", result[4])
+	}
+	if len(result[1]) != 0 {
+		t.Errorf("unexpected ancestors of 1: %v", result[1])
+	}
+	if !reflect.DeepEqual(result[5], []uint{6}) {
+		t.Errorf("unexpected ancestors of 5: %v", result[5])
+	}
+}
@@ -0,0 +1,224 @@
+package kuu
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/jinzhu/gorm"
+)
+
+var ErrSeedInvalid = errors.New("invalid seed")
+
+// Seed 模块种子数据，内容为以模型名称为键的记录列表，如：
+//
+//	Role:
+//	  - Code: auditor
+//	    Name: 审计员
+//	Param:
+//	  - Code: siteName
+//	    Value: Demo
+//
+// 记录按自然键（默认为Code）幂等导入，已存在时跳过（Update为true时更新）
+type Seed struct {
+	Name   string              // 数据集名称
+	Envs   []string            // 适用环境，如dev、test、demo，为空时适用全部环境
+	File   string              // JSON或YAML文件路径
+	Data   []byte              // 与File二选一，JSON或YAML内容
+	Keys   map[string][]string // 模型的自然键字段，未指定时为Code
+	Update bool                // 记录已存在时是否更新
+}
+
+// SeedOptions 种子数据导入参数
+type SeedOptions struct {
+	Env  string   // 环境名称，为空时使用配置项seedEnv
+	Mods []string // 模块编码，为空时为全部已导入模块
+}
+
+// SeedResult 种子数据导入结果
+type SeedResult struct {
+	ModCode string
+	Seed    string
+	Model   string
+	Created int
+	Updated int
+	Skipped int
+}
+
+// GetSeedEnv 当前环境名称，对应配置项seedEnv
+func GetSeedEnv() string {
+	return C().GetString("seedEnv")
+}
+
+// matchEnv 判断种子数据是否适用于指定环境
+func (s *Seed) matchEnv(env string) bool {
+	if len(s.Envs) == 0 {
+		return true
+	}
+	for _, item := range s.Envs {
+		if strings.EqualFold(item, env) {
+			return true
+		}
+	}
+	return false
+}
+
+// parse 解析种子数据
+func (s *Seed) parse() (map[string][]map[string]interface{}, error) {
+	data := s.Data
+	if s.File != "" {
+		buf, err := ioutil.ReadFile(s.File)
+		if err != nil {
+			return nil, err
+		}
+		data = buf
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: %s has no data", ErrSeedInvalid, s.Name)
+	}
+	// YAML是JSON的超集，统一转换为JSON解析
+	data, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %s", ErrSeedInvalid, s.Name, err.Error())
+	}
+	var sets map[string][]map[string]interface{}
+	if err := JSON().Unmarshal(data, &sets); err != nil {
+		return nil, fmt.Errorf("%w: %s: %s", ErrSeedInvalid, s.Name, err.Error())
+	}
+	return sets, nil
+}
+
+// naturalKeys 模型的自然键字段
+func (s *Seed) naturalKeys(modelName string) []string {
+	if keys := s.Keys[modelName]; len(keys) > 0 {
+		return keys
+	}
+	return []string{"Code"}
+}
+
+// LoadSeeds 导入适用于当前环境的模块种子数据
+func LoadSeeds(opts SeedOptions) ([]SeedResult, error) {
+	if opts.Env == "" {
+		opts.Env = GetSeedEnv()
+	}
+	mods, err := selectMigrationMods(opts.Mods)
+	if err != nil {
+		return nil, err
+	}
+	var results []SeedResult
+	for _, mod := range mods {
+		for _, seed := range mod.Seeds {
+			if !seed.matchEnv(opts.Env) {
+				continue
+			}
+			list, err := LoadSeed(seed)
+			for i := range list {
+				list[i].ModCode = mod.Code
+			}
+			results = append(results, list...)
+			if err != nil {
+				return results, fmt.Errorf("failed to load seed %s/%s: %w", mod.Code, seed.Name, err)
+			}
+		}
+	}
+	return results, nil
+}
+
+// LoadSeed 导入种子数据（不校验环境），也可在测试中直接导入夹具：
+//
+//	kuu.LoadSeed(kuu.Seed{Name: "fixtures", File: "testdata/fixtures.yml"})
+func LoadSeed(seed Seed) ([]SeedResult, error) {
+	sets, err := seed.parse()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(sets))
+	for name := range sets {
+		if Meta(name) == nil {
+			return nil, fmt.Errorf("%w: model not found: %s", ErrSeedInvalid, name)
+		}
+		names = append(names, name)
+	}
+	sortSeedModels(names)
+
+	IgnoreAuth()
+	defer IgnoreAuth(true)
+
+	var results []SeedResult
+	for _, name := range names {
+		result := SeedResult{Seed: seed.Name, Model: name}
+		meta := Meta(name)
+		err := withDBTransaction(ModelDB(meta.NewValue()), func(tx *gorm.DB) error {
+			for _, row := range sets[name] {
+				created, err := loadSeedRecord(tx, meta, seed.naturalKeys(name), row, seed.Update)
+				if err != nil {
+					return err
+				}
+				switch created {
+				case seedRecordCreated:
+					result.Created++
+				case seedRecordUpdated:
+					result.Updated++
+				default:
+					result.Skipped++
+				}
+			}
+			return nil
+		})
+		results = append(results, result)
+		if err != nil {
+			return results, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return results, nil
+}
+
+// sortSeedModels 按模型注册顺序排序，使被引用的模型先导入
+func sortSeedModels(names []string) {
+	order := make(map[string]int, len(metadataList))
+	for index, meta := range metadataList {
+		order[meta.Name] = index
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		return order[names[i]] < order[names[j]]
+	})
+}
+
+const (
+	seedRecordSkipped = iota
+	seedRecordCreated
+	seedRecordUpdated
+)
+
+// loadSeedRecord 按自然键导入单条记录
+func loadSeedRecord(tx *gorm.DB, meta *Metadata, keys []string, row map[string]interface{}, update bool) (int, error) {
+	value := meta.NewValue()
+	if err := Copy(row, value); err != nil {
+		return seedRecordSkipped, err
+	}
+	scope := tx.NewScope(value)
+	where := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		field, ok := scope.FieldByName(key)
+		if !ok {
+			return seedRecordSkipped, fmt.Errorf("%w: %s has no field %s", ErrSeedInvalid, meta.Name, key)
+		}
+		if field.IsBlank {
+			return seedRecordSkipped, fmt.Errorf("%w: %s.%s is required", ErrSeedInvalid, meta.Name, key)
+		}
+		where[field.DBName] = field.Field.Interface()
+	}
+	existing := reflect.New(meta.reflectType).Interface()
+	err := tx.Unscoped().Where(where).First(existing).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return seedRecordCreated, tx.Create(value).Error
+	}
+	if err != nil || !update {
+		return seedRecordSkipped, err
+	}
+	return seedRecordUpdated, tx.Model(existing).Updates(row).Error
+}
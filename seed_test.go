@@ -0,0 +1,101 @@
+package kuu
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+)
+
+type testSeedItem struct {
+	gorm.Model
+	Code string
+	Name string
+}
+
+func TestSeedParse(t *testing.T) {
+	seed := Seed{Name: "demo", Data: []byte(`
+Role:
+  - Code: auditor
+    Name: 审计员
+Param:
+  - Code: siteName
+    Value: Demo
+`)}
+	sets, err := seed.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sets["Role"]) != 1 || sets["Role"][0]["Code"] != "auditor" || sets["Param"][0]["Value"] != "Demo" {
+		t.Errorf("unexpected sets: %v", sets)
+	}
+
+	seed.Data = []byte(`{"Role": [{"Code": "viewer"}]}`)
+	if sets, err = seed.parse(); err != nil || sets["Role"][0]["Code"] != "viewer" {
+		t.Errorf("unexpected json seed: %v, %v", sets, err)
+	}
+
+	seed.Data = []byte(`Role: auditor`)
+	if _, err := seed.parse(); !errors.Is(err, ErrSeedInvalid) {
+		t.Errorf("expected invalid seed error, got %v", err)
+	}
+}
+
+func TestSeedMatchEnv(t *testing.T) {
+	if !(&Seed{}).matchEnv("prod") {
+		t.Error("seed without envs should match all environments")
+	}
+	seed := &Seed{Envs: []string{"dev", "Demo"}}
+	if !seed.matchEnv("demo") || seed.matchEnv("prod") || seed.matchEnv("") {
+		t.Error("unexpected env matching")
+	}
+	if keys := seed.naturalKeys("User"); len(keys) != 1 || keys[0] != "Code" {
+		t.Errorf("unexpected default keys: %v", keys)
+	}
+}
+
+func TestLoadSeedIdempotent(t *testing.T) {
+	db := testSQLiteDB(t, "")
+	parseMetadata(&testSeedItem{})
+	if err := db.AutoMigrate(&testSeedItem{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	seed := Seed{Name: "items", Data: []byte(`
+testSeedItem:
+  - Code: a
+    Name: A
+  - Code: b
+    Name: B
+`)}
+	results, err := LoadSeed(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Created != 2 || results[0].Skipped != 0 {
+		t.Fatalf("unexpected first run: %+v", results)
+	}
+
+	// 再次导入时按自然键跳过已存在的记录
+	results, err = LoadSeed(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Created != 0 || results[0].Updated != 0 || results[0].Skipped != 2 {
+		t.Fatalf("unexpected second run: %+v", results)
+	}
+	var count int
+	db.Model(&testSeedItem{}).Count(&count)
+	if count != 2 {
+		t.Errorf("expected 2 records, got %d", count)
+	}
+
+	seed.Update = true
+	seed.Data = []byte(`testSeedItem: [{Code: a, Name: A2}]`)
+	if results, err = LoadSeed(seed); err != nil || results[0].Updated != 1 {
+		t.Fatalf("unexpected update run: %+v, %v", results, err)
+	}
+	var item testSeedItem
+	if err := db.Where(&testSeedItem{Code: "a"}).First(&item).Error; err != nil || item.Name != "A2" {
+		t.Errorf("unexpected updated item: %+v, %v", item, err)
+	}
+}
@@ -301,15 +301,21 @@ func GetActOrg(c *Context, actOrgID uint) (actOrg Org, err error) {
 
 // GetUserWithRoles
 var GetUserWithRoles = func(uid uint) (*User, error) {
-	// 查询用户档案
-	var user User
-	if err := DB().Where("id = ?", uid).Preload("RoleAssigns").First(&user).Error; err != nil {
+	// 查询用户档案（走只读副本）
+	var (
+		db   = ReadDB()
+		user User
+	)
+	if err := db.Where("id = ?", uid).Preload("RoleAssigns").First(&user).Error; err != nil {
 		return &user, err
 	}
 	// 过滤有效的角色分配
-	var roleIDs []uint
+	var (
+		now     = time.Now()
+		roleIDs []uint
+	)
 	for _, assign := range user.RoleAssigns {
-		if assign.ExpireUnix <= 0 || time.Now().Before(time.Unix(assign.ExpireUnix, 0)) {
+		if assign.IsActive(now) {
 			roleIDs = append(roleIDs, assign.RoleID)
 		}
 	}
@@ -318,15 +324,23 @@ var GetUserWithRoles = func(uid uint) (*User, error) {
 		roles   []Role
 		roleMap = make(map[uint]Role)
 	)
-	if err := DB().Where("id in (?)", roleIDs).Preload("OperationPrivileges").Preload("DataPrivileges").Find(&roles).Error; err != nil {
+	if err := db.Where("id in (?)", roleIDs).Preload("OperationPrivileges").Preload("DataPrivileges").Find(&roles).Error; err != nil {
+		return &user, err
+	}
+	// 合并父角色的权限
+	roles, err := inheritRolePrivileges(db, roles)
+	if err != nil {
 		return &user, err
 	}
 	for _, role := range roles {
 		roleMap[role.ID] = role
 	}
-	// 重新赋值
+	// 重新赋值（未生效或已过期的分配不关联角色）
 	for index, assign := range user.RoleAssigns {
-		role := roleMap[assign.RoleID]
+		role, ok := roleMap[assign.RoleID]
+		if !ok || !assign.IsActive(now) {
+			continue
+		}
 		assign.Role = &role
 		user.RoleAssigns[index] = assign
 	}
@@ -352,16 +366,19 @@ func defaultLoginHandler(c *Context) (resp *LoginHandlerResponse) {
 	if err := c.ShouldBindBodyWith(&body, binding.JSON); err != nil {
 		return &LoginHandlerResponse{Error: err}
 	}
-	// 判断是否需要需要校验验证码
-	failedTimesKey := getFailedTimesKey(body.Username)
-	failedTimes := GetCacheInt(failedTimesKey)
-	cacheFailedTimes := func() {
-		SetCacheInt(failedTimesKey, failedTimes+1)
-	}
 	resp = &LoginHandlerResponse{
 		Username: body.Username,
 		Password: body.Password,
 	}
+	// 检测来源IP是否被限制登录
+	if err := CheckLoginThrottle(c.TrustedClientIP()); err != nil {
+		resp.Error = err
+		resp.LocaleMessageID = "acc_login_throttled"
+		resp.LocaleMessageDefaultText = "Too many failed login attempts, please try again later."
+		return
+	}
+	// 判断是否需要需要校验验证码
+	failedTimes := getLoginFailures(getFailedTimesKey(body.Username), GetLockoutPolicy().window()).Count
 	if failedTimesValid(failedTimes) {
 		// 校验验证码
 		if body.CaptchaID == "" {
@@ -382,7 +399,15 @@ func defaultLoginHandler(c *Context) (resp *LoginHandlerResponse) {
 	var user User
 	if err := DB().Where(&User{Username: body.Username}).First(&user).Error; err != nil {
 		resp.Error = err
-		cacheFailedTimes()
+		RecordLoginFailure(c, body.Username, nil)
+		return
+	}
+	// 检测账号是否被锁定
+	if IsAccountLocked(&user) {
+		resp.Error = fmt.Errorf("%w: uid=%v, until=%v", ErrAccountLocked, user.ID, user.LockedUntil)
+		resp.LocaleMessageID = "acc_account_locked"
+		resp.LocaleMessageDefaultText = "Account locked due to too many failed login attempts, please try again after {{until}}."
+		resp.LocaleMessageContextValues = D{"until": user.LockedUntil.Format("2006-01-02 15:04:05")}
 		return
 	}
 	// 检测账号是否禁止登录
@@ -390,7 +415,7 @@ func defaultLoginHandler(c *Context) (resp *LoginHandlerResponse) {
 		resp.Error = fmt.Errorf("account deny login: %v", user.ID)
 		resp.LocaleMessageID = "acc_account_deny"
 		resp.LocaleMessageDefaultText = "Account deny login."
-		cacheFailedTimes()
+		RecordLoginFailure(c, body.Username, nil)
 		return
 	}
 	// 检测账号是否有效
@@ -398,16 +423,23 @@ func defaultLoginHandler(c *Context) (resp *LoginHandlerResponse) {
 		resp.Error = fmt.Errorf("account has been disabled: %v", user.ID)
 		resp.LocaleMessageID = "acc_account_disabled"
 		resp.LocaleMessageDefaultText = "Account has been disabled."
-		cacheFailedTimes()
+		RecordLoginFailure(c, body.Username, nil)
 		return
 	}
 	// 检测密码是否正确
 	body.Password = strings.ToLower(body.Password)
 	if err := CompareHashAndPassword(user.Password, body.Password); err != nil {
 		resp.Error = err
-		cacheFailedTimes()
+		RecordLoginFailure(c, body.Username, &user)
 		return
 	}
+	ResetLoginFailures(&user)
+	setLoginPayload(c, resp, &user)
+	return
+}
+
+// setLoginPayload 根据用户信息设置登录响应的令牌载荷
+func setLoginPayload(c *Context, resp *LoginHandlerResponse, user *User) {
 	resp.Payload = jwt.MapClaims{
 		"UID":       user.ID,
 		"Username":  user.Username,
@@ -419,7 +451,8 @@ func defaultLoginHandler(c *Context) (resp *LoginHandlerResponse) {
 		"CreatedAt": user.CreatedAt,
 		"UpdatedAt": user.UpdatedAt,
 	}
-	resp.Payload = SetPayloadAttrs(resp.Payload, &user)
+	resp.Payload = SetPayloadAttrs(resp.Payload, user)
+	resp.Payload["MustChangePassword"] = IsPasswordExpired(user)
 	// 处理Lang参数
 	if user.Lang == "" {
 		user.Lang = c.Lang()
@@ -427,7 +460,6 @@ func defaultLoginHandler(c *Context) (resp *LoginHandlerResponse) {
 	resp.Payload["Lang"] = user.Lang
 	resp.Lang = user.Lang
 	resp.UID = user.ID
-	return
 }
 
 // SetPayloadAttrs
@@ -461,23 +493,44 @@ func Sys() *Mod {
 			&Role{},
 			&OperationPrivileges{},
 			&DataPrivileges{},
+			&RoleParent{},
 			&Menu{},
 			&File{},
 			&Param{},
 			&Message{},
 			&MessageReceipt{},
 			&RepeatEvent{},
+			&PasswordHistory{},
+			&EventLog{},
+			&EventLogLabel{},
 		},
 		Routes: RoutesInfo{
 			OrgLoginableRoute,
 			OrgSwitchRoute,
 			UserRoleAssigns,
 			RoleUserAssigns,
+			RoleEffectivePrivilegesRoute,
+			AuthExplainRoute,
+			TenantProvisionRoute,
+			TenantsRoute,
+			TenantMigrateRoute,
+			MigrationsRoute,
+			MigrateUpRoute,
+			MigrateDownRoute,
+			RecycleBinRoute,
+			RecycleRestoreRoute,
+			RecyclePurgeRoute,
+			BatchRoute,
 			UserMenusRoute,
 			UploadRoute,
 			ImportRoute,
 			ImportTemplateRoute,
 			ChangePassword,
+			PasswordResetRoute,
+			UnlockUserRoute,
+			RoleDelegateRoute,
+			RoleDelegationsRoute,
+			RoleDelegationRevokeRoute,
 			AuthRoute,
 			MetaRoute,
 			DataDictRoute,
@@ -498,6 +551,22 @@ func Sys() *Mod {
 			MessagesReadRoute,
 			TriggerRepeatEvent,
 		},
-		OnInit: initSys,
+		OnImport: initSysJobs,
+		OnInit:   initSys,
 	}
 }
+
+// initSysJobs 按配置添加系统定时任务
+func initSysJobs() error {
+	if !GetRoleAssignExpiryConfig().Disabled {
+		if err := AddRoleAssignExpiryJob(); err != nil {
+			return err
+		}
+	}
+	if GetRecycleBinConfig().PurgeEnabled() {
+		if err := AddRecycleBinPurgeJob(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -26,6 +27,8 @@ func init() {
 		Add(DataScopePersonal, "个人范围").
 		Add(DataScopeCurrent, "当前组织").
 		Add(DataScopeCurrentFollowing, "当前及以下组织")
+	Enum("EventLogClass", "事件分类").
+		Add(EventLogClassSecurity, "安全事件")
 }
 
 // PrivilegesDesc
@@ -189,6 +192,11 @@ func (desc *PrivilegesDesc) shouldCheckAPIPermission(config APIPermissionConfig,
 
 // HasAPIPermission 检查用户是否有API访问权限
 func (desc *PrivilegesDesc) HasAPIPermission(method, path string) bool {
+	// API Key权限范围
+	if desc.SignInfo != nil && !desc.SignInfo.Secret.GetAPIKeyScope().AllowAPI(method, path) {
+		return false
+	}
+
 	// 如果是root用户，直接允许
 	if desc.UID == RootUID() {
 		return true
@@ -275,6 +283,7 @@ type AuthProcessorDesc struct {
 	SubDocIDNames       []string
 	Scope               *gorm.Scope
 	PrisDesc            *PrivilegesDesc
+	Action              string // 操作类型：read、create、update、delete
 	HasCreatedByIDField bool
 	HasOrgIDField       bool
 	CreatedByIDField    *gorm.Field
@@ -348,7 +357,7 @@ func (por *DefaultAuthProcessor) AllowCreate(auth AuthProcessorDesc) (err error)
 			return fmt.Errorf("用户 %d 在组织 %d 中无可写权限", desc.UID, auth.OrgID)
 		}
 	}
-	return
+	return CheckAccessPolicies(auth, AuthActionCreate)
 }
 
 // AddWritableWheres
@@ -366,7 +375,17 @@ func (por *DefaultAuthProcessor) AddWritableWheres(auth AuthProcessorDesc) (err
 	if len(sqls) > 0 {
 		auth.Scope.Search.Where(strings.Join(sqls, " OR "), attrs...)
 	}
-	return
+	// 数据访问策略
+	action := auth.Action
+	if action == "" {
+		action = AuthActionUpdate
+	}
+	if action == AuthActionUpdate {
+		if err := CheckAccessPolicies(auth, action); err != nil {
+			return err
+		}
+	}
+	return AddAccessPolicyWheres(auth, action)
 }
 
 // AddReadableWheres
@@ -384,7 +403,7 @@ func (por *DefaultAuthProcessor) AddReadableWheres(auth AuthProcessorDesc) (err
 	if len(sqls) > 0 {
 		auth.Scope.Search.Where(strings.Join(sqls, " OR "), attrs...)
 	}
-	return
+	return AddAccessPolicyWheres(auth, AuthActionRead)
 }
 
 // GetDataScopeWheres
@@ -550,15 +569,17 @@ func GetPrivilegesDesc(signOrContextOrUID interface{}) (desc *PrivilegesDesc) {
 		personalReadableOrgIDMap = make(map[uint]Org)
 		personalWritableOrgIDMap = make(map[uint]Org)
 	)
+	now := time.Now()
 	for _, assign := range user.RoleAssigns {
-		if assign.Role == nil {
+		if assign.Role == nil || !assign.IsActive(now) {
 			continue
 		}
 		desc.RolesCode = append(desc.RolesCode, assign.Role.Code)
 		roleIDs = append(roleIDs, strconv.Itoa(int(assign.Role.ID)))
 		for _, op := range assign.Role.OperationPrivileges {
 			if op.MenuCode != "" {
-				desc.PermissionMap[op.MenuCode] = assign.ExpireUnix
+				exp, exists := desc.PermissionMap[op.MenuCode]
+				desc.PermissionMap[op.MenuCode] = mergePermissionExp(exp, exists, assign.ExpireUnix)
 			}
 		}
 		for _, dp := range assign.Role.DataPrivileges {
@@ -594,7 +615,7 @@ func GetPrivilegesDesc(signOrContextOrUID interface{}) (desc *PrivilegesDesc) {
 		}
 	}
 	var orgList []Org
-	if err := DB().Find(&orgList).Error; err != nil {
+	if err := ReadDB().Find(&orgList).Error; err != nil {
 		ERROR("组织列表查询失败")
 		return
 	}
@@ -700,6 +721,10 @@ func GetPrivilegesDesc(signOrContextOrUID interface{}) (desc *PrivilegesDesc) {
 	}
 	desc.ReadableOrgIDMap = filteredReadableOrgIDs
 	desc.ReadableOrgIDs = keys(filteredReadableOrgIDs)
+	// API Key权限范围
+	if sign != nil {
+		desc.applyAPIKeyScope(sign.Secret.GetAPIKeyScope())
+	}
 
 	desc.addEmptyOrgs()
 
@@ -32,7 +32,16 @@ var (
 )
 
 func registerCallbacks() {
-	callback := DB().Callback()
+	dataSourcesMap.Range(func(_, value interface{}) bool {
+		registerDBCallbacks(value.(*gorm.DB))
+		return true
+	})
+	callbacksRegistered = true
+}
+
+// registerDBCallbacks gorm的Callback()只对当前数据源生效，每个数据源都需单独注册
+func registerDBCallbacks(db *gorm.DB) {
+	callback := db.Callback()
 	// 注册系统callback
 	if callback.Create().Get("kuu:uuid_create") == nil {
 		callback.Create().Before("gorm:begin_transaction").Register("kuu:uuid_create", uuidCreateCallback)
@@ -73,6 +82,23 @@ func registerCallbacks() {
 	if callback.Delete().Get("kuu:delete") == nil {
 		callback.Delete().Replace("gorm:delete", DeleteCallback)
 	}
+	// 注册批量写入冲突处理callback
+	if callback.Create().Get("kuu:upsert_insert_option") == nil {
+		callback.Create().Before("gorm:create").Register("kuu:upsert_insert_option", upsertInsertOptionCallback)
+	}
+	if callback.Create().Get("kuu:upsert_insert_conflict") == nil {
+		callback.Create().After("gorm:create").Register("kuu:upsert_insert_conflict", upsertInsertConflictCallback)
+	}
+	// 注册读写分离callback
+	if callback.Create().Get("kuu:mark_write") == nil {
+		callback.Create().After("gorm:create").Register("kuu:mark_write", markWriteCallback)
+	}
+	if callback.Update().Get("kuu:mark_write") == nil {
+		callback.Update().After("gorm:update").Register("kuu:mark_write", markWriteCallback)
+	}
+	if callback.Delete().Get("kuu:mark_write") == nil {
+		callback.Delete().After("gorm:delete").Register("kuu:mark_write", markWriteCallback)
+	}
 	// 注册数据变更callback
 	if callback.Create().Get("kuu:model_change") == nil {
 		callback.Create().After("gorm:after_create").Register("kuu:model_change", modelChangeCallback)
@@ -146,9 +172,14 @@ func beforeQueryCallback(scope *gorm.Scope) {
 	}
 }
 
+// tsNow Ts字段的当前时间，截断到微秒以与数据库精度（DATETIME(6)）一致，避免写入后的ETag与读取到的不一致
+func tsNow() time.Time {
+	return time.Now().Truncate(time.Microsecond)
+}
+
 func updateTsForCreateCallback(scope *gorm.Scope) {
 	if !scope.HasError() {
-		now := time.Now()
+		now := tsNow()
 
 		if tsField, ok := scope.FieldByName("Ts"); ok {
 			if tsField.IsBlank {
@@ -161,7 +192,7 @@ func updateTsForCreateCallback(scope *gorm.Scope) {
 func updateTsForUpdateCallback(scope *gorm.Scope) {
 	if !scope.HasError() {
 		// 注意：必须修改update_interface才能确保后续callback取到子表值
-		now := time.Now()
+		now := tsNow()
 		if v, ok := scope.InstanceGet("gorm:update_interface"); ok {
 			newScope := scope.New(v)
 			if field, ok := newScope.FieldByName("Ts"); ok {
@@ -258,9 +289,10 @@ func createCallback(scope *gorm.Scope) {
 				}
 			}
 			auth := GetAuthProcessorDesc(scope, desc)
+			auth.Action = AuthActionCreate
 			auth.OrgID = orgID
 			auth.CreatedByID = createdByID
-			if err := ActiveAuthProcessor.AllowCreate(auth); err != nil {
+			if err := GetAuthProcessor(auth.Meta).AllowCreate(auth); err != nil {
 				_ = scope.Err(err)
 				return
 			}
@@ -279,7 +311,8 @@ func deleteCallback(scope *gorm.Scope) {
 		var desc *PrivilegesDesc
 		if desc = GetRoutinePrivilegesDesc(); desc.IsValid() {
 			auth := GetAuthProcessorDesc(scope, desc)
-			if err := ActiveAuthProcessor.AddWritableWheres(auth); err != nil {
+			auth.Action = AuthActionDelete
+			if err := GetAuthProcessor(auth.Meta).AddWritableWheres(auth); err != nil {
 				_ = scope.Err(err)
 				return
 			}
@@ -347,7 +380,8 @@ func updateCallback(scope *gorm.Scope) {
 		if desc := GetRoutinePrivilegesDesc(); desc.IsValid() {
 			// 添加可写权限控制
 			auth := GetAuthProcessorDesc(scope, desc)
-			if err := ActiveAuthProcessor.AddWritableWheres(auth); err != nil {
+			auth.Action = AuthActionUpdate
+			if err := GetAuthProcessor(auth.Meta).AddWritableWheres(auth); err != nil {
 				_ = scope.Err(err)
 				return
 			}
@@ -366,7 +400,7 @@ func afterSaveCallback(scope *gorm.Scope) {
 		}
 		// 判断是否写入
 		if scope.DB().RowsAffected < 1 {
-			WARN("未新增或修改任何记录，请检查更新条件或数据权限")
+			WARN("未新增或修改任何记录，请检查更新条件或数据权限（可通过 GET /auth/explain 诊断）")
 			return
 		}
 	}
@@ -376,7 +410,8 @@ func queryCallback(scope *gorm.Scope) {
 	if !scope.HasError() {
 		if desc := GetRoutinePrivilegesDesc(); desc.IsValid() {
 			auth := GetAuthProcessorDesc(scope, desc)
-			if err := ActiveAuthProcessor.AddReadableWheres(auth); err != nil {
+			auth.Action = AuthActionRead
+			if err := GetAuthProcessor(auth.Meta).AddReadableWheres(auth); err != nil {
 				_ = scope.Err(err)
 				return
 			}
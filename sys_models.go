@@ -30,7 +30,7 @@ type Model struct {
 	UpdatedByID uint        `name:"修改人ID（默认字段）"`
 	DeletedByID uint        `name:"删除人ID（默认字段）"`
 	Remark      null.String `name:"备注" gorm:"text"`
-	Ts          time.Time   `name:"时间戳"`
+	Ts          time.Time   `name:"时间戳" gorm:"precision:6"`
 	Org         *Org        `gorm:"association_autoupdate:false;association_autocreate:false;foreignkey:id;association_foreignkey:org_id"`
 	CreatedBy   *User       `gorm:"association_autoupdate:false;association_autocreate:false;foreignkey:id;association_foreignkey:created_by_id"`
 	UpdatedBy   *User       `gorm:"association_autoupdate:false;association_autocreate:false;foreignkey:id;association_foreignkey:updated_by_id"`
@@ -46,7 +46,7 @@ type ModelExDel struct {
 	CreatedByID uint        `name:"创建人ID（默认字段）"`
 	UpdatedByID uint        `name:"修改人ID（默认字段）"`
 	Remark      null.String `name:"备注" gorm:"text"`
-	Ts          time.Time   `name:"时间戳"`
+	Ts          time.Time   `name:"时间戳" gorm:"precision:6"`
 	Org         *Org        `gorm:"association_autoupdate:false;association_autocreate:false;foreignkey:id;association_foreignkey:org_id"`
 	CreatedBy   *User       `gorm:"association_autoupdate:false;association_autocreate:false;foreignkey:id;association_foreignkey:created_by_id"`
 	UpdatedBy   *User       `gorm:"association_autoupdate:false;association_autocreate:false;foreignkey:id;association_foreignkey:updated_by_id"`
@@ -62,7 +62,7 @@ type ModelExOrg struct {
 	UpdatedByID uint        `name:"修改人ID（默认字段）"`
 	DeletedByID uint        `name:"删除人ID（默认字段）"`
 	Remark      null.String `name:"备注" gorm:"text"`
-	Ts          time.Time   `name:"时间戳"`
+	Ts          time.Time   `name:"时间戳" gorm:"precision:6"`
 	CreatedBy   *User       `gorm:"association_autoupdate:false;association_autocreate:false;foreignkey:id;association_foreignkey:created_by_id"`
 	UpdatedBy   *User       `gorm:"association_autoupdate:false;association_autocreate:false;foreignkey:id;association_foreignkey:updated_by_id"`
 	DeletedBy   *User       `gorm:"association_autoupdate:false;association_autocreate:false;foreignkey:id;association_foreignkey:deleted_by_id"`
@@ -90,7 +90,7 @@ type User struct {
 	UpdatedByID uint       `name:"修改人ID（默认字段）"`
 	DeletedByID uint       `name:"删除人ID（默认字段）"`
 	Remark      string     `name:"备注" gorm:"text"`
-	Ts          time.Time  `name:"时间戳"`
+	Ts          time.Time  `name:"时间戳" gorm:"precision:6"`
 	Org         *Org       `gorm:"association_autoupdate:false;association_autocreate:false;foreignkey:id;association_foreignkey:org_id"`
 	CreatedBy   *User      `gorm:"association_autoupdate:false;association_autocreate:false;foreignkey:id;association_foreignkey:created_by_id"`
 	UpdatedBy   *User      `gorm:"association_autoupdate:false;association_autocreate:false;foreignkey:id;association_foreignkey:updated_by_id"`
@@ -112,6 +112,20 @@ type User struct {
 	DenyLogin              null.Bool    `name:"禁止登录"`
 	ActOrgID               uint         `name:"当前组织"`
 	LastChangePasswordTime *time.Time   `name:"最后修改密码时间"`
+	ForceChangePassword    null.Bool    `name:"下次登录强制修改密码"`
+	FailedLoginTimes       int          `name:"连续登录失败次数"`
+	LastFailedLoginAt      *time.Time   `name:"最后登录失败时间"`
+	LockedUntil            *time.Time   `name:"锁定截止时间"`
+	LockCount              int          `name:"累计锁定次数"`
+	Source                 string       `name:"账号来源（为空表示本地账号，LDAP表示目录同步）"`
+	SourceID               string       `name:"来源系统中的账号标识（如目录DN）"`
+}
+
+// PasswordHistory
+type PasswordHistory struct {
+	gorm.Model `displayName:"历史密码"`
+	UID        uint   `name:"用户ID" gorm:"INDEX:kuu_password_history_uid"`
+	Password   string `name:"密码" json:"-"`
 }
 
 // GetSubDocIDs
@@ -161,17 +175,52 @@ func (u *User) DelSubDocID(signType string) error {
 // BeforeSave
 func (u *User) BeforeSave(scope *gorm.Scope) (err error) {
 	if len(u.Password) == 32 {
+		if err = GetPasswordPolicy().CheckHashed(scope.NewDB(), u.ID, u.Password); err != nil {
+			return
+		}
 		var hashed string
 		if hashed, err = GenerateFromPassword(u.Password); err == nil {
 			err = scope.SetColumn("Password", hashed)
 		}
+		scope.InstanceSet("kuu:password_changed", hashed)
+		if u.ID != 0 {
+			scope.InstanceSet("kuu:revoke_sessions", true)
+		}
 	}
 	if u.ID != 0 {
+		if u.Disable.Valid && u.Disable.Bool {
+			scope.InstanceSet("kuu:revoke_tokens", true)
+		}
 		DelCache(fmt.Sprintf("user_%d", u.ID))
 	}
 	return
 }
 
+// AfterSave 记录历史密码，修改密码或禁用用户时强制退出所有会话
+func (u *User) AfterSave(scope *gorm.Scope) (err error) {
+	if u.ID == 0 {
+		return
+	}
+	if v, ok := scope.InstanceGet("kuu:password_changed"); ok {
+		if err = SavePasswordHistory(scope.NewDB(), u.ID, v.(string)); err != nil {
+			return
+		}
+	}
+	if _, ok := scope.InstanceGet("kuu:revoke_tokens"); ok {
+		_, err = RevokeUserTokens(scope.NewDB(), u.ID)
+	} else if _, ok := scope.InstanceGet("kuu:revoke_sessions"); ok {
+		// 保留发起修改的当前会话
+		var exceptTokens []string
+		if raw, _ := GetGLSValue(GLSSignInfoKey); !IsBlank(raw) {
+			if sign, ok := raw.(*SignContext); ok && sign.Token != "" {
+				exceptTokens = append(exceptTokens, sign.Token)
+			}
+		}
+		_, err = RevokeUserSessions(scope.NewDB(), u.ID, exceptTokens...)
+	}
+	return
+}
+
 // AfterDelete
 func (u *User) AfterDelete() {
 	if u.ID != 0 {
@@ -192,7 +241,7 @@ type Org struct {
 	UpdatedByID uint       `name:"修改人ID（默认字段）"`
 	DeletedByID uint       `name:"删除人ID（默认字段）"`
 	Remark      string     `name:"备注" gorm:"text"`
-	Ts          time.Time  `name:"时间戳"`
+	Ts          time.Time  `name:"时间戳" gorm:"precision:6"`
 	Org         *Org       `gorm:"association_autoupdate:false;association_autocreate:false;foreignkey:id;association_foreignkey:org_id"`
 	CreatedBy   *User      `gorm:"association_autoupdate:false;association_autocreate:false;foreignkey:id;association_foreignkey:created_by_id"`
 	UpdatedBy   *User      `gorm:"association_autoupdate:false;association_autocreate:false;foreignkey:id;association_foreignkey:updated_by_id"`
@@ -270,10 +319,14 @@ func FillOrgFullInfo(list []Org) []Org {
 type RoleAssign struct {
 	ModelExOrg `displayName:"用户角色分配"`
 	ExtendField
-	UserID     uint `name:"用户ID" gorm:"not null"`
-	RoleID     uint `name:"角色ID" gorm:"not null"`
-	Role       *Role
-	ExpireUnix int64
+	UserID      uint `name:"用户ID" gorm:"not null"`
+	RoleID      uint `name:"角色ID" gorm:"not null"`
+	Role        *Role
+	StartUnix   int64  `name:"生效时间戳（0表示立即生效）"`
+	ExpireUnix  int64  `name:"过期时间戳（0表示永久有效）"`
+	Source      string `name:"分配来源（为空表示手工分配，LDAP表示目录同步，DELEGATION表示用户委托）"`
+	DelegatorID uint   `name:"委托人ID"`
+	NoticeUnix  int64  `name:"到期提醒时间戳"`
 }
 
 // Role
@@ -289,7 +342,7 @@ type Role struct {
 	UpdatedByID uint       `name:"修改人ID（默认字段）"`
 	DeletedByID uint       `name:"删除人ID（默认字段）"`
 	Remark      string     `name:"备注" gorm:"text"`
-	Ts          time.Time  `name:"时间戳"`
+	Ts          time.Time  `name:"时间戳" gorm:"precision:6"`
 	Org         *Org       `gorm:"association_autoupdate:false;association_autocreate:false;foreignkey:id;association_foreignkey:org_id"`
 	CreatedBy   *User      `gorm:"association_autoupdate:false;association_autocreate:false;foreignkey:id;association_foreignkey:created_by_id"`
 	UpdatedBy   *User      `gorm:"association_autoupdate:false;association_autocreate:false;foreignkey:id;association_foreignkey:updated_by_id"`
@@ -300,7 +353,9 @@ type Role struct {
 	Name                string                `name:"角色名称" gorm:"not null"`
 	OperationPrivileges []OperationPrivileges `name:"角色操作权限"`
 	DataPrivileges      []DataPrivileges      `name:"角色数据权限"`
+	Parents             []RoleParent          `name:"父角色（继承父角色的操作权限和数据权限）"`
 	IsBuiltIn           null.Bool             `name:"是否内置"`
+	RequireTwoFactor    null.Bool             `name:"是否要求双因素认证"`
 }
 
 // OperationPrivileges
@@ -494,8 +549,16 @@ type File struct {
 	Name string `name:"文件名称" json:"name" gorm:"not null"`
 	URL  string `name:"文件下载路径" json:"url" gorm:"not null"`
 	Path string `name:"文件存储路径" json:"path" gorm:"not null"`
+	// 异步生成的文件（如导出）在完成前为pending，失败时为failed，为空表示可用
+	Status string `name:"文件状态" json:"status"`
 }
 
+// 文件状态
+const (
+	FileStatusPending = "pending"
+	FileStatusFailed  = "failed"
+)
+
 // BeforeCreate
 func (f *File) BeforeCreate() {
 	if f.UID == "" {
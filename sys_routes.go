@@ -16,6 +16,7 @@ import (
 
 	"github.com/ghodss/yaml"
 	"github.com/gin-gonic/gin/binding"
+	"github.com/jinzhu/gorm"
 	"gopkg.in/guregu/null.v3"
 )
 
@@ -308,8 +309,9 @@ var ChangePassword = RouteInfo{
 	},
 	HandlerFunc: func(c *Context) *STDReply {
 		var body = struct {
-			OldPasswd string `binding:"required"`
-			NewPasswd string `binding:"required"`
+			OldPasswd      string `binding:"required"`
+			NewPasswd      string `binding:"required"`
+			NewPasswdPlain string // 明文新密码，启用密码复杂度策略时必填
 		}{}
 		if err := c.ShouldBindBodyWith(&body, binding.JSON); err != nil {
 			return c.STDErr(err, "parse_body_failed")
@@ -323,16 +325,24 @@ var ChangePassword = RouteInfo{
 		if err := CompareHashAndPassword(user.Password, body.OldPasswd); err != nil {
 			return c.STDErr(err, "oldpasswd_error")
 		}
-		passwd, err := GenerateFromPassword(strings.ToLower(body.NewPasswd))
+		err := WithTransaction(func(tx *gorm.DB) error {
+			if err := ValidateNewPassword(tx, user.ID, body.NewPasswd, body.NewPasswdPlain); err != nil {
+				return err
+			}
+			// 新密码不能与当前密码相同
+			if CompareHashAndPassword(user.Password, strings.ToLower(body.NewPasswd)) == nil && (user.ForceChangePassword.Bool || GetPasswordPolicy().HistoryCount > 0) {
+				return NewIntlError(errors.New("new password is the same as the old one"), "password_policy_same_as_old", "New password cannot be the same as the old one.")
+			}
+			if err := ChangeUserPassword(tx, user.ID, body.NewPasswd, false); err != nil {
+				return err
+			}
+			// 修改密码后强制退出其他会话
+			_, err := RevokeUserSessions(tx, user.ID, c.SignInfo.Token)
+			return err
+		})
 		if err != nil {
 			return c.STDErr(err, "newpasswd_error")
 		}
-		DB().Model(&User{}).
-			Where("id = ?", c.PrisDesc.UID).
-			Updates(map[string]any{
-				"Password":               passwd,
-				"LastChangePasswordTime": time.Now(),
-			})
 		return c.STDOK()
 	},
 }
@@ -518,7 +528,7 @@ var CaptchaRoute = RouteInfo{
 			valid bool
 		)
 		if user != "" {
-			times := GetCacheInt(getFailedTimesKey(user))
+			times := getLoginFailures(getFailedTimesKey(user), GetLockoutPolicy().window()).Count
 			valid = failedTimesValid(times)
 		}
 		if valid == false {
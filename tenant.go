@@ -0,0 +1,465 @@
+package kuu
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jinzhu/gorm"
+	"github.com/jtolds/gls"
+	"gopkg.in/guregu/null.v3"
+)
+
+// 租户识别方式
+const (
+	TenantResolverHeader    = "header"
+	TenantResolverSubdomain = "subdomain"
+	TenantResolverClaim     = "claim"
+)
+
+var (
+	ErrTenantRequired     = errors.New("tenant is required")
+	ErrTenantNotFound     = errors.New("tenant not found")
+	ErrTenantDisabled     = errors.New("tenant is disabled")
+	ErrTenantCodeInvalid  = errors.New("invalid tenant code")
+	ErrTenantNotEnabled   = errors.New("tenant mode is not enabled")
+	ErrTenantRootPassword = errors.New("tenant root password is required")
+)
+
+var (
+	tenantCodeRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]{1,31}$`)
+	tenantOpenMu     sync.Mutex
+)
+
+// Tenant 租户，每个租户使用独立的数据库，租户信息保存在默认数据源中
+type Tenant struct {
+	gorm.Model `displayName:"租户"`
+	Code       string    `name:"租户编码" gorm:"not null;unique_index:kuu_tenant_code"`
+	Name       string    `name:"租户名称"`
+	Dialect    string    `name:"数据库类型"`
+	Database   string    `name:"数据库名称"`
+	Args       string    `name:"连接参数" gorm:"type:text" json:"-"`
+	Disabled   null.Bool `name:"是否禁用"`
+}
+
+// TableName
+func (Tenant) TableName() string {
+	return "sys_tenants"
+}
+
+// DataSourceName 租户数据源名称
+func (t *Tenant) DataSourceName() string {
+	return TenantDataSourceName(t.Code)
+}
+
+// TenantDataSourceName 租户数据源名称
+func TenantDataSourceName(code string) string {
+	return fmt.Sprintf("tenant_%s", code)
+}
+
+// TenantConfig 多租户配置，对应配置项tenant
+type TenantConfig struct {
+	Enabled        bool   `json:"enabled"`
+	Resolver       string `json:"resolver"`       // 租户识别方式：subdomain（默认）、claim、header（仅限网关设置请求头的部署）
+	Header         string `json:"header"`         // 请求头名称，默认X-Tenant
+	Claim          string `json:"claim"`          // 令牌载荷中的属性名，默认Tenant
+	Domain         string `json:"domain"`         // 子域名识别时的主域名，如example.com
+	Required       bool   `json:"required"`       // 为true时拒绝未识别到租户的请求，否则使用默认数据源
+	Dialect        string `json:"dialect"`        // 租户数据库类型，默认与默认数据源一致
+	AdminArgs      string `json:"adminArgs"`      // 用于创建租户数据库的连接参数
+	ArgsTemplate   string `json:"argsTemplate"`   // 租户数据源连接参数模板，{{database}}将替换为数据库名称
+	DatabasePrefix string `json:"databasePrefix"` // 租户数据库名称前缀，默认kuu_tenant_
+}
+
+// GetTenantConfig
+func GetTenantConfig() TenantConfig {
+	var config TenantConfig
+	C().GetInterface("tenant", &config)
+	if config.Resolver == "" {
+		config.Resolver = TenantResolverSubdomain
+	}
+	if config.Header == "" {
+		config.Header = "X-Tenant"
+	}
+	if config.Claim == "" {
+		config.Claim = "Tenant"
+	}
+	if config.DatabasePrefix == "" {
+		config.DatabasePrefix = "kuu_tenant_"
+	}
+	return config
+}
+
+// DatabaseName 租户数据库名称
+func (config TenantConfig) DatabaseName(code string) string {
+	return config.DatabasePrefix + code
+}
+
+// DataSourceArgs 租户数据源连接参数
+func (config TenantConfig) DataSourceArgs(database string) string {
+	return strings.ReplaceAll(config.ArgsTemplate, "{{database}}", database)
+}
+
+// TenantResolver 从请求中识别租户编码，可替换为自定义实现
+// header方式直接信任客户端提交的请求头，仅适用于由网关根据域名或身份设置该请求头（并清除客户端传入值）的部署
+var TenantResolver = func(c *Context, config TenantConfig) string {
+	switch config.Resolver {
+	case TenantResolverHeader:
+		return strings.ToLower(strings.TrimSpace(c.GetHeader(config.Header)))
+	case TenantResolverClaim:
+		token := c.Token()
+		if token == "" {
+			return ""
+		}
+		// 此处只读取租户，签名在租户数据源中校验
+		claims := jwt.MapClaims{}
+		if _, _, err := new(jwt.Parser).ParseUnverified(token, claims); err != nil {
+			return ""
+		}
+		if v, ok := claims[config.Claim].(string); ok {
+			return strings.ToLower(v)
+		}
+		return ""
+	default:
+		return tenantFromHost(c.Request.Host, config.Domain)
+	}
+}
+
+// tenantFromHost 从主机名中解析子域名，如acme.example.com解析为acme
+func tenantFromHost(host, domain string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+	if domain == "" || !strings.HasSuffix(host, "."+domain) {
+		return ""
+	}
+	sub := strings.TrimSuffix(host, "."+domain)
+	if strings.Contains(sub, ".") {
+		return ""
+	}
+	return sub
+}
+
+// initTenants 启用多租户时迁移租户表
+func initTenants() {
+	if !GetTenantConfig().Enabled {
+		return
+	}
+	if err := DS("").AutoMigrate(&Tenant{}).Error; err != nil {
+		PANIC("failed to migrate tenants: %s", err.Error())
+	}
+}
+
+// GetRoutineTenant 查询当前协程绑定的租户编码
+func GetRoutineTenant() string {
+	if raw, ok := GetGLSValue(GLSTenantKey); ok && raw != nil {
+		return raw.(string)
+	}
+	return ""
+}
+
+// TenantMiddleware 识别请求所属租户并绑定租户数据源
+func TenantMiddleware(c *Context) *STDReply {
+	config := GetTenantConfig()
+	code := TenantResolver(c, config)
+	if code == "" {
+		if config.Required && !c.InWhitelist() {
+			return c.AbortErrWithCode(ErrTenantRequired, 559, "tenant_required", "Tenant is required")
+		}
+		return nil
+	}
+	name, err := OpenTenantDataSource(code)
+	if err != nil {
+		return c.AbortErrWithCode(err, 559, "tenant_unavailable", "Tenant is unavailable")
+	}
+	SetGLSValues(gls.Values{GLSTenantKey: code, GLSDataSourceKey: name}, c.Next)
+	return nil
+}
+
+// OpenTenantDataSource 打开租户数据源（已打开时直接返回）
+func OpenTenantDataSource(code string) (string, error) {
+	if !tenantCodeRegexp.MatchString(code) {
+		return "", fmt.Errorf("%w: %s", ErrTenantCodeInvalid, code)
+	}
+	name := TenantDataSourceName(code)
+	if HasDataSource(name) {
+		return name, nil
+	}
+	tenantOpenMu.Lock()
+	defer tenantOpenMu.Unlock()
+	if HasDataSource(name) {
+		return name, nil
+	}
+	var tenant Tenant
+	if err := DS("").Where(&Tenant{Code: code}).First(&tenant).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return "", fmt.Errorf("%w: %s", ErrTenantNotFound, code)
+		}
+		return "", err
+	}
+	if tenant.Disabled.Bool {
+		return "", fmt.Errorf("%w: %s", ErrTenantDisabled, code)
+	}
+	if err := RegisterDataSource(name, tenant.Dialect, tenant.Args); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// WithTenant 在租户数据源上执行fn，可用于定时任务等非请求场景
+func WithTenant(code string, fn func() error) (err error) {
+	name, err := OpenTenantDataSource(code)
+	if err != nil {
+		return err
+	}
+	SetGLSValues(gls.Values{GLSTenantKey: code, GLSDataSourceKey: name}, func() {
+		err = fn()
+	})
+	return
+}
+
+// EachTenant 依次在默认数据源和全部启用的租户数据源上执行fn（code为空表示默认数据源），可用于定时任务，
+// 单个租户执行失败不影响其他租户
+func EachTenant(fn func(code string) error) error {
+	var errs []string
+	if err := fn(""); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if GetTenantConfig().Enabled {
+		var tenants []Tenant
+		if err := DS("").Order("id").Find(&tenants).Error; err != nil {
+			errs = append(errs, err.Error())
+		}
+		for _, tenant := range tenants {
+			if tenant.Disabled.Bool {
+				continue
+			}
+			code := tenant.Code
+			if err := WithTenant(code, func() error { return fn(code) }); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", code, err.Error()))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// MigrateDataSource 在指定数据源上迁移全部已导入模块的模型，首次迁移时执行模块初始化，并导入种子数据
+func MigrateDataSource(name string) error {
+	return WithDataSource(name, func() error {
+		for _, mod := range modList {
+			for _, model := range mod.Models {
+				// 绑定了独立数据源的模型不属于租户库
+				if ModelDataSource(model) != "" {
+					continue
+				}
+				if err := DB().AutoMigrate(model).Error; err != nil {
+					return err
+				}
+				if v, ok := model.(DBTypeRepairer); ok {
+					v.RepairDBTypes()
+				}
+			}
+		}
+		var codes []string
+		for _, mod := range modList {
+			if mod.DataSource == "" {
+				codes = append(codes, mod.Code)
+			}
+		}
+		if len(codes) > 0 {
+			if _, err := MigrateUp(MigrateOptions{Mods: codes}); err != nil {
+				return err
+			}
+		}
+		if !preflight() {
+			for _, mod := range modList {
+				if mod.OnInit != nil {
+					if err := mod.OnInit(); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		if len(codes) > 0 {
+			if _, err := LoadSeeds(SeedOptions{Mods: codes}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// createTenantDatabase 创建租户数据库
+func createTenantDatabase(config TenantConfig, database string) error {
+	if config.Dialect == "sqlite3" {
+		// SQLite在打开时自动创建数据库文件
+		return nil
+	}
+	admin, err := gorm.Open(config.Dialect, config.AdminArgs)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+	switch config.Dialect {
+	case "mysql":
+		return admin.Exec(fmt.Sprintf("CREATE DATABASE IF NOT EXISTS `%s` DEFAULT CHARACTER SET utf8mb4", database)).Error
+	case "postgres":
+		var count int
+		if err := admin.Raw("SELECT count(*) FROM pg_database WHERE datname = ?", database).Row().Scan(&count); err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+		return admin.Exec(fmt.Sprintf(`CREATE DATABASE "%s"`, database)).Error
+	}
+	return fmt.Errorf("unsupported tenant dialect: %s", config.Dialect)
+}
+
+// ProvisionTenantArgs
+type ProvisionTenantArgs struct {
+	Code         string `binding:"required"`
+	Name         string `binding:"required"`
+	RootPassword string `binding:"required"` // 租户root用户的密码（MD5）
+}
+
+// ProvisionTenant 创建租户数据库、执行迁移和初始化，并保存租户信息
+func ProvisionTenant(args ProvisionTenantArgs) (*Tenant, error) {
+	config := GetTenantConfig()
+	if !config.Enabled {
+		return nil, ErrTenantNotEnabled
+	}
+	args.Code = strings.ToLower(args.Code)
+	if !tenantCodeRegexp.MatchString(args.Code) {
+		return nil, fmt.Errorf("%w: %s", ErrTenantCodeInvalid, args.Code)
+	}
+	// 租户数据库不能保留内置的root默认密码
+	if args.RootPassword == "" {
+		return nil, ErrTenantRootPassword
+	}
+	if config.Dialect == "" {
+		config.Dialect = DS("").Dialect().GetName()
+	}
+	tenant := Tenant{
+		Code:     args.Code,
+		Name:     args.Name,
+		Dialect:  config.Dialect,
+		Database: config.DatabaseName(args.Code),
+	}
+	tenant.Args = config.DataSourceArgs(tenant.Database)
+	if err := createTenantDatabase(config, tenant.Database); err != nil {
+		return nil, err
+	}
+	// 初始化期间使用临时数据源，设置root密码前租户数据源无法通过请求访问
+	name := tenant.DataSourceName() + "_provisioning"
+	if err := RegisterDataSource(name, tenant.Dialect, tenant.Args); err != nil {
+		return nil, err
+	}
+	defer unregisterDataSource(name)
+	if err := MigrateDataSource(name); err != nil {
+		return nil, err
+	}
+	err := WithDataSource(name, func() error {
+		return ChangeUserPassword(DB(), RootUID(), args.RootPassword, false)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := DS("").Create(&tenant).Error; err != nil {
+		return nil, err
+	}
+	return &tenant, nil
+}
+
+// checkTenantAdmin 租户管理接口只允许默认数据源的root用户调用
+func checkTenantAdmin(c *Context) error {
+	if c.SignInfo.UID != RootUID() || GetRoutineTenant() != "" {
+		return fmt.Errorf("unauthorized operation: uid=%v, tenant=%s", c.SignInfo.UID, GetRoutineTenant())
+	}
+	return nil
+}
+
+// TenantProvisionRoute
+var TenantProvisionRoute = RouteInfo{
+	Name:   "创建租户（创建数据库并执行迁移）",
+	Method: http.MethodPost,
+	Path:   "/tenants",
+	IntlMessages: map[string]string{
+		"tenant_provision_failed": "Failed to provision tenant",
+		"login_as_unauthorized":   "Unauthorized operation",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		if err := checkTenantAdmin(c); err != nil {
+			return c.STDErr(err, "login_as_unauthorized")
+		}
+		var body ProvisionTenantArgs
+		if err := c.ShouldBindJSON(&body); err != nil {
+			return c.STDErr(err, "tenant_provision_failed")
+		}
+		IgnoreAuth()
+		tenant, err := ProvisionTenant(body)
+		IgnoreAuth(true)
+		if err != nil {
+			return c.STDErr(err, "tenant_provision_failed")
+		}
+		return c.STD(tenant)
+	},
+}
+
+// TenantsRoute
+var TenantsRoute = RouteInfo{
+	Name:   "查询租户列表",
+	Method: http.MethodGet,
+	Path:   "/tenants",
+	IntlMessages: map[string]string{
+		"tenants_failed":        "Failed to query tenants",
+		"login_as_unauthorized": "Unauthorized operation",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		if err := checkTenantAdmin(c); err != nil {
+			return c.STDErr(err, "login_as_unauthorized")
+		}
+		var list []Tenant
+		if err := DS("").Order("id").Find(&list).Error; err != nil {
+			return c.STDErr(err, "tenants_failed")
+		}
+		return c.STD(list)
+	},
+}
+
+// TenantMigrateRoute
+var TenantMigrateRoute = RouteInfo{
+	Name:   "对租户数据库执行迁移",
+	Method: http.MethodPost,
+	Path:   "/tenants/:code/migrate",
+	IntlMessages: map[string]string{
+		"tenant_migrate_failed": "Failed to migrate tenant database",
+		"login_as_unauthorized": "Unauthorized operation",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		if err := checkTenantAdmin(c); err != nil {
+			return c.STDErr(err, "login_as_unauthorized")
+		}
+		name, err := OpenTenantDataSource(strings.ToLower(c.Param("code")))
+		if err != nil {
+			return c.STDErr(err, "tenant_migrate_failed")
+		}
+		IgnoreAuth()
+		err = MigrateDataSource(name)
+		IgnoreAuth(true)
+		if err != nil {
+			return c.STDErr(err, "tenant_migrate_failed")
+		}
+		return c.STDOK()
+	},
+}
@@ -0,0 +1,102 @@
+package kuu
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jtolds/gls"
+)
+
+func TestTenantFromHost(t *testing.T) {
+	cases := map[string]string{
+		"acme.example.com":      "acme",
+		"ACME.example.com:8080": "acme",
+		"example.com":           "",
+		"a.b.example.com":       "",
+		"acme.other.com":        "",
+	}
+	for host, expected := range cases {
+		if v := tenantFromHost(host, "example.com"); v != expected {
+			t.Errorf("%s: got %q, expected %q", host, v, expected)
+		}
+	}
+	if v := tenantFromHost("acme.example.com", ""); v != "" {
+		t.Errorf("expected empty tenant without domain, got %q", v)
+	}
+}
+
+func TestTenantConfig(t *testing.T) {
+	config := TenantConfig{DatabasePrefix: "kuu_tenant_", ArgsTemplate: "root:pwd@tcp(127.0.0.1:3306)/{{database}}?parseTime=true"}
+	database := config.DatabaseName("acme")
+	if database != "kuu_tenant_acme" {
+		t.Errorf("unexpected database: %s", database)
+	}
+	if v := config.DataSourceArgs(database); v != "root:pwd@tcp(127.0.0.1:3306)/kuu_tenant_acme?parseTime=true" {
+		t.Errorf("unexpected args: %s", v)
+	}
+	for code, valid := range map[string]bool{"acme": true, "acme_01": true, "a": false, "1acme": false, "acme-x": false, "Acme": false} {
+		if tenantCodeRegexp.MatchString(code) != valid {
+			t.Errorf("%s: expected valid=%v", code, valid)
+		}
+	}
+}
+
+func TestTenantCacheKey(t *testing.T) {
+	if v := tenantCacheKey("user_1"); v != "user_1" {
+		t.Errorf("unexpected key: %s", v)
+	}
+	SetGLSValues(gls.Values{GLSDataSourceKey: TenantDataSourceName("acme")}, func() {
+		if v := tenantCacheKey("user_1"); v != "tenant_acme_user_1" {
+			t.Errorf("unexpected tenant key: %s", v)
+		}
+	})
+
+	// 后缀和包含查询仅返回当前租户的缓存
+	suffix := fmt.Sprintf("_tenant_cache_%d", time.Now().UnixNano())
+	for _, tenant := range []string{"acme", "other"} {
+		SetGLSValues(gls.Values{GLSDataSourceKey: TenantDataSourceName(tenant)}, func() {
+			SetCacheString("key"+suffix, tenant)
+		})
+	}
+	defer DelCache("tenant_acme_key"+suffix, "tenant_other_key"+suffix)
+	SetGLSValues(gls.Values{GLSDataSourceKey: TenantDataSourceName("acme")}, func() {
+		for name, values := range map[string]map[string]string{
+			"HasSuffixCache": HasSuffixCache(suffix, 10),
+			"ContainsCache":  ContainsCache(suffix, 10),
+		} {
+			if len(values) != 1 {
+				t.Errorf("%s: expected only the current tenant, got %v", name, values)
+			}
+			for _, v := range values {
+				if v != "acme" {
+					t.Errorf("%s: unexpected value: %v", name, values)
+				}
+			}
+		}
+	})
+	if values := ContainsCache(suffix, 10); len(values) != 2 {
+		t.Errorf("default data source should see all keys, got %v", values)
+	}
+}
+
+func TestTenantResolver(t *testing.T) {
+	config := GetTenantConfig()
+	if config.Resolver != TenantResolverSubdomain {
+		t.Fatalf("unexpected default resolver: %s", config.Resolver)
+	}
+	config.Domain = "example.com"
+	ginCtx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ginCtx.Request = httptest.NewRequest("GET", "http://acme.example.com/api/user", nil)
+	ginCtx.Request.Header.Set(config.Header, "other")
+	c := &Context{Context: ginCtx}
+	if v := TenantResolver(c, config); v != "acme" {
+		t.Errorf("expected tenant from host, got %q", v)
+	}
+	config.Resolver = TenantResolverHeader
+	if v := TenantResolver(c, config); v != "other" {
+		t.Errorf("expected tenant from header, got %q", v)
+	}
+}
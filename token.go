@@ -4,12 +4,18 @@ import (
 	"errors"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin/binding"
+	"github.com/jinzhu/gorm"
 	uuid "github.com/satori/go.uuid"
 	"gopkg.in/guregu/null.v3"
 	"strings"
 	"time"
 )
 
+var (
+	ErrRefreshTokenInvalid = errors.New("refresh token invalid or expired")
+	ErrRefreshTokenReused  = errors.New("refresh token reused, token family revoked")
+)
+
 type GenTokenDesc struct {
 	UID          uint
 	Username     string
@@ -18,7 +24,22 @@ type GenTokenDesc struct {
 	Desc         string
 	Payload      jwt.MapClaims
 	IsAPIKey     bool
-	ForcePayload bool // 是否强制使用Payload参数值作为jwt的payload
+	ForcePayload bool   // 是否强制使用Payload参数值作为jwt的payload
+	RefreshExp   int64  // 刷新令牌过期时间戳，为0时不签发刷新令牌
+	Family       string // 令牌族ID，刷新轮换时沿用
+	IP           string // 登录IP
+	UserAgent    string // 登录设备
+	APIKeyScope  *APIKeyScope
+}
+
+// AccessTokenExpiresSeconds 访问令牌有效期（秒）
+func AccessTokenExpiresSeconds() int {
+	return C().DefaultGetInt("tokenExpiresSeconds", ExpiresSeconds)
+}
+
+// RefreshTokenExpiresSeconds 刷新令牌有效期（秒），为0时不签发刷新令牌
+func RefreshTokenExpiresSeconds() int {
+	return C().DefaultGetInt("refreshTokenExpiresSeconds", RefreshExpiresSeconds)
 }
 
 // GenToken
@@ -47,17 +68,34 @@ func GenToken(desc GenTokenDesc) (secretData *SignSecret, err error) {
 	}
 	// 生成新密钥
 	secretData = &SignSecret{
-		UID:      desc.UID,
-		Username: desc.Username,
-		Secret:   strings.ReplaceAll(uuid.NewV4().String(), "-", ""),
-		Iat:      iat,
-		Exp:      desc.Exp,
-		Method:   SignMethodLogin,
-		SubDocID: subDocID,
-		Payload:  JSONStringify(desc.Payload),
-		Desc:     desc.Desc,
-		Type:     desc.Type,
-		IsAPIKey: null.NewBool(desc.IsAPIKey, true),
+		UID:        desc.UID,
+		Username:   desc.Username,
+		Secret:     strings.ReplaceAll(uuid.NewV4().String(), "-", ""),
+		Iat:        iat,
+		Exp:        desc.Exp,
+		Method:     SignMethodLogin,
+		SubDocID:   subDocID,
+		Payload:    JSONStringify(desc.Payload),
+		Desc:       desc.Desc,
+		Type:       desc.Type,
+		IsAPIKey:   null.NewBool(desc.IsAPIKey, true),
+		IP:         desc.IP,
+		UserAgent:  desc.UserAgent,
+		LastSeenAt: iat,
+	}
+	if desc.IsAPIKey && !desc.APIKeyScope.IsEmpty() {
+		secretData.Scope = JSONStringify(desc.APIKeyScope)
+	}
+	// 生成刷新令牌（API Key不支持刷新）
+	if desc.RefreshExp > 0 && !desc.IsAPIKey {
+		secretData.RawRefreshToken = strings.ReplaceAll(uuid.NewV4().String(), "-", "") + strings.ReplaceAll(uuid.NewV4().String(), "-", "")
+		secretData.RefreshToken = Sha1(secretData.RawRefreshToken)
+		secretData.RefreshExp = desc.RefreshExp
+		secretData.RefreshRotated = null.BoolFrom(false)
+		secretData.Family = desc.Family
+		if secretData.Family == "" {
+			secretData.Family = strings.ReplaceAll(uuid.NewV4().String(), "-", "")
+		}
 	}
 	// 签发令牌
 	var tokenPayload jwt.MapClaims
@@ -71,12 +109,25 @@ func GenToken(desc GenTokenDesc) (secretData *SignSecret, err error) {
 			"exp": desc.Payload["exp"],
 		}
 	}
-	if signed, err := EncodedToken(tokenPayload, secretData.Secret); err != nil {
+	if config := GetJWTConfig(); config.IsAsymmetric() && !desc.IsAPIKey {
+		// 非对称签名令牌携带完整身份信息，便于其他服务离线验证
+		tokenPayload["Username"] = secretData.Username
+		tokenPayload["Type"] = secretData.Type
+		tokenPayload["SubDocID"] = secretData.SubDocID
+		if signed, err := signAsymmetricToken(config, tokenPayload); err != nil {
+			return secretData, err
+		} else {
+			secretData.Token = signed
+		}
+	} else if signed, err := EncodedToken(tokenPayload, secretData.Secret); err != nil {
 		return secretData, err
 	} else {
 		secretData.Token = signed
 	}
 	desc.Payload[TokenKey] = secretData.Token
+	if secretData.RawRefreshToken != "" {
+		desc.Payload[RefreshTokenKey] = secretData.RawRefreshToken
+	}
 	if err = DB().Create(secretData).Error; err != nil {
 		return
 	}
@@ -87,3 +138,76 @@ func GenToken(desc GenTokenDesc) (secretData *SignSecret, err error) {
 	saveHistory(secretData)
 	return
 }
+
+// RotateRefreshToken 使用刷新令牌换发新的访问令牌和刷新令牌
+//
+// 每个刷新令牌只能使用一次，已轮换的刷新令牌被再次使用时视为泄露，整个令牌族都将被吊销。
+func RotateRefreshToken(rawRefreshToken string) (secretData *SignSecret, err error) {
+	if rawRefreshToken == "" || RefreshTokenExpiresSeconds() <= 0 {
+		return nil, ErrRefreshTokenInvalid
+	}
+	var (
+		db  = DB()
+		old SignSecret
+	)
+	if err := db.Where(&SignSecret{RefreshToken: Sha1(rawRefreshToken)}).First(&old).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, ErrRefreshTokenInvalid
+		}
+		return nil, err
+	}
+	if old.RefreshRotated.Bool {
+		RevokeTokenFamily(old.Family)
+		return nil, ErrRefreshTokenReused
+	}
+	if old.Method == SignMethodLogout || old.RefreshExp < time.Now().Unix() {
+		return nil, ErrRefreshTokenInvalid
+	}
+	// 条件更新防止并发刷新时同一令牌被使用两次
+	affected := db.Model(&SignSecret{}).
+		Where("id = ?", old.ID).
+		Where("refresh_rotated = ? OR refresh_rotated IS NULL", false).
+		Updates(map[string]interface{}{
+			"refresh_rotated": true,
+			"method":          SignMethodLogout,
+		})
+	if affected.Error != nil {
+		return nil, affected.Error
+	}
+	if affected.RowsAffected < 1 {
+		RevokeTokenFamily(old.Family)
+		return nil, ErrRefreshTokenReused
+	}
+	old.Method = SignMethodLogout
+	DelCache(old.Token)
+	saveHistory(&old)
+
+	var payload jwt.MapClaims
+	if err := JSONParse(old.Payload, &payload); err != nil {
+		return nil, err
+	}
+	return GenToken(GenTokenDesc{
+		UID:        old.UID,
+		Username:   old.Username,
+		Payload:    payload,
+		Exp:        time.Now().Add(time.Second * time.Duration(AccessTokenExpiresSeconds())).Unix(),
+		Type:       old.Type,
+		RefreshExp: time.Now().Add(time.Second * time.Duration(RefreshTokenExpiresSeconds())).Unix(),
+		Family:     old.Family,
+		IP:         old.IP,
+		UserAgent:  old.UserAgent,
+	})
+}
+
+// RevokeTokenFamily 吊销同一令牌族下的所有令牌
+func RevokeTokenFamily(family string) {
+	if family == "" {
+		return
+	}
+	n, err := revokeSignSecrets(DB(), DB().Where(&SignSecret{Family: family}))
+	if err != nil {
+		ERROR(err)
+		return
+	}
+	WARN("token family revoked: family=%s, tokens=%d", family, n)
+}
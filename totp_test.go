@@ -0,0 +1,45 @@
+package kuu
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+func TestHOTP(t *testing.T) {
+	// RFC 6238 附录B测试向量（SHA1）
+	key := []byte("12345678901234567890")
+	cases := map[int64]string{
+		59:         "94287082",
+		1111111109: "07081804",
+		1111111111: "14050471",
+		1234567890: "89005924",
+		2000000000: "69279037",
+	}
+	for ts, want := range cases {
+		if got := hotp(key, uint64(ts/TOTPPeriod), 8); got != want {
+			t.Errorf("hotp(%d) = %s, want %s", ts, got, want)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111111, 0)
+	code, err := TOTPCode(secret, TOTPStep(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != "050471" {
+		t.Errorf("unexpected code: %s", code)
+	}
+	if step, ok := VerifyTOTP(secret, code, now.Add(TOTPPeriod*time.Second)); !ok || step != TOTPStep(now) {
+		t.Error("code within the skew window should be accepted")
+	}
+	if _, ok := VerifyTOTP(secret, code, now.Add(3*TOTPPeriod*time.Second)); ok {
+		t.Error("code outside the skew window should be rejected")
+	}
+	if _, ok := VerifyTOTP(GenTOTPSecret(), code, now); ok {
+		t.Error("code for another secret should be rejected")
+	}
+}
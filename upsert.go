@@ -0,0 +1,430 @@
+package kuu
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+// 批量写入结果
+const (
+	UpsertActionCreated  = "created"
+	UpsertActionUpdated  = "updated"
+	UpsertActionUpserted = "upserted" // 原生批量写入时无法区分新增和修改
+	UpsertActionFailed   = "failed"
+)
+
+var (
+	ErrUpsertKeysRequired      = errors.New("upsert keys are required")
+	ErrUpsertNativeUnsupported = errors.New("native upsert is not supported by the dialect")
+	ErrUpsertConflict          = errors.New("upsert conflicts with an existing record")
+)
+
+// upsertSavepoint 逐行写入时的保存点名称
+const upsertSavepoint = "kuu_upsert"
+
+// UpsertParams 批量写入参数
+type UpsertParams struct {
+	Keys            []string                 // 自然键字段，默认为kuu_unique索引中除删除标记外的字段
+	Docs            []map[string]interface{} `binding:"required"`
+	Native          bool                     // 使用数据库原生语法批量写入，跳过业务回调和数据权限，仅限root
+	ContinueOnError bool                     // 单行失败时回滚该行并继续处理其他行
+}
+
+// UpsertResult 单行写入结果
+type UpsertResult struct {
+	Index  int
+	ID     uint `json:",omitempty"`
+	Action string
+	Error  string `json:",omitempty"`
+}
+
+// upsertKeyFields 解析自然键字段
+func upsertKeyFields(scope *gorm.Scope, keys []string) ([]*gorm.Field, error) {
+	if len(keys) == 0 {
+		fields := recycleUniqueFields(scope)
+		if len(fields) == 0 {
+			return nil, ErrUpsertKeysRequired
+		}
+		return fields, nil
+	}
+	fields := make([]*gorm.Field, 0, len(keys))
+	for _, key := range keys {
+		field, ok := scope.FieldByName(key)
+		if !ok {
+			return nil, fmt.Errorf("unknown upsert key: %s", key)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// UpsertDocs 按自然键逐行新增或修改，每行均执行业务回调和数据权限控制
+func UpsertDocs(c *Context, tx *gorm.DB, reflectType reflect.Type, params UpsertParams) ([]UpsertResult, error) {
+	keys, err := upsertKeyFields(tx.NewScope(reflect.New(reflectType).Interface()), params.Keys)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]UpsertResult, len(params.Docs))
+	for i, raw := range params.Docs {
+		if params.ContinueOnError {
+			if err := tx.Exec("SAVEPOINT " + upsertSavepoint).Error; err != nil {
+				return results, err
+			}
+		}
+		result, err := upsertDoc(c, tx, reflectType, keys, raw)
+		result.Index = i
+		if err != nil {
+			if !params.ContinueOnError {
+				return results, fmt.Errorf("row %d: %w", i, err)
+			}
+			if err := tx.Exec("ROLLBACK TO SAVEPOINT " + upsertSavepoint).Error; err != nil {
+				return results, err
+			}
+			result.Action = UpsertActionFailed
+			result.Error = err.Error()
+		} else if params.ContinueOnError {
+			if err := tx.Exec("RELEASE SAVEPOINT " + upsertSavepoint).Error; err != nil {
+				return results, err
+			}
+		}
+		results[i] = result
+	}
+	return results, nil
+}
+
+// upsertInsertKey 逐行写入时新增语句的冲突处理，仅作用于当前写入的记录
+const upsertInsertKey = "kuu:upsert_insert"
+
+// upsertDoc 写入单行：已存在时按修改处理；不存在时以原生语法（ON CONFLICT DO NOTHING、ON DUPLICATE KEY UPDATE）新增，
+// 若期间被并发写入导致冲突，则重新查询并按修改处理
+func upsertDoc(c *Context, tx *gorm.DB, reflectType reflect.Type, keys []*gorm.Field, raw map[string]interface{}) (result UpsertResult, err error) {
+	doc := reflect.New(reflectType).Interface()
+	if err = Copy(raw, doc); err != nil {
+		return
+	}
+	var (
+		docScope = tx.NewScope(doc)
+		query    = tx
+	)
+	for _, key := range keys {
+		field, _ := docScope.FieldByName(key.Name)
+		if field.IsBlank && key.Name != "Dr" {
+			return result, fmt.Errorf("%s is required", key.Name)
+		}
+		query = query.Where(fmt.Sprintf("%v.%v = ?", docScope.QuotedTableName(), docScope.Quote(field.DBName)), field.Field.Interface())
+	}
+	existing := reflect.New(reflectType).Interface()
+	err = query.First(existing).Error
+	if gorm.IsRecordNotFoundError(err) {
+		bizScope := NewBizScope(c, doc, tx.Set(upsertInsertKey, doc)).callCallbacks(BizCreateKind)
+		if !bizScope.HasError() {
+			result.Action = UpsertActionCreated
+			result.ID, _, _ = recordVersion(doc)
+			return result, nil
+		}
+		if bizScope.DB.Error != ErrUpsertConflict {
+			return result, bizScope.DB.Error
+		}
+		// 新增时与并发写入的记录冲突
+		if err = query.First(existing).Error; gorm.IsRecordNotFoundError(err) {
+			return result, ErrUpsertConflict
+		}
+		// 新增回调可能已修改doc，按原始数据重新生成
+		if err == nil {
+			doc = reflect.New(reflectType).Interface()
+			err = Copy(raw, doc)
+		}
+	}
+	if err != nil {
+		return
+	}
+	id, _, _ := recordVersion(existing)
+	bizScope := NewBizScope(c, existing, tx)
+	bizScope.UpdateParams = &BizUpdateParams{Cond: map[string]interface{}{"ID": id}, Doc: raw}
+	bizScope.UpdateCond = existing
+	bizScope.Value = doc
+	bizScope.callCallbacks(BizUpdateKind)
+	if bizScope.HasError() {
+		return result, bizScope.DB.Error
+	}
+	result.Action = UpsertActionUpdated
+	result.ID = id
+	return result, nil
+}
+
+// upsertInsertOptionCallback 为逐行写入的新增语句追加原生冲突处理
+func upsertInsertOptionCallback(scope *gorm.Scope) {
+	if v, ok := scope.Get(upsertInsertKey); !ok || v != scope.Value || scope.HasError() {
+		return
+	}
+	switch scope.Dialect().GetName() {
+	case "mysql":
+		pk := scope.Quote(scope.PrimaryKey())
+		scope.Set("gorm:insert_option", fmt.Sprintf("ON DUPLICATE KEY UPDATE %s=%s", pk, pk))
+	case "postgres", "sqlite3":
+		scope.Set("gorm:insert_option", "ON CONFLICT DO NOTHING")
+	}
+}
+
+// upsertInsertConflictCallback 新增语句未写入记录时标记冲突，跳过后续的新增回调
+func upsertInsertConflictCallback(scope *gorm.Scope) {
+	if v, ok := scope.Get(upsertInsertKey); !ok || v != scope.Value {
+		return
+	}
+	// 避免关联记录的写入沿用冲突处理
+	scope.Set("gorm:insert_option", "")
+	db := scope.DB()
+	// PostgreSQL冲突时RETURNING无返回行
+	if db.RowsAffected == 0 && (db.Error == nil || db.Error == sql.ErrNoRows) {
+		db.Error = ErrUpsertConflict
+	}
+}
+
+// NativeUpsertDocs 使用INSERT ... ON DUPLICATE KEY UPDATE（MySQL）或ON CONFLICT（PostgreSQL、SQLite）批量写入，
+// 只写入请求中出现的字段和自动维护的字段（时间、创建人、修改人、组织、UUID），不执行业务回调和数据权限控制
+func NativeUpsertDocs(tx *gorm.DB, reflectType reflect.Type, params UpsertParams) ([]UpsertResult, error) {
+	dialect := tx.Dialect().GetName()
+	if dialect != "mysql" && dialect != "postgres" && dialect != "sqlite3" {
+		return nil, fmt.Errorf("%w: %s", ErrUpsertNativeUnsupported, dialect)
+	}
+	scope := tx.NewScope(reflect.New(reflectType).Interface())
+	keys, err := upsertKeyFields(scope, params.Keys)
+	if err != nil {
+		return nil, err
+	}
+	// kuu_unique索引包含删除标记，冲突目标需与索引一致
+	conflictColumns := make([]string, 0, len(keys)+1)
+	keyColumns := make(map[string]bool)
+	for _, key := range keys {
+		conflictColumns = append(conflictColumns, key.DBName)
+		keyColumns[key.DBName] = true
+	}
+	if field, ok := scope.FieldByName("Dr"); ok && !keyColumns[field.DBName] && len(params.Keys) == 0 {
+		conflictColumns = append(conflictColumns, field.DBName)
+		keyColumns[field.DBName] = true
+	}
+
+	// 按字段组合分组，避免未提供的字段被零值覆盖
+	var (
+		now     = tsNow()
+		desc    = GetRoutinePrivilegesDesc()
+		groups  = make(map[string][]int)
+		columns = make(map[string][]*gorm.StructField)
+		keeps   = make(map[string]map[string]bool)
+		order   []string
+	)
+	for i, raw := range params.Docs {
+		fields, keep := nativeUpsertFields(scope, raw, keyColumns)
+		names := make([]string, len(fields))
+		for j, field := range fields {
+			names[j] = field.DBName
+			if keep[field.DBName] {
+				names[j] += "!"
+			}
+		}
+		signature := strings.Join(names, ",")
+		if _, ok := groups[signature]; !ok {
+			order = append(order, signature)
+			columns[signature] = fields
+			keeps[signature] = keep
+		}
+		groups[signature] = append(groups[signature], i)
+	}
+	results := make([]UpsertResult, len(params.Docs))
+	for _, signature := range order {
+		var (
+			fields = columns[signature]
+			keep   = keeps[signature]
+			names  = make([]string, len(fields))
+		)
+		for i, field := range fields {
+			names[i] = field.DBName
+		}
+		batchSize := 1000
+		if dialect == "sqlite3" {
+			// SQLite默认最多999个参数
+			if batchSize = 999 / len(fields); batchSize < 1 {
+				batchSize = 1
+			}
+		}
+		indexes := groups[signature]
+		for start := 0; start < len(indexes); start += batchSize {
+			end := start + batchSize
+			if end > len(indexes) {
+				end = len(indexes)
+			}
+			var (
+				placeholders []string
+				vars         []interface{}
+			)
+			for _, index := range indexes[start:end] {
+				doc := reflect.New(reflectType).Interface()
+				if err := Copy(params.Docs[index], doc); err != nil {
+					return results, fmt.Errorf("row %d: %w", index, err)
+				}
+				docScope := tx.NewScope(doc)
+				if err := fillNativeUpsertFields(docScope, desc); err != nil {
+					return results, fmt.Errorf("row %d: %w", index, err)
+				}
+				marks := make([]string, len(fields))
+				for j, field := range fields {
+					marks[j] = "?"
+					vars = append(vars, nativeUpsertValue(docScope, field, now))
+				}
+				placeholders = append(placeholders, "("+strings.Join(marks, ",")+")")
+				results[index] = UpsertResult{Index: index, Action: UpsertActionUpserted}
+			}
+			sql := buildNativeUpsertSQL(dialect, scope.Quote, scope.QuotedTableName(), names, conflictColumns, keep, placeholders)
+			if err := tx.Exec(sql, vars...).Error; err != nil {
+				return results, err
+			}
+		}
+	}
+	return results, nil
+}
+
+// nativeUpsertFields 原生写入的字段：请求中出现的字段、自然键和自动维护的字段，
+// keep为冲突时保持原值的字段：自然键、创建时间、创建人、UUID，以及请求中未提供的组织
+func nativeUpsertFields(scope *gorm.Scope, raw map[string]interface{}, keyColumns map[string]bool) (fields []*gorm.StructField, keep map[string]bool) {
+	var (
+		auto      = map[string]bool{"CreatedAt": true, "UpdatedAt": true, "Ts": true, "Dr": true, "CreatedByID": true, "UpdatedByID": true, "OrgID": true}
+		uuidField string
+	)
+	if meta := Meta(scope.Value); meta != nil {
+		uuidField = meta.TagSettings["UUID"]
+	}
+	keep = make(map[string]bool)
+	for _, field := range scope.GetModelStruct().StructFields {
+		if !field.IsNormal || field.IsIgnored || field.IsPrimaryKey {
+			continue
+		}
+		_, provided := raw[field.Name]
+		if !provided && !keyColumns[field.DBName] && !auto[field.Name] && field.Name != uuidField {
+			continue
+		}
+		fields = append(fields, field)
+		switch {
+		case keyColumns[field.DBName], field.Name == "CreatedAt", field.Name == "CreatedByID", field.Name == uuidField:
+			keep[field.DBName] = true
+		case field.Name == "OrgID":
+			keep[field.DBName] = !provided
+		}
+	}
+	sort.SliceStable(fields, func(i, j int) bool {
+		return fields[i].DBName < fields[j].DBName
+	})
+	return
+}
+
+// fillNativeUpsertFields 原生写入前填充UUID、创建人、修改人和组织，与uuidCreateCallback、createCallback一致
+func fillNativeUpsertFields(scope *gorm.Scope, desc *PrivilegesDesc) error {
+	if meta := Meta(scope.Value); meta != nil && meta.TagSettings["UUID"] != "" {
+		if field, ok := scope.FieldByName(meta.TagSettings["UUID"]); ok && field.IsBlank {
+			if err := field.Set(strings.ReplaceAll(uuid.NewV4().String(), "-", "")); err != nil {
+				return err
+			}
+		}
+	}
+	if desc == nil {
+		return nil
+	}
+	for _, name := range []string{"CreatedByID", "UpdatedByID"} {
+		if field, ok := scope.FieldByName(name); ok {
+			if err := field.Set(desc.UID); err != nil {
+				return err
+			}
+		}
+	}
+	if field, ok := scope.FieldByName("OrgID"); ok && field.IsBlank && desc.ActOrgID != 0 {
+		if err := field.Set(desc.ActOrgID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nativeUpsertValue 原生写入时的字段值，自动维护的时间字段取当前时间
+func nativeUpsertValue(scope *gorm.Scope, structField *gorm.StructField, now time.Time) interface{} {
+	field, _ := scope.FieldByName(structField.Name)
+	switch structField.Name {
+	case "UpdatedAt", "Ts":
+		return now
+	case "CreatedAt":
+		if field.IsBlank {
+			return now
+		}
+	}
+	return field.Field.Interface()
+}
+
+// buildNativeUpsertSQL 生成原生批量写入语句，自然键和创建时间不会被更新
+func buildNativeUpsertSQL(dialect string, quote func(string) string, table string, columns, conflictColumns []string, keep map[string]bool, placeholders []string) string {
+	var (
+		names   = make([]string, len(columns))
+		updates []string
+	)
+	for i, column := range columns {
+		names[i] = quote(column)
+		if keep[column] {
+			continue
+		}
+		if dialect == "mysql" {
+			updates = append(updates, fmt.Sprintf("%s=VALUES(%s)", names[i], names[i]))
+		} else {
+			updates = append(updates, fmt.Sprintf("%s=EXCLUDED.%s", names[i], names[i]))
+		}
+	}
+	sql := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", table, strings.Join(names, ","), strings.Join(placeholders, ","))
+	if dialect == "mysql" {
+		if len(updates) == 0 {
+			// 无可更新字段时保持原值
+			first := quote(conflictColumns[0])
+			updates = append(updates, fmt.Sprintf("%s=%s", first, first))
+		}
+		return sql + " ON DUPLICATE KEY UPDATE " + strings.Join(updates, ",")
+	}
+	quoted := make([]string, len(conflictColumns))
+	for i, column := range conflictColumns {
+		quoted[i] = quote(column)
+	}
+	if len(updates) == 0 {
+		return fmt.Sprintf("%s ON CONFLICT (%s) DO NOTHING", sql, strings.Join(quoted, ","))
+	}
+	return fmt.Sprintf("%s ON CONFLICT (%s) DO UPDATE SET %s", sql, strings.Join(quoted, ","), strings.Join(updates, ","))
+}
+
+func restUpsertHandler(reflectType reflect.Type) HandlerFunc {
+	return func(c *Context) *STDReply {
+		var (
+			params  UpsertParams
+			results []UpsertResult
+		)
+		if err := c.ShouldBindBodyWith(&params, binding.JSON); err != nil {
+			return c.STDErr(err, "rest_upsert_failed", "Upsert failed")
+		}
+		if params.Native && c.SignInfo.UID != RootUID() {
+			return c.STDErr(fmt.Errorf("native upsert is only allowed for root: uid=%v", c.SignInfo.UID), "rest_upsert_failed", "Upsert failed")
+		}
+		err := c.WithModelTransaction(reflect.New(reflectType).Interface(), func(tx *gorm.DB) (err error) {
+			if params.Native {
+				results, err = NativeUpsertDocs(tx, reflectType, params)
+			} else {
+				results, err = UpsertDocs(c, tx, reflectType, params)
+			}
+			return
+		})
+		if err != nil {
+			return c.STDErr(err, "rest_upsert_failed", "Upsert failed")
+		}
+		return c.STD(results)
+	}
+}
@@ -0,0 +1,109 @@
+package kuu
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBuildNativeUpsertSQL(t *testing.T) {
+	quote := func(s string) string { return `"` + s + `"` }
+	var (
+		columns      = []string{"code", "created_at", "dr", "name"}
+		conflict     = []string{"code", "dr"}
+		keep         = map[string]bool{"code": true, "created_at": true, "dr": true}
+		placeholders = []string{"(?,?,?,?)", "(?,?,?,?)"}
+	)
+	sql := buildNativeUpsertSQL("postgres", quote, `"role"`, columns, conflict, keep, placeholders)
+	expected := `INSERT INTO "role" ("code","created_at","dr","name") VALUES (?,?,?,?),(?,?,?,?) ON CONFLICT ("code","dr") DO UPDATE SET "name"=EXCLUDED."name"`
+	if sql != expected {
+		t.Errorf("unexpected postgres sql: %s", sql)
+	}
+	sql = buildNativeUpsertSQL("mysql", quote, `"role"`, columns, conflict, keep, placeholders)
+	expected = `INSERT INTO "role" ("code","created_at","dr","name") VALUES (?,?,?,?),(?,?,?,?) ON DUPLICATE KEY UPDATE "name"=VALUES("name")`
+	if sql != expected {
+		t.Errorf("unexpected mysql sql: %s", sql)
+	}
+	sql = buildNativeUpsertSQL("sqlite3", quote, `"role"`, columns[:3], conflict, keep, []string{"(?,?,?)"})
+	expected = `INSERT INTO "role" ("code","created_at","dr") VALUES (?,?,?) ON CONFLICT ("code","dr") DO NOTHING`
+	if sql != expected {
+		t.Errorf("unexpected sqlite sql: %s", sql)
+	}
+}
+
+type testUpsertItem struct {
+	ID          uint   `gorm:"primary_key"`
+	Code        string `gorm:"UNIQUE_INDEX"`
+	Name        string
+	UID         string `kuu:"UUID:UID"`
+	CreatedByID uint
+	UpdatedByID uint
+	OrgID       uint
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func TestUpsertDocsConcurrentInsert(t *testing.T) {
+	db := testSQLiteDB(t, "")
+	registerDBCallbacks(db)
+	parseMetadata(&testUpsertItem{})
+	if err := db.AutoMigrate(&testUpsertItem{}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟查询之后、新增之前被并发写入
+	DefaultCallback.Create().Before("kuu:biz_before_create").Register("test:upsert_race", func(scope *Scope) {
+		if item, ok := scope.Value.(*testUpsertItem); ok && item.Code == "c2" {
+			scope.DB.Exec("INSERT INTO test_upsert_items (code, name) VALUES (?, ?)", "c2", "other")
+		}
+	})
+	defer DefaultCallback.Create().Remove("test:upsert_race")
+
+	params := UpsertParams{
+		Keys: []string{"Code"},
+		Docs: []map[string]interface{}{{"Code": "c1", "Name": "n1"}, {"Code": "c2", "Name": "n2"}},
+	}
+	results, err := UpsertDocs(testContext("POST", "/api/testupsertitem/upsert", ""), db, reflect.TypeOf(testUpsertItem{}), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Action != UpsertActionCreated || results[1].Action != UpsertActionUpdated {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	var items []testUpsertItem
+	db.Order("code").Find(&items)
+	if len(items) != 2 || items[1].ID != results[1].ID || items[1].Name != "n2" {
+		t.Errorf("unexpected items: %+v", items)
+	}
+}
+
+func TestNativeUpsertAutoFields(t *testing.T) {
+	scope := testSQLiteDB(t, "").NewScope(&testUpsertItem{})
+	parseMetadata(&testUpsertItem{})
+
+	fields, keep := nativeUpsertFields(scope, map[string]interface{}{"Code": "c1", "Name": "n1"}, map[string]bool{"code": true})
+	names := make([]string, len(fields))
+	for i, field := range fields {
+		names[i] = field.DBName
+	}
+	expected := []string{"code", "created_at", "created_by_id", "name", "org_id", "uid", "updated_at", "updated_by_id"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("unexpected fields: %v", names)
+	}
+	for _, name := range []string{"code", "created_at", "created_by_id", "org_id", "uid"} {
+		if !keep[name] {
+			t.Errorf("%s should be kept on conflict", name)
+		}
+	}
+	if _, keep = nativeUpsertFields(scope, map[string]interface{}{"Code": "c1", "OrgID": 3}, map[string]bool{"code": true}); keep["org_id"] {
+		t.Error("provided org_id should be updated on conflict")
+	}
+
+	item := testUpsertItem{OrgID: 3}
+	if err := fillNativeUpsertFields(scope.New(&item), &PrivilegesDesc{UID: 2, ActOrgID: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if item.CreatedByID != 2 || item.UpdatedByID != 2 || item.OrgID != 3 || len(item.UID) != 32 {
+		t.Errorf("unexpected auto fields: %+v", item)
+	}
+}